@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edibez/priceforagent/internal/ai"
+	"github.com/edibez/priceforagent/internal/aliases"
+	"github.com/edibez/priceforagent/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	aliasStore     *aliases.Store
+	aliasVersionMu sync.Mutex
+	aliasVersion   int64 = -1
+)
+
+// aliasReloadInterval is how often replicas check the dictionary for
+// changes made through another replica
+var aliasReloadInterval = 30 * time.Second
+
+// openAliasStore keeps the alias dictionary in the key store's
+// database, sharing its connections. With the in-memory key store it is
+// kept in memory too, so no database file is needed; admin changes to
+// it are lost on restart.
+func openAliasStore(keys auth.KeyStore) (*aliases.Store, error) {
+	if sqlite, ok := keys.(*auth.Store); ok {
+		return aliases.OpenDB(sqlite.DB())
+	}
+	log.Printf("Using the in-memory alias dictionary; alias changes are lost on restart")
+	return aliases.NewMemoryStore()
+}
+
+// seedEntries turns the built-in dictionary into store rows
+func seedEntries(d ai.Dictionary) []aliases.Entry {
+	var entries []aliases.Entry
+	for lang, words := range d.Aliases {
+		for word, asset := range words {
+			entries = append(entries, aliases.Entry{Kind: aliases.KindAlias, Term: word, Value: asset, Lang: lang})
+		}
+	}
+	for asset, class := range d.Classes {
+		entries = append(entries, aliases.Entry{Kind: aliases.KindClass, Term: asset, Value: string(class)})
+	}
+	for word := range d.Blocked {
+		entries = append(entries, aliases.Entry{Kind: aliases.KindBlock, Term: word})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Lang != b.Lang {
+			return a.Lang < b.Lang
+		}
+		return a.Term < b.Term
+	})
+	return entries
+}
+
+// startAliasReload loads the dictionary now and whenever another replica
+// changes it
+func startAliasReload(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(aliasReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			reloadAliases(false)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// reloadAliases loads the store into the parser if it changed since the
+// last load, or always when force is set
+func reloadAliases(force bool) {
+	aliasVersionMu.Lock()
+	defer aliasVersionMu.Unlock()
+
+	version, err := aliasStore.Version()
+	if err != nil {
+		log.Printf("Alias reload failed: %v", err)
+		return
+	}
+	if !force && version == aliasVersion {
+		return
+	}
+
+	entries, err := aliasStore.List("", "")
+	if err != nil {
+		log.Printf("Alias reload failed: %v", err)
+		return
+	}
+	d := ai.Dictionary{
+		Aliases: make(map[string]map[string]string),
+		Classes: make(map[string]ai.AssetClass),
+		Blocked: make(map[string]bool),
+	}
+	for _, e := range entries {
+		switch e.Kind {
+		case aliases.KindAlias:
+			if d.Aliases[e.Lang] == nil {
+				d.Aliases[e.Lang] = make(map[string]string)
+			}
+			d.Aliases[e.Lang][e.Term] = e.Value
+		case aliases.KindClass:
+			d.Classes[e.Term] = ai.AssetClass(e.Value)
+		case aliases.KindBlock:
+			d.Blocked[e.Term] = true
+		}
+	}
+	ai.LoadDictionary(d)
+	aliasVersion = version
+	log.Printf("Alias dictionary loaded: %d entries", len(entries))
+}
+
+// AliasRequest creates or updates a dictionary entry
+type AliasRequest struct {
+	Kind  string `json:"kind"`
+	Term  string `json:"term"`
+	Value string `json:"value"`
+	Lang  string `json:"lang"`
+}
+
+// normalize validates an entry and puts it in stored form: words in
+// lowercase, assets in uppercase
+func (r AliasRequest) normalize() (aliases.Entry, error) {
+	e := aliases.Entry{Kind: strings.ToLower(r.Kind), Term: strings.TrimSpace(r.Term), Value: strings.TrimSpace(r.Value)}
+	if e.Term == "" {
+		return e, errors.New("term is required")
+	}
+
+	switch e.Kind {
+	case aliases.KindAlias:
+		e.Term = strings.ToLower(e.Term)
+		e.Value = strings.ToUpper(e.Value)
+		e.Lang = strings.ToLower(r.Lang)
+		if e.Lang == "" {
+			e.Lang = "en"
+		}
+		if _, ok := ai.Languages[e.Lang]; !ok {
+			return e, errors.New("unsupported lang")
+		}
+		if e.Value == "" {
+			return e, errors.New("value (asset) is required")
+		}
+	case aliases.KindClass:
+		e.Term = strings.ToUpper(e.Term)
+		e.Value = strings.ToLower(e.Value)
+		if !ai.AssetClass(e.Value).Valid() {
+			return e, errors.New("value must be an asset class")
+		}
+	case aliases.KindBlock:
+		e.Term = strings.ToLower(e.Term)
+		e.Value = ""
+	default:
+		return e, errors.New("kind must be alias, class or block")
+	}
+	return e, nil
+}
+
+func handleAdminListAliases(c *gin.Context) {
+	entries, err := aliasStore.List(c.Query("kind"), c.Query("lang"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if q := strings.ToLower(c.Query("q")); q != "" {
+		filtered := entries[:0]
+		for _, e := range entries {
+			if strings.Contains(strings.ToLower(e.Term), q) || strings.Contains(strings.ToLower(e.Value), q) {
+				filtered = append(filtered, e)
+			}
+		}
+		entries = filtered
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count":   len(entries),
+		"aliases": entries,
+	})
+}
+
+func handleAdminCreateAlias(c *gin.Context) {
+	var req AliasRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	e, err := req.normalize()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := aliasStore.Create(e)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			c.JSON(http.StatusConflict, gin.H{"error": "alias already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	reloadAliases(true)
+	c.JSON(http.StatusCreated, created)
+}
+
+func handleAdminUpdateAlias(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	existing, err := aliasStore.Get(id)
+	if errors.Is(err, aliases.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req AliasRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	// Only the value changes; kind, term and lang identify the entry
+	e, err := AliasRequest{Kind: existing.Kind, Term: existing.Term, Value: req.Value, Lang: existing.Lang}.normalize()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := aliasStore.Update(id, e.Value)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	reloadAliases(true)
+	c.JSON(http.StatusOK, updated)
+}
+
+func handleAdminDeleteAlias(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := aliasStore.Delete(id); err != nil {
+		if errors.Is(err, aliases.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	reloadAliases(true)
+	c.JSON(http.StatusOK, gin.H{"deleted": id})
+}
+
+func handleAdminAliasHistory(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+	changes, err := aliasStore.History(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"count":   len(changes),
+		"history": changes,
+	})
+}
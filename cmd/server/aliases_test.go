@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/edibez/priceforagent/internal/ai"
+	"github.com/edibez/priceforagent/internal/aliases"
+)
+
+// parsesAs reports whether text parses to asset alone
+func parsesAs(text, asset string) bool {
+	q := ai.Parse(text)
+	return len(q.Matches) == 1 && q.Matches[0].Asset == asset
+}
+
+// eventually polls cond until it holds or a second passes
+func eventually(cond func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestAliasReload(t *testing.T) {
+	store, err := aliases.NewMemoryStore()
+	if err != nil {
+		t.Fatalf("NewMemoryStore: %v", err)
+	}
+	if err := store.Seed(seedEntries(ai.SeedDictionary())); err != nil {
+		t.Fatalf("Seed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	aliasStore, aliasVersion, aliasReloadInterval = store, -1, 10*time.Millisecond
+	t.Cleanup(func() {
+		cancel()
+		aliasVersionMu.Lock()
+		aliasStore, aliasVersion, aliasReloadInterval = nil, -1, 30*time.Second
+		aliasVersionMu.Unlock()
+		ai.LoadDictionary(ai.SeedDictionary())
+		store.Close()
+	})
+
+	startAliasReload(ctx)
+	if !eventually(func() bool { return parsesAs("gold price", "XAU") }) {
+		t.Fatal("seeded dictionary never loaded")
+	}
+	if parsesAs("kopikoin price", "DOGE") {
+		t.Fatal("kopikoin is an alias before it was added")
+	}
+
+	// Changes written by another replica reach this one on the next poll
+	e, err := store.Create(aliases.Entry{Kind: aliases.KindAlias, Term: "kopikoin", Value: "DOGE", Lang: "en"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if !eventually(func() bool { return parsesAs("kopikoin price", "DOGE") }) {
+		t.Error("created alias was not picked up")
+	}
+	if _, err := store.Update(e.ID, "SHIB"); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if !eventually(func() bool { return parsesAs("kopikoin price", "SHIB") }) {
+		t.Error("updated alias was not picked up")
+	}
+	if err := store.Delete(e.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if !eventually(func() bool { return !parsesAs("kopikoin price", "SHIB") }) {
+		t.Error("deleted alias was still parsed")
+	}
+
+	aliasVersionMu.Lock()
+	loaded := aliasVersion
+	aliasVersionMu.Unlock()
+	if version, _ := store.Version(); loaded != version {
+		t.Errorf("loaded version = %d, want the store's %d", loaded, version)
+	}
+}
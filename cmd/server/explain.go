@@ -0,0 +1,114 @@
+package main
+
+import (
+	"time"
+
+	"github.com/edibez/priceforagent/internal/ai"
+	"github.com/gin-gonic/gin"
+)
+
+// Trace records how a request was answered, for ?explain=true. A nil
+// trace records nothing, so callers can pass it around unconditionally.
+type Trace struct {
+	Steps   []TraceStep `json:"steps"`
+	TotalMs float64     `json:"total_ms"`
+
+	start time.Time
+}
+
+// TraceStep is one timed step of a trace
+type TraceStep struct {
+	Step       string  `json:"step"`
+	DurationMs float64 `json:"duration_ms"`
+	Detail     gin.H   `json:"detail,omitempty"`
+}
+
+// newTrace starts a trace when the request asks for one
+func newTrace(c *gin.Context) *Trace {
+	if c.Query("explain") != "true" {
+		return nil
+	}
+	return &Trace{start: time.Now()}
+}
+
+// add records a step that began at start
+func (t *Trace) add(step string, start time.Time, detail gin.H) {
+	if t == nil {
+		return
+	}
+	t.Steps = append(t.Steps, TraceStep{Step: step, DurationMs: millis(time.Since(start)), Detail: detail})
+}
+
+// done stamps the total time and returns the trace
+func (t *Trace) done() *Trace {
+	if t == nil {
+		return nil
+	}
+	t.TotalMs = millis(time.Since(t.start))
+	return t
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// traceParse records what the query parser found
+func (t *Trace) traceParse(start time.Time, q ai.Query) {
+	if t == nil {
+		return
+	}
+	matches := make([]gin.H, 0, len(q.Matches))
+	aliases := gin.H{}
+	for _, m := range q.Matches {
+		matches = append(matches, gin.H{
+			"text":      m.Text,
+			"asset":     m.Asset,
+			"via":       m.Via,
+			"class":     m.Class,
+			"market":    m.Market,
+			"ambiguous": m.Ambiguous,
+		})
+		if m.Via == ai.ViaAlias {
+			aliases[m.Text] = m.Asset
+		}
+	}
+	t.add("parse", start, gin.H{
+		"tokens":  ai.Tokens(q.Text),
+		"lang":    q.Lang,
+		"parser":  q.Parser,
+		"intent":  q.Intent,
+		"quote":   q.Quote,
+		"venue":   q.Venue,
+		"matches": matches,
+		"aliases": aliases,
+	})
+}
+
+// traceResolve records how a target became a source code
+func (t *Trace) traceResolve(start time.Time, target ai.Target, res ai.Resolution) {
+	if t == nil {
+		return
+	}
+	class := target.Class
+	if class == "" {
+		class = ai.ClassOfType(ai.ParseCode(res.Code).Type)
+	}
+	t.add("resolve", start, gin.H{
+		"asset":         res.Asset,
+		"class":         class,
+		"built_code":    ai.DefaultCode(target),
+		"code":          res.Code,
+		"listed":        res.Listed,
+		"catalog_pairs": catalog.Len(),
+		"invert":        res.Invert,
+		"convert":       res.Convert,
+	})
+}
+
+// withExplain adds the finished trace to a response when there is one
+func withExplain(h gin.H, tr *Trace) gin.H {
+	if tr != nil {
+		h["explain"] = tr.done()
+	}
+	return h
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/edibez/priceforagent/internal/ai"
+	"github.com/gin-gonic/gin"
+)
+
+// Why a result has no 24h change
+const (
+	changeUnsupported = "24h change is only available for crypto assets"
+	changeUnranked    = "24h change is only available for the top 100 coins"
+)
+
+// attachChange fills 24h change from CoinGecko rankings, which list the
+// top 100 coins only, so other results get a note instead. Coins are
+// matched on the base of the resolved code, never for other classes.
+// It returns a note when no result got a change.
+func attachChange(results []PriceResponse) string {
+	note := ""
+	for i := range results {
+		parts := ai.ParseCode(results[i].Code)
+		if ai.ClassOfType(parts.Type) != ai.ClassCrypto {
+			results[i].ChangeNote = changeUnsupported
+			note = changeUnsupported
+			continue
+		}
+		coin, err := rankingClient.FindSymbol(parts.Base)
+		if err != nil {
+			results[i].ChangeNote = changeUnranked
+			if note == "" {
+				note = changeUnranked
+			}
+			continue
+		}
+		change := coin.PriceChange24h
+		results[i].Change24h = &change
+	}
+	for _, r := range results {
+		if r.Change24h != nil {
+			return ""
+		}
+	}
+	return note
+}
+
+// compareResults orders assets by the query's metric; the leader is
+// the answer to "which is cheaper" or "is ETH up more than SOL"
+func compareResults(q ai.Query, results []PriceResponse) gin.H {
+	type entry struct {
+		Asset string  `json:"asset"`
+		Value float64 `json:"value"`
+	}
+
+	var ranked []entry
+	for _, r := range results {
+		switch q.Metric {
+		case ai.MetricChange:
+			if r.Change24h == nil {
+				continue
+			}
+			ranked = append(ranked, entry{Asset: r.Pair, Value: *r.Change24h})
+		default:
+			ranked = append(ranked, entry{Asset: r.Pair, Value: r.Price})
+		}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if q.Order == "asc" {
+			return ranked[i].Value < ranked[j].Value
+		}
+		return ranked[i].Value > ranked[j].Value
+	})
+
+	comparison := gin.H{
+		"metric":  q.Metric,
+		"order":   q.Order,
+		"ranking": ranked,
+	}
+	if len(ranked) > 0 {
+		comparison["leader"] = ranked[0].Asset
+	}
+	if len(ranked) < len(results) {
+		comparison["note"] = "some assets have no " + q.Metric + " data"
+	}
+	return comparison
+}
+
+// ratioOf prices the first asset of a ratio query in units of the
+// second. A single asset priced in the requested quote ("ETH in BTC")
+// is its own ratio. Results are found by the match they priced, and
+// there is no ratio unless every asset of the ratio was priced.
+func ratioOf(q ai.Query, results []PriceResponse) (gin.H, bool) {
+	if len(q.Matches) == 1 {
+		base, ok := resultFor(results, q.Matches[0])
+		if !ok || q.Quote == "" || !strings.EqualFold(base.Currency, q.Quote) {
+			return nil, false
+		}
+		return gin.H{
+			"base":  base.Pair,
+			"quote": base.Currency,
+			"value": base.Price,
+		}, true
+	}
+	if len(q.Matches) != 2 {
+		return nil, false
+	}
+	base, ok := resultFor(results, q.Matches[0])
+	if !ok {
+		return nil, false
+	}
+	quote, ok := resultFor(results, q.Matches[1])
+	if !ok || quote.Price == 0 {
+		return nil, false
+	}
+	return gin.H{
+		"base":  base.Pair,
+		"quote": quote.Pair,
+		"value": base.Price / quote.Price,
+	}, true
+}
+
+// resultFor returns the result priced for a match
+func resultFor(results []PriceResponse, m ai.Match) (PriceResponse, bool) {
+	for _, r := range results {
+		if r.Match != nil && r.Match.Start == m.Start && r.Match.Asset == m.Asset {
+			return r, true
+		}
+	}
+	return PriceResponse{}, false
+}
+
+// handleQueryCode prices an exact source code passed by a caller
+// resolving an ambiguous query
+func handleQueryCode(c *gin.Context, req QueryRequest) {
+	parts := ai.ParseCode(req.Code)
+	res := ai.Resolution{Asset: parts.Base, Code: req.Code, Quote: parts.Quote, Listed: catalog.Has(req.Code)}
+	if denial := scopeDenial(requestKey(c), req.Code); denial != "" {
+		c.JSON(http.StatusForbidden, scopeError(req.Code, denial))
+		return
+	}
+
+	resp, ok := priceResolution(res, nil)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "pair not found", "code": req.Code})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":               req.Query,
+		"intent":              ai.IntentPrice,
+		"results":             []PriceResponse{*resp},
+		"needs_clarification": false,
+	})
+}
+
+// supportedLanguages lists the query language codes, sorted
+func supportedLanguages() []string {
+	codes := make([]string, 0, len(ai.Languages))
+	for code := range ai.Languages {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/edibez/priceforagent/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// Self-service key management. A key holder sees their own key and the
+// child keys it issued, and can manage only those.
+
+// ChildKeyRequest issues a child key
+type ChildKeyRequest struct {
+	Label  string               `json:"label"`
+	Scopes ScopesRequest        `json:"scopes"`
+	Limits *auth.LimitOverrides `json:"limits"`
+}
+
+// KeyLabelRequest renames a key
+type KeyLabelRequest struct {
+	Label string `json:"label"`
+}
+
+func handleListOwnKeys(c *gin.Context) {
+	caller := c.MustGet("api_key").(*auth.APIKey)
+	children, err := authStore.ListChildren(caller.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if children == nil {
+		children = []auth.APIKey{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"key":      caller,
+		"children": children,
+		"count":    len(children),
+	})
+}
+
+func handleCreateChildKey(c *gin.Context) {
+	caller := c.MustGet("api_key").(*auth.APIKey)
+	var req ChildKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	scopes, err := req.Scopes.normalize()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Limits != nil && *req.Limits == (auth.LimitOverrides{}) {
+		req.Limits = nil
+	}
+
+	child, err := authStore.CreateChild(caller, strings.TrimSpace(req.Label), scopes, req.Limits)
+	switch {
+	case errors.Is(err, auth.ErrNestedChild):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, auth.ErrWiderScopes), errors.Is(err, auth.ErrHigherLimits):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+	c.JSON(http.StatusCreated, child)
+}
+
+func handleLabelKey(c *gin.Context) {
+	var req KeyLabelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if _, ok := ownedKey(c, true); !ok {
+		return
+	}
+	withKeyID(c, func(id int64) error { return authStore.SetLabel(id, strings.TrimSpace(req.Label)) })
+}
+
+func handleRevokeChildKey(c *gin.Context) {
+	var req KeyRevokeRequest
+	c.ShouldBindJSON(&req)
+	if _, ok := ownedKey(c, false); !ok {
+		return
+	}
+	withKeyID(c, func(id int64) error { return authStore.Revoke(id, strings.TrimSpace(req.Reason)) })
+}
+
+func handleOwnKeyUsage(c *gin.Context) {
+	key, ok := ownedKey(c, true)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, keyUsage(context.Background(), key))
+}
+
+// ownedKey loads the key named in the path if the caller issued it, or
+// is it when self is set. Other keys are reported as not found.
+func ownedKey(c *gin.Context, self bool) (*auth.APIKey, bool) {
+	caller := c.MustGet("api_key").(*auth.APIKey)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key id"})
+		return nil, false
+	}
+
+	key, err := authStore.GetKey(id)
+	if err != nil && !errors.Is(err, auth.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if key == nil || !(key.ParentID == caller.ID || self && key.ID == caller.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": auth.ErrNotFound.Error()})
+		return nil, false
+	}
+	return key, true
+}
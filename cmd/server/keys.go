@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edibez/priceforagent/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// keyError maps a key validation error to a status, message and code
+// clients can act on
+func keyError(err error) (int, gin.H) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidKey):
+		return http.StatusUnauthorized, gin.H{"error": "Invalid API key", "code": "invalid_key"}
+	case errors.Is(err, auth.ErrKeyDisabled):
+		return http.StatusForbidden, gin.H{"error": "API key is disabled", "code": "key_disabled"}
+	case errors.Is(err, auth.ErrKeyRevoked):
+		return http.StatusUnauthorized, gin.H{"error": "API key has been revoked", "code": "key_revoked"}
+	case errors.Is(err, auth.ErrKeyExpired):
+		return http.StatusUnauthorized, gin.H{"error": "API key has expired", "code": "key_expired"}
+	case errors.Is(err, auth.ErrSigningDisabled):
+		return http.StatusServiceUnavailable, gin.H{"error": "Request signing is not enabled on this server", "code": "signing_disabled"}
+	}
+	return http.StatusInternalServerError, gin.H{"error": "Failed to validate API key", "code": "internal_error"}
+}
+
+// plainRequestKey checks the key sent in X-API-Key or api_key and
+// returns it, or the status and body to refuse the request with
+func plainRequestKey(c *gin.Context) (*auth.APIKey, int, gin.H) {
+	key := c.GetHeader("X-API-Key")
+	if key == "" {
+		key = c.Query("api_key")
+	}
+	if key == "" {
+		return nil, http.StatusUnauthorized, gin.H{"error": "API key required", "code": "missing_key"}
+	}
+
+	apiKey, err := authStore.ValidateKey(key)
+	if err != nil {
+		status, body := keyError(err)
+		return nil, status, body
+	}
+	if apiKey.RequireSignature {
+		return nil, http.StatusUnauthorized, gin.H{
+			"error": "This API key only accepts signed requests",
+			"code":  "signature_required",
+		}
+	}
+	return apiKey, 0, nil
+}
+
+// Key rotation grace periods: the default, set by KEY_ROTATION_GRACE,
+// and the longest a caller may ask for
+var (
+	rotationGrace    = 24 * time.Hour
+	maxRotationGrace = 30 * 24 * time.Hour
+)
+
+// KeyRotateRequest optionally sets how long the old key keeps working
+type KeyRotateRequest struct {
+	Grace string `json:"grace"` // duration such as "72h"
+}
+
+// grace returns the requested grace period, or the default
+func (r KeyRotateRequest) grace() (time.Duration, error) {
+	if r.Grace == "" {
+		return rotationGrace, nil
+	}
+	d, err := time.ParseDuration(r.Grace)
+	if err != nil || d < 0 || d > maxRotationGrace {
+		return 0, errors.New("grace must be a duration between 0s and 720h")
+	}
+	return d, nil
+}
+
+// handleRotateKey replaces the calling key with a successor
+func handleRotateKey(c *gin.Context) {
+	key := c.MustGet("api_key").(*auth.APIKey)
+	rotateKey(c, key.ID)
+}
+
+func handleAdminRotateKey(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key id"})
+		return
+	}
+	rotateKey(c, id)
+}
+
+func rotateKey(c *gin.Context, id int64) {
+	var req KeyRotateRequest
+	c.ShouldBindJSON(&req)
+	grace, err := req.grace()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	successor, err := authStore.Rotate(id, grace)
+	switch {
+	case errors.Is(err, auth.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, auth.ErrKeyRevoked), errors.Is(err, auth.ErrAlreadyRotated):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	old, _ := authStore.GetKey(id)
+	response := gin.H{
+		"api_key":    successor.Key,
+		"key_id":     successor.ID,
+		"key_prefix": successor.Prefix,
+		"replaces":   id,
+		"message":    "Switch to the new key before the old one expires.",
+	}
+	if old != nil {
+		response["old_key_expires_at"] = old.ExpiresAt
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// KeyRevokeRequest is the body of a revocation
+type KeyRevokeRequest struct {
+	Reason string `json:"reason"`
+}
+
+// KeyExpiryRequest sets or, with a null expires_at, clears an expiry
+type KeyExpiryRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func handleAdminGetKey(c *gin.Context) {
+	withKeyID(c, func(id int64) error { return nil })
+}
+
+func handleAdminDisableKey(c *gin.Context) {
+	withKeyID(c, func(id int64) error { return authStore.SetStatus(id, auth.StatusDisabled) })
+}
+
+func handleAdminEnableKey(c *gin.Context) {
+	withKeyID(c, func(id int64) error { return authStore.SetStatus(id, auth.StatusActive) })
+}
+
+func handleAdminRevokeKey(c *gin.Context) {
+	var req KeyRevokeRequest
+	c.ShouldBindJSON(&req)
+	withKeyID(c, func(id int64) error { return authStore.Revoke(id, strings.TrimSpace(req.Reason)) })
+}
+
+func handleAdminSetKeyExpiry(c *gin.Context) {
+	var req KeyExpiryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be an RFC 3339 time or null"})
+		return
+	}
+	withKeyID(c, func(id int64) error { return authStore.SetExpiry(id, req.ExpiresAt) })
+}
+
+func handleAdminDeleteKey(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key id"})
+		return
+	}
+	if err := authStore.DeleteKey(id); err != nil {
+		if errors.Is(err, auth.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": id})
+}
+
+// withKeyID applies a change to the key named in the path and responds
+// with the key as it is afterwards
+func withKeyID(c *gin.Context, change func(id int64) error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key id"})
+		return
+	}
+
+	err = change(id)
+	if err == nil {
+		var key *auth.APIKey
+		if key, err = authStore.GetKey(id); err == nil {
+			c.JSON(http.StatusOK, key)
+			return
+		}
+	}
+	switch {
+	case errors.Is(err, auth.ErrNotFound), errors.Is(err, auth.ErrOrgNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrKeyRevoked):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrUnknownPlan), errors.Is(err, auth.ErrNoSigningSecret):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrSigningDisabled):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"strconv"
@@ -25,13 +26,21 @@ var (
 	priceClient       *price.Client
 	wsClient          *price.WSClient
 	dynamicSubscriber *price.DynamicSubscriber
-	authStore         *auth.Store
+	authStore         auth.KeyStore
 	rateLimiter       *ratelimit.Limiter
 	rankingClient     *ranking.CoinGecko
 	pairsSyncer       *pairs.Syncer
+	queryParser       ai.QueryParser = ai.RulesParser{}
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -47,34 +56,56 @@ func main() {
 		log.Fatal("SOURCE_API_KEY is required")
 	}
 
-	redisAddr := os.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
-		redisAddr = "localhost:6379"
-	}
+	redisAddr := envRedisAddr()
 
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = "/data/priceforagent.db"
-	}
+	dbPath := envDBPath()
 
 	// Initialize price client
 	priceClient = price.NewClient(sourceURL, apiKey)
 
 	// Initialize auth store
 	var err error
-	authStore, err = auth.NewStore(dbPath)
+	authStore, err = openAuthStore(dbPath)
 	if err != nil {
 		log.Fatalf("Failed to initialize auth store: %v", err)
 	}
 	defer authStore.Close()
 
+	// Initialize alias dictionary, seeded from the built-in word lists
+	aliasStore, err = openAliasStore(authStore)
+	if err != nil {
+		log.Fatalf("Failed to initialize alias store: %v", err)
+	}
+	defer aliasStore.Close()
+	if err := aliasStore.Seed(seedEntries(ai.SeedDictionary())); err != nil {
+		log.Fatalf("Failed to seed aliases: %v", err)
+	}
+	startAliasReload(context.Background())
+
+	if grace := os.Getenv("KEY_ROTATION_GRACE"); grace != "" {
+		if d, err := time.ParseDuration(grace); err == nil && d >= 0 {
+			rotationGrace = d
+		}
+	}
+
+	if window := os.Getenv("SIGNATURE_WINDOW"); window != "" {
+		if d, err := time.ParseDuration(window); err == nil && d > 0 {
+			signatureWindow = d
+		}
+	}
+	configureRegistration()
+	configureTokens()
+
 	// Initialize rate limiter
-	rateLimiter, err = ratelimit.NewLimiter(redisAddr, 2) // 2 req/sec
+	rateLimiter, err = ratelimit.NewLimiter(redisAddr) // limits come from each key's plan
 	if err != nil {
 		log.Fatalf("Failed to initialize rate limiter: %v", err)
 	}
 	defer rateLimiter.Close()
 
+	// Usage counters of keys hashed at startup move to their prefix
+	moveMigratedUsage(rateLimiter, authStore.Migrated())
+
 	// Initialize ranking client (CoinGecko)
 	rankingClient = ranking.NewCoinGecko()
 
@@ -82,6 +113,19 @@ func main() {
 	redisClient := redis.NewClient(&redis.Options{Addr: redisAddr})
 	pairsSyncer = pairs.NewSyncer(sourceURL, apiKey, redisClient)
 	pairsSyncer.StartDailySync(context.Background())
+	startCatalogRefresh(context.Background())
+
+	// Optional LLM query parser (OpenAI-compatible), falls back to rules
+	if llmURL := os.Getenv("LLM_URL"); llmURL != "" {
+		timeout, _ := time.ParseDuration(os.Getenv("LLM_TIMEOUT"))
+		queryParser = ai.NewLLMParser(ai.LLMConfig{
+			URL:     llmURL,
+			Model:   os.Getenv("LLM_MODEL"),
+			APIKey:  os.Getenv("LLM_API_KEY"),
+			Timeout: timeout,
+		}, ai.RulesParser{})
+		log.Printf("LLM query parser enabled (%s)", llmURL)
+	}
 
 	// Initialize WebSocket client for real-time prices
 	wsURL := os.Getenv("WS_URL")
@@ -103,11 +147,16 @@ func main() {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
 	r.Use(gin.Recovery())
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	// Public endpoints (no auth)
 	r.GET("/health", handleHealth)
 	r.GET("/v1/info", handleInfo)
 	r.POST("/v1/register", handleRegister)
+	r.GET("/v1/register/challenge", handleRegisterChallenge)
+	r.POST("/v1/token", authMiddleware(), rateLimitMiddleware(), keyOnlyMiddleware(), handleIssueToken)
 	r.GET("/v1/openapi.yaml", handleOpenAPI)
 	r.GET("/v1/function-schema", handleFunctionSchema)
 
@@ -121,14 +170,51 @@ func main() {
 	{
 		admin.GET("/stats", handleAdminStats)
 		admin.GET("/keys", handleAdminListKeys)
-		admin.GET("/usage/:key", handleAdminKeyUsage)
+		admin.POST("/keys", handleAdminCreateKey)
+		admin.GET("/keys/:id", handleAdminGetKey)
+		admin.GET("/keys/:id/usage", handleAdminKeyUsage)
+		admin.POST("/keys/:id/disable", handleAdminDisableKey)
+		admin.POST("/keys/:id/enable", handleAdminEnableKey)
+		admin.POST("/keys/:id/revoke", handleAdminRevokeKey)
+		admin.PUT("/keys/:id/expiry", handleAdminSetKeyExpiry)
+		admin.PUT("/keys/:id/scopes", handleAdminSetKeyScopes)
+		admin.PUT("/keys/:id/plan", handleAdminSetKeyPlan)
+		admin.PUT("/keys/:id/limits", handleAdminSetKeyLimits)
+		admin.POST("/keys/:id/rotate", handleAdminRotateKey)
+		admin.POST("/keys/:id/signing-secret", handleAdminNewSigningSecret)
+		admin.PUT("/keys/:id/signing", handleAdminSetKeySigning)
+		admin.DELETE("/keys/:id", handleAdminDeleteKey)
+		admin.PUT("/keys/:id/org", handleAdminSetKeyOrg)
+		admin.GET("/plans", handleAdminListPlans)
+		admin.GET("/orgs", handleAdminListOrgs)
+		admin.POST("/orgs", handleAdminCreateOrg)
+		admin.GET("/orgs/:id", handleAdminGetOrg)
+		admin.DELETE("/orgs/:id", handleAdminDeleteOrg)
+		admin.PUT("/orgs/:id/plan", handleAdminSetOrgPlan)
+		admin.PUT("/orgs/:id/limits", handleAdminSetOrgLimits)
+		admin.POST("/orgs/:id/members", handleAdminAddOrgMember)
+		admin.DELETE("/orgs/:id/members/:member", handleAdminRemoveOrgMember)
+		admin.POST("/orgs/:id/keys", handleAdminProvisionKeys)
+		admin.GET("/orgs/:id/usage", handleAdminOrgUsage)
+		admin.PUT("/plans/:name", handleAdminSavePlan)
+		admin.GET("/registration", handleAdminGetRegistration)
+		admin.PUT("/registration", handleAdminSetRegistration)
+		admin.GET("/invites", handleAdminListInvites)
+		admin.POST("/invites", handleAdminCreateInvite)
+		admin.DELETE("/invites/:id", handleAdminDeleteInvite)
 		admin.GET("/daily", handleAdminDailyBreakdown)
+		admin.GET("/aliases", handleAdminListAliases)
+		admin.POST("/aliases", handleAdminCreateAlias)
+		admin.GET("/aliases/history", handleAdminAliasHistory)
+		admin.PUT("/aliases/:id", handleAdminUpdateAlias)
+		admin.DELETE("/aliases/:id", handleAdminDeleteAlias)
 	}
 
 	// Protected endpoints (require API key)
 	protected := r.Group("/v1")
 	protected.Use(authMiddleware())
 	protected.Use(rateLimitMiddleware())
+	protected.Use(scopeMiddleware())
 	{
 		protected.POST("/query", handleQuery)
 		protected.GET("/price/:pair", handlePrice)
@@ -136,6 +222,23 @@ func main() {
 		protected.GET("/pairs", handlePairs)
 		protected.GET("/usage", handleUsage)
 		protected.GET("/top", handleTop)
+		protected.GET("/markets/prediction", handlePredictionMarkets)
+	}
+
+	// Key management works with any valid key; scopes only limit data
+	keys := r.Group("/v1/keys")
+	keys.Use(authMiddleware())
+	keys.Use(rateLimitMiddleware())
+	keys.Use(keyOnlyMiddleware())
+	{
+		keys.GET("", handleListOwnKeys)
+		keys.POST("", handleCreateChildKey)
+		keys.POST("/rotate", handleRotateKey)
+		keys.PATCH("/:id", handleLabelKey)
+		keys.POST("/:id/revoke", handleRevokeChildKey)
+		keys.GET("/:id/usage", handleOwnKeyUsage)
+		keys.POST("/:id/signing-secret", handleNewSigningSecret)
+		keys.PUT("/:id/signing", handleSetKeySigning)
 	}
 
 	log.Printf("Starting Price for Agent on :%s", port)
@@ -146,29 +249,47 @@ func main() {
 
 func authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		key := c.GetHeader("X-API-Key")
-		if key == "" {
-			key = c.Query("api_key")
+		// An access token, a signature or the key itself
+		var apiKey *auth.APIKey
+		var status int
+		var body gin.H
+		switch token := bearerToken(c); {
+		case token != "":
+			apiKey, status, body = tokenRequestKey(c, token)
+		case c.GetHeader(headerSignature) != "":
+			apiKey, status, body = signedRequestKey(c)
+		default:
+			apiKey, status, body = plainRequestKey(c)
 		}
-		if key == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "API key required"})
+		if apiKey == nil {
+			c.JSON(status, body)
 			c.Abort()
 			return
 		}
 
-		apiKey, err := authStore.ValidateKey(key)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
-			c.Abort()
-			return
+		if apiKey.SuccessorID != 0 {
+			// Rotated; works until the grace period ends
+			if apiKey.RotatedAt != nil {
+				c.Header("Deprecation", "@"+strconv.FormatInt(apiKey.RotatedAt.Unix(), 10))
+			}
+			if apiKey.ExpiresAt != nil {
+				c.Header("Sunset", apiKey.ExpiresAt.UTC().Format(http.TimeFormat))
+			}
 		}
 
 		c.Set("api_key", apiKey)
 		c.Next()
 
 		// Track usage after request
-		go authStore.IncrementUsage(key)
-		go rateLimiter.IncrementUsage(context.Background(), key)
+		go authStore.IncrementUsage(apiKey.ID)
+		go rateLimiter.IncrementUsage(context.Background(), apiKey.UsageKey)
+		if apiKey.Parent != nil {
+			// Child key requests count toward the parent too
+			go rateLimiter.IncrementPoolUsage(context.Background(), apiKey.Parent.UsageKey)
+		}
+		if apiKey.Org != nil {
+			go rateLimiter.IncrementPoolUsage(context.Background(), apiKey.Org.UsageKey())
+		}
 	}
 }
 
@@ -190,10 +311,7 @@ func adminAuthMiddleware(adminKey string) gin.HandlerFunc {
 func rateLimitMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := context.Background()
-		key := c.GetHeader("X-API-Key")
-		if key == "" {
-			key = c.Query("api_key")
-		}
+		apiKey := c.MustGet("api_key").(*auth.APIKey)
 
 		// Check global limit first
 		globalAllowed, globalRemaining, _ := rateLimiter.CheckGlobalLimit(ctx)
@@ -211,24 +329,56 @@ func rateLimitMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Check per-key rate limit
-		allowed, remaining, err := rateLimiter.Allow(ctx, key)
+		// Check per-key limits from the key's plan and those it shares,
+		// all at once so a pool that denies the request costs the
+		// others nothing. Like the signing nonce check, limits fail
+		// closed: without Redis, requests are refused, not unmetered.
+		pools := limitPools(apiKey)
+		res, denying, err := rateLimiter.AllowAll(ctx, pools)
 		if err != nil {
 			log.Printf("Rate limit error: %v", err)
-			c.Next()
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Rate limits can't be checked right now, try again later",
+				"code":  "rate_limit_unavailable",
+			})
+			c.Abort()
 			return
 		}
+		limits := pools[denying].Limits
 
-		c.Header("X-RateLimit-Limit", "2")
-		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("X-RateLimit-Plan", apiKey.Plan)
+		if limits.PerSecond > 0 {
+			c.Header("X-RateLimit-Limit", strconv.Itoa(limits.PerSecond))
+			c.Header("X-RateLimit-Burst", strconv.Itoa(max(limits.Burst, limits.PerSecond)))
+			c.Header("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		}
+		if limits.Daily > 0 {
+			c.Header("X-Quota-Daily-Limit", strconv.FormatInt(limits.Daily, 10))
+			c.Header("X-Quota-Daily-Remaining", strconv.FormatInt(res.DailyRemaining, 10))
+		}
+		if limits.Monthly > 0 {
+			c.Header("X-Quota-Monthly-Limit", strconv.FormatInt(limits.Monthly, 10))
+			c.Header("X-Quota-Monthly-Remaining", strconv.FormatInt(res.MonthlyRemaining, 10))
+		}
 
-		if !allowed {
-			c.JSON(http.StatusTooManyRequests, gin.H{
+		if !res.Allowed {
+			retryAfter := int(math.Ceil(res.RetryAfter.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			body := gin.H{
 				"error":       "Rate limit exceeded",
-				"limit":       2,
-				"remaining":   remaining,
-				"retry_after": 1,
-			})
+				"code":        "rate_limited",
+				"plan":        apiKey.Plan,
+				"limit":       limits.PerSecond,
+				"remaining":   res.Remaining,
+				"retry_after": retryAfter,
+			}
+			switch res.Reason {
+			case ratelimit.ReasonDaily:
+				body["error"], body["code"], body["limit"] = "Daily quota exceeded", "daily_quota_exceeded", limits.Daily
+			case ratelimit.ReasonMonthly:
+				body["error"], body["code"], body["limit"] = "Monthly quota exceeded", "monthly_quota_exceeded", limits.Monthly
+			}
+			c.JSON(http.StatusTooManyRequests, body)
 			c.Abort()
 			return
 		}
@@ -237,6 +387,29 @@ func rateLimitMiddleware() gin.HandlerFunc {
 	}
 }
 
+// limitPools returns the limits a request by a key is checked against:
+// the key's own, its parent's for a child key, and its organization's
+func limitPools(k *auth.APIKey) []ratelimit.Pool {
+	pools := []ratelimit.Pool{{Key: k.UsageKey, Limits: rateLimits(k.Limits)}}
+	if k.Parent != nil {
+		pools = append(pools, ratelimit.Pool{Key: k.Parent.UsageKey, Limits: rateLimits(k.Parent.Limits)})
+	}
+	if k.Org != nil {
+		pools = append(pools, ratelimit.Pool{Key: k.Org.UsageKey(), Limits: rateLimits(k.Org.Limits)})
+	}
+	return pools
+}
+
+// rateLimits converts plan limits for the rate limiter
+func rateLimits(l auth.Limits) ratelimit.Limits {
+	return ratelimit.Limits{
+		PerSecond: l.PerSecond,
+		Burst:     l.Burst,
+		Daily:     l.Daily,
+		Monthly:   l.Monthly,
+	}
+}
+
 // Handlers
 
 func handleHealth(c *gin.Context) {
@@ -273,35 +446,17 @@ func handleInfo(c *gin.Context) {
 			"Prediction markets",
 		},
 		"endpoints": gin.H{
-			"GET /v1/price/:pair": "Get price for a single asset",
-			"POST /v1/query":      "Natural language price query",
-			"POST /v1/batch":      "Batch price lookup",
-			"GET /v1/pairs":       "List all pairs (2700+)",
-			"GET /v1/top":         "Top coins by market cap",
+			"GET /v1/price/:pair":        "Get price for a single asset",
+			"POST /v1/query":             "Natural language price query",
+			"POST /v1/batch":             "Batch price lookup",
+			"GET /v1/pairs":              "List all pairs (2700+)",
+			"GET /v1/top":                "Top coins by market cap",
+			"GET /v1/markets/prediction": "List and search prediction markets",
 		},
 		"docs": "https://github.com/edibez/priceforagent",
 	})
 }
 
-func handleRegister(c *gin.Context) {
-	var req struct {
-		AgentID string `json:"agent_id"`
-	}
-	c.ShouldBindJSON(&req)
-
-	apiKey, err := authStore.GenerateKey(req.AgentID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"api_key":    apiKey.Key,
-		"message":    "API key generated successfully. Include this in X-API-Key header.",
-		"created_at": apiKey.CreatedAt,
-	})
-}
-
 // Admin handlers
 
 func handleAdminStats(c *gin.Context) {
@@ -341,13 +496,17 @@ func handleAdminListKeys(c *gin.Context) {
 	// Enrich with Redis stats
 	var enrichedKeys []gin.H
 	for _, k := range keys {
-		last24h, _ := rateLimiter.GetLast24HoursUsage(ctx, k.Key)
-		last7d, _ := rateLimiter.GetLast7DaysUsage(ctx, k.Key)
+		last24h, _ := rateLimiter.GetLast24HoursUsage(ctx, k.UsageKey)
+		last7d, _ := rateLimiter.GetLast7DaysUsage(ctx, k.UsageKey)
 		
 		enrichedKeys = append(enrichedKeys, gin.H{
 			"id":         k.ID,
-			"api_key":    k.Key[:16] + "...",
+			"api_key":    k.Prefix + "...",
 			"agent_id":   k.AgentID,
+			"status":     k.Status,
+			"plan":       k.Plan,
+			"org_id":     k.OrgID,
+			"expires_at": k.ExpiresAt,
 			"created_at": k.CreatedAt,
 			"hit_count":  k.HitCount,
 			"last_24h":   last24h,
@@ -363,36 +522,41 @@ func handleAdminListKeys(c *gin.Context) {
 
 func handleAdminKeyUsage(c *gin.Context) {
 	ctx := context.Background()
-	keyPrefix := c.Param("key")
-	
-	// Find full key
-	keys, _ := authStore.ListKeys()
-	var fullKey string
-	for _, k := range keys {
-		if len(k.Key) >= len(keyPrefix) && k.Key[:len(keyPrefix)] == keyPrefix {
-			fullKey = k.Key
-			break
-		}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key id"})
+		return
 	}
-	
-	if fullKey == "" {
+
+	key, err := authStore.GetKey(id)
+	if err == auth.ErrNotFound {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
 		return
 	}
-	
-	// Get detailed stats
-	total, _ := rateLimiter.GetUsage(ctx, fullKey)
-	last24h, _ := rateLimiter.GetLast24HoursUsage(ctx, fullKey)
-	last7d, _ := rateLimiter.GetLast7DaysUsage(ctx, fullKey)
-	breakdown, _ := rateLimiter.GetDailyBreakdown(ctx, fullKey, 7)
-	
-	c.JSON(http.StatusOK, gin.H{
-		"api_key":        fullKey[:16] + "...",
-		"total":          total,
-		"last_24h":       last24h,
-		"last_7_days":    last7d,
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, keyUsage(ctx, key))
+}
+
+// keyUsage returns detailed usage stats for a key
+func keyUsage(ctx context.Context, key *auth.APIKey) gin.H {
+	total, _ := rateLimiter.GetUsage(ctx, key.UsageKey)
+	last24h, _ := rateLimiter.GetLast24HoursUsage(ctx, key.UsageKey)
+	last7d, _ := rateLimiter.GetLast7DaysUsage(ctx, key.UsageKey)
+	breakdown, _ := rateLimiter.GetDailyBreakdown(ctx, key.UsageKey, 7)
+
+	return gin.H{
+		"id":              key.ID,
+		"api_key":         key.Prefix + "...",
+		"label":           key.Label,
+		"total":           total,
+		"last_24h":        last24h,
+		"last_7_days":     last7d,
 		"daily_breakdown": breakdown,
-	})
+	}
 }
 
 func handleAdminDailyBreakdown(c *gin.Context) {
@@ -420,14 +584,16 @@ func handleUsage(c *gin.Context) {
 	apiKey, _ := c.Get("api_key")
 	key := apiKey.(*auth.APIKey)
 
-	stats, err := authStore.GetUsageStats(key.Key)
+	stats, err := authStore.GetKey(key.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage stats"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"api_key":    stats.Key[:12] + "...",
+		"api_key":    stats.Prefix + "...",
+		"plan":       stats.Plan,
+		"limits":     stats.Limits,
 		"hit_count":  stats.HitCount,
 		"created_at": stats.CreatedAt,
 		"last_used":  stats.LastUsed,
@@ -437,7 +603,10 @@ func handleUsage(c *gin.Context) {
 // Request/Response types
 
 type QueryRequest struct {
-	Query string `json:"query" binding:"required"`
+	Query      string `json:"query" binding:"required"`
+	AssetClass string `json:"asset_class,omitempty"` // preferred class for ambiguous tickers
+	Code       string `json:"code,omitempty"`        // exact source code, skips parsing
+	Lang       string `json:"lang,omitempty"`        // query language; detected when empty
 }
 
 type BatchRequest struct {
@@ -445,14 +614,27 @@ type BatchRequest struct {
 }
 
 type PriceResponse struct {
-	Pair      string  `json:"pair"`
-	Price     float64 `json:"price"`
-	Ask       float64 `json:"ask,omitempty"`
-	Bid       float64 `json:"bid,omitempty"`
-	Currency  string  `json:"currency"`
-	Market    string  `json:"market"`
-	Timestamp int64   `json:"timestamp"`
-	Source    string  `json:"source,omitempty"`
+	Pair         string          `json:"pair"`
+	Price        float64         `json:"price"`
+	Ask          float64         `json:"ask,omitempty"`
+	Bid          float64         `json:"bid,omitempty"`
+	Currency     string          `json:"currency"`
+	Unit         string          `json:"unit,omitempty"` // "per barrel" for commodities
+	Market       string          `json:"market"`
+	Timestamp    int64           `json:"timestamp"`
+	Source       string          `json:"source,omitempty"`
+	Code         string          `json:"code,omitempty"`
+	Venue        string          `json:"venue,omitempty"`
+	Conversion   *ConversionInfo `json:"conversion,omitempty"`
+	Inverted     bool            `json:"inverted,omitempty"` // priced from the reverse pair
+	Amount       float64         `json:"amount,omitempty"`
+	Value        float64         `json:"value,omitempty"`
+	Change24h    *float64        `json:"change_24h,omitempty"`
+	ChangeNote   string          `json:"change_note,omitempty"`
+	Alternatives []ai.Candidate  `json:"alternatives,omitempty"`
+	DidYouMean   *ai.Suggestion  `json:"did_you_mean,omitempty"`
+	Match        *ai.Match       `json:"match,omitempty"`
+	Explain      *Trace          `json:"explain,omitempty"` // with ?explain=true
 }
 
 // getPriceWithCache tries WS cache first, falls back to HTTP
@@ -492,47 +674,248 @@ func handleQuery(c *gin.Context) {
 		return
 	}
 
-	assets := ai.ParseQuery(req.Query)
-	if len(assets) == 0 {
-		c.JSON(http.StatusOK, gin.H{
+	if req.Code != "" {
+		handleQueryCode(c, req)
+		return
+	}
+
+	lang := strings.ToLower(req.Lang)
+	if _, ok := ai.Languages[lang]; lang != "" && !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported lang", "supported": supportedLanguages()})
+		return
+	}
+
+	tr := newTrace(c)
+	start := time.Now()
+	parsed, err := queryParser.Parse(c.Request.Context(), req.Query, ai.Options{
+		Class:   ai.AssetClass(strings.ToLower(req.AssetClass)),
+		Catalog: catalog,
+		Lang:    lang,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse query", "details": err.Error()})
+		return
+	}
+	tr.traceParse(start, parsed)
+	if parsed.Intent == ai.IntentOdds {
+		handleOddsQuery(c, req, parsed, tr)
+		return
+	}
+	if parsed.Intent == ai.IntentRank {
+		if key := requestKey(c); key != nil && !key.AllowsClass(string(ai.ClassCrypto)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key is not allowed to rank crypto assets", "code": scopeClass})
+			return
+		}
+		results, denied, err := topCoins(requestKey(c), parsed.Limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rankings", "details": err.Error()})
+			return
+		}
+		response := gin.H{
+			"query":   req.Query,
+			"lang":    parsed.Lang,
+			"intent":  parsed.Intent,
+			"limit":   parsed.Limit,
+			"results": results,
+		}
+		if len(denied) > 0 {
+			response["denied"] = denied
+		}
+		c.JSON(http.StatusOK, withExplain(response, tr))
+		return
+	}
+
+	matches := parsed.Matches
+	if len(matches) == 0 {
+		response := gin.H{
 			"query":   req.Query,
+			"lang":    parsed.Lang,
+			"intent":  parsed.Intent,
 			"results": []interface{}{},
 			"message": "No assets found in query. Try: 'What's the price of Bitcoin?'",
-		})
+		}
+		if len(parsed.Suggestions) > 0 {
+			response["suggestions"] = parsed.Suggestions
+		}
+		c.JSON(http.StatusOK, withExplain(response, tr))
 		return
 	}
 
+	// Ratios divide two prices, so both need the same quote
+	quote := parsed.Quote
+	if parsed.Intent == ai.IntentRatio && quote == "" {
+		quote = "USD"
+	}
+
 	var results []PriceResponse
-	for _, asset := range assets {
-		code := ai.BuildCode(asset)
-		data, source := getPriceWithCache(code)
-		if data == nil {
+	var clarifications []gin.H
+	var corrections []gin.H
+	var denied []gin.H
+	for i := range matches {
+		m := matches[i]
+		if m.DidYouMean != "" {
+			corrections = append(corrections, gin.H{
+				"text":         m.Text,
+				"did_you_mean": m.DidYouMean,
+				"asset":        m.Asset,
+				"confidence":   m.Confidence,
+			})
+		}
+		if m.Ambiguous {
+			clarifications = append(clarifications, gin.H{
+				"text":       m.Text,
+				"reason":     m.Reason,
+				"candidates": m.Candidates,
+			})
 			continue
 		}
-		resp := toPriceResponse(asset, data)
-		resp.Source = source
-		results = append(results, resp)
+		target := ai.Target{Asset: m.Asset, Quote: quote, Venue: parsed.Venue, Class: m.Class, Market: m.Market}
+		start := time.Now()
+		res := catalog.Resolve(target)
+		tr.traceResolve(start, target, res)
+		if denial := scopeDenial(requestKey(c), res.Code); denial != "" {
+			denied = append(denied, gin.H{"text": m.Text, "pair": res.Code, "code": denial})
+			continue
+		}
+		resp, ok := priceResolution(res, tr)
+		if !ok {
+			continue
+		}
+		resp.Match = &m
+		if m.Amount > 0 {
+			// Total value in the response currency ("what is 0.3 BTC worth")
+			resp.Amount = m.Amount
+			resp.Value = m.Amount * resp.Price
+		}
+		results = append(results, *resp)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"query":   req.Query,
-		"results": results,
-	})
+	response := gin.H{
+		"query":               req.Query,
+		"lang":                parsed.Lang,
+		"parser":              parsed.Parser,
+		"intent":              parsed.Intent,
+		"results":             results,
+		"needs_clarification": parsed.NeedsClarification,
+	}
+	if len(corrections) > 0 {
+		response["did_you_mean"] = corrections
+	}
+	if len(parsed.Suggestions) > 0 {
+		response["suggestions"] = parsed.Suggestions
+	}
+	if len(denied) > 0 {
+		response["denied"] = denied
+	}
+	if parsed.NeedsClarification {
+		response["clarifications"] = clarifications
+		response["message"] = "Some assets are ambiguous. Repeat the query with asset_class or code set to one of the candidates."
+	}
+	switch parsed.Intent {
+	case ai.IntentChange:
+		if note := attachChange(results); note != "" {
+			response["note"] = note
+		}
+	case ai.IntentCompare:
+		if parsed.Metric == ai.MetricChange {
+			attachChange(results)
+		}
+		response["comparison"] = compareResults(parsed, results)
+	case ai.IntentRatio:
+		if ratio, ok := ratioOf(parsed, results); ok {
+			response["ratio"] = ratio
+		}
+	}
+	if parsed.Quote != "" {
+		response["quote"] = parsed.Quote
+	}
+	if parsed.Venue != "" {
+		response["venue"] = parsed.Venue
+	}
+	c.JSON(http.StatusOK, withExplain(response, tr))
 }
 
 func handlePrice(c *gin.Context) {
 	pair := c.Param("pair")
-	asset := ai.NormalizeAsset(pair)
-	code := ai.BuildCode(asset)
+	if code := c.Query("code"); code != "" {
+		pair = code
+	}
+
+	tr := newTrace(c)
+	var res ai.Resolution
+	var alternatives []ai.Candidate
+	var didYouMean *ai.Suggestion
+	if strings.Count(pair, ":") == 2 && ai.ParseCode(pair).Type == ai.PredictionType {
+		if denial := scopeDenial(requestKey(c), pair); denial != "" {
+			c.JSON(http.StatusForbidden, scopeError(pair, denial))
+			return
+		}
+		handlePredictionPrice(c, pair, tr)
+		return
+	}
+	if strings.Count(pair, ":") == 2 {
+		// Full source code such as "Crypto:ALL:BTC/USDT"
+		start := time.Now()
+		parts := ai.ParseCode(pair)
+		res = ai.Resolution{Asset: parts.Base, Code: pair, Quote: parts.Quote, Listed: catalog.Has(pair)}
+		tr.add("resolve", start, gin.H{"code": pair, "listed": res.Listed, "catalog_pairs": catalog.Len()})
+	} else {
+		start := time.Now()
+		target := ai.PairTarget(pair)
+		if target.Market == "" {
+			// Typos like "etherium" are corrected and reported; less
+			// certain ones end in a 404 with suggestions
+			if !catalog.Known(target.Asset) {
+				if fix, ok := ai.Correct(target.Asset, catalog); ok && ai.Sure(target.Asset, fix) {
+					didYouMean = &fix
+					target.Asset = fix.Asset
+				}
+			}
+			target.Class = ai.AssetClass(strings.ToLower(c.Query("class")))
+		}
+
+		// Ambiguous tickers get their most common reading, with the
+		// others listed so the caller can ask again with ?class=
+		if target.Class == "" {
+			if cands := catalog.Candidates(target.Asset); len(cands) > 1 {
+				target.Asset, target.Class = cands[0].Asset, cands[0].Class
+				alternatives = cands[1:]
+			}
+		}
+		tr.add("parse", start, gin.H{
+			"pair":         pair,
+			"asset":        target.Asset,
+			"quote":        target.Quote,
+			"venue":        target.Venue,
+			"market":       target.Market,
+			"class":        target.Class,
+			"did_you_mean": didYouMean,
+			"alternatives": len(alternatives),
+		})
+
+		start = time.Now()
+		res = catalog.Resolve(target)
+		tr.traceResolve(start, target, res)
+	}
+
+	if denial := scopeDenial(requestKey(c), res.Code); denial != "" {
+		c.JSON(http.StatusForbidden, scopeError(res.Code, denial))
+		return
+	}
 
-	data, source := getPriceWithCache(code)
-	if data == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "pair not found", "pair": pair})
+	resp, ok := priceResolution(res, tr)
+	if !ok {
+		c.JSON(http.StatusNotFound, withExplain(gin.H{
+			"error":       "pair not found",
+			"pair":        pair,
+			"suggestions": ai.Suggest(res.Asset, catalog, 5),
+		}, tr))
 		return
 	}
+	resp.Alternatives = alternatives
+	resp.DidYouMean = didYouMean
+	resp.Explain = tr.done()
 
-	resp := toPriceResponse(asset, data)
-	resp.Source = source
 	c.JSON(http.StatusOK, resp)
 }
 
@@ -545,31 +928,34 @@ func handleBatch(c *gin.Context) {
 
 	// Parallel fetch
 	type result struct {
-		index int
-		data  *PriceResponse
-		err   error
-		pair  string
+		index  int
+		data   *PriceResponse
+		err    error
+		denial string
+		pair   string
 	}
 
 	results := make([]PriceResponse, 0, len(req.Pairs))
 	errors := make([]gin.H, 0)
 	resultChan := make(chan result, len(req.Pairs))
 
+	key := requestKey(c)
 	var wg sync.WaitGroup
 	for i, pair := range req.Pairs {
 		wg.Add(1)
 		go func(idx int, p string) {
 			defer wg.Done()
-			asset := ai.NormalizeAsset(p)
-			code := ai.BuildCode(asset)
-			data, source := getPriceWithCache(code)
-			if data == nil {
+			res := catalog.Resolve(ai.PairTarget(p))
+			if denial := scopeDenial(key, res.Code); denial != "" {
+				resultChan <- result{index: idx, err: fmt.Errorf("not allowed by API key scopes"), denial: denial, pair: p}
+				return
+			}
+			resp, ok := priceResolution(res, nil)
+			if !ok {
 				resultChan <- result{index: idx, err: fmt.Errorf("not found"), pair: p}
 				return
 			}
-			resp := toPriceResponse(asset, data)
-			resp.Source = source
-			resultChan <- result{index: idx, data: &resp, pair: p}
+			resultChan <- result{index: idx, data: resp, pair: p}
 		}(i, pair)
 	}
 
@@ -582,7 +968,9 @@ func handleBatch(c *gin.Context) {
 	// Collect results maintaining order
 	ordered := make([]*PriceResponse, len(req.Pairs))
 	for r := range resultChan {
-		if r.err != nil {
+		if r.denial != "" {
+			errors = append(errors, gin.H{"pair": r.pair, "error": r.err.Error(), "code": r.denial})
+		} else if r.err != nil {
 			errors = append(errors, gin.H{"pair": r.pair, "error": r.err.Error()})
 		} else {
 			ordered[r.index] = r.data
@@ -611,13 +999,42 @@ func handleTop(c *gin.Context) {
 		}
 	}
 
-	// Get top coins from CoinGecko (symbols + ranking info)
-	coins, err := rankingClient.GetTopCoins(limit)
+	results, denied, err := topCoins(requestKey(c), limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rankings", "details": err.Error()})
 		return
 	}
 
+	response := gin.H{
+		"limit":   limit,
+		"results": results,
+	}
+	if len(denied) > 0 {
+		response["denied"] = denied
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// topCoins returns the top coins by market cap with source prices.
+// Coins the key's scopes don't allow are left out and listed as denied.
+func topCoins(key *auth.APIKey, limit int) ([]gin.H, []gin.H, error) {
+	// Get top coins from CoinGecko (symbols + ranking info)
+	ranked, err := rankingClient.GetTopCoins(limit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var coins []ranking.CoinRank
+	var denied []gin.H
+	for _, coin := range ranked {
+		code := ai.BuildCode(ai.NormalizeAsset(coin.Symbol))
+		if denial := scopeDenial(key, code); denial != "" {
+			denied = append(denied, gin.H{"symbol": coin.Symbol, "pair": code, "code": denial})
+			continue
+		}
+		coins = append(coins, coin)
+	}
+
 	// Fetch prices in parallel from source
 	type priceResult struct {
 		index int
@@ -675,10 +1092,7 @@ func handleTop(c *gin.Context) {
 		results = append(results, entry)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"limit":   limit,
-		"results": results,
-	})
+	return results, denied, nil
 }
 
 func handlePairs(c *gin.Context) {
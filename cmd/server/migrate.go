@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/edibez/priceforagent/internal/auth"
+	"github.com/edibez/priceforagent/internal/ratelimit"
+)
+
+// envDBPath returns the SQLite database path from DB_PATH
+func envDBPath() string {
+	if dbPath := os.Getenv("DB_PATH"); dbPath != "" {
+		return dbPath
+	}
+	return "/data/priceforagent.db"
+}
+
+// envRedisAddr returns the Redis address from REDIS_ADDR
+func envRedisAddr() string {
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		return addr
+	}
+	return "localhost:6379"
+}
+
+// moveMigratedUsage moves the usage counters of keys hashed by the
+// baseline migration from the plaintext key to the key's prefix
+func moveMigratedUsage(limiter *ratelimit.Limiter, migrated []auth.MigratedKey) {
+	for _, m := range migrated {
+		if err := limiter.MoveUsage(context.Background(), m.Key, m.Prefix); err != nil {
+			log.Printf("Failed to move usage of %s: %v", m.Prefix, err)
+		}
+	}
+	if n := len(migrated); n > 0 {
+		log.Printf("Hashed %d plaintext API keys", n)
+	}
+}
+
+// openAuthStore opens the key store AUTH_STORE names: "sqlite", the
+// default, or "memory". SQLite is migrated on boot unless
+// AUTH_MIGRATE=false, in which case its schema must already be current.
+// Its signing secrets are encrypted with SIGNING_SECRET_KEY, and
+// request signing is off without one.
+func openAuthStore(dbPath string) (auth.KeyStore, error) {
+	switch backend := os.Getenv("AUTH_STORE"); backend {
+	case "memory":
+		log.Printf("Using the in-memory key store; keys are lost on restart")
+		return auth.NewMemoryStore(), nil
+	case "", "sqlite":
+	default:
+		return nil, fmt.Errorf("unknown AUTH_STORE %q", backend)
+	}
+
+	store, err := openSQLiteStore(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	secretKey := os.Getenv("SIGNING_SECRET_KEY")
+	if err := store.SetSecretKey(secretKey); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("SIGNING_SECRET_KEY: %w", err)
+	}
+	if secretKey == "" {
+		log.Printf("Request signing is disabled; set SIGNING_SECRET_KEY to enable it")
+	}
+	return store, nil
+}
+
+// openSQLiteStore opens the SQLite key store, migrating it unless
+// AUTH_MIGRATE=false
+func openSQLiteStore(dbPath string) (*auth.Store, error) {
+	if os.Getenv("AUTH_MIGRATE") != "false" {
+		return auth.NewStore(dbPath)
+	}
+	store, err := auth.OpenStore(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	version, err := store.SchemaVersion()
+	if err == nil && version != auth.LatestVersion() {
+		err = fmt.Errorf("auth schema is at version %d, not %d; run `server migrate up`", version, auth.LatestVersion())
+	}
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// runMigrate is the migrate subcommand:
+//
+//	server migrate status
+//	server migrate up [version]
+//	server migrate down <version>
+func runMigrate(args []string) error {
+	store, err := auth.OpenStore(envDBPath())
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	cmd := "status"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	target := -1
+	if len(args) > 1 {
+		if target, err = strconv.Atoi(args[1]); err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+	}
+
+	switch cmd {
+	case "status":
+	case "up":
+		if target < 0 {
+			target = auth.LatestVersion()
+		}
+		if err := store.Migrate(target); err != nil {
+			return err
+		}
+	case "down":
+		if target < 0 {
+			return fmt.Errorf("usage: migrate down <version>")
+		}
+		if err := store.Migrate(target); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("usage: migrate [status | up [version] | down <version>]")
+	}
+
+	if migrated := store.Migrated(); len(migrated) > 0 {
+		limiter, err := ratelimit.NewLimiter(envRedisAddr())
+		if err != nil {
+			return fmt.Errorf("keys were hashed but their usage can't be moved: %w", err)
+		}
+		defer limiter.Close()
+		moveMigratedUsage(limiter, migrated)
+	}
+
+	migrations, err := store.Migrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		applied := "pending"
+		if m.AppliedAt != nil {
+			applied = "applied " + m.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%4d  %-24s %s\n", m.Version, m.Name, applied)
+	}
+	return nil
+}
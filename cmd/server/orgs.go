@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/edibez/priceforagent/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// maxProvisionKeys caps how many keys one bulk provisioning issues
+const maxProvisionKeys = 1000
+
+// OrgCreateRequest creates an organization
+type OrgCreateRequest struct {
+	Name string `json:"name" binding:"required"`
+	Plan string `json:"plan"` // pooled limits; none when empty
+}
+
+// OrgMemberRequest adds a member or changes their role
+type OrgMemberRequest struct {
+	Member string `json:"member" binding:"required"`
+	Role   string `json:"role"` // defaults to member
+}
+
+// KeyOrgRequest moves a key to an organization; 0 takes it out
+type KeyOrgRequest struct {
+	OrgID int64 `json:"org_id"`
+}
+
+// ProvisionRequest issues keys for an organization's agents
+type ProvisionRequest struct {
+	Count       int           `json:"count" binding:"required"`
+	AgentPrefix string        `json:"agent_prefix"`
+	Plan        string        `json:"plan"`
+	Scopes      ScopesRequest `json:"scopes"`
+}
+
+func handleAdminListOrgs(c *gin.Context) {
+	orgs, err := authStore.ListOrgs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if orgs == nil {
+		orgs = []auth.Org{}
+	}
+	c.JSON(http.StatusOK, gin.H{"count": len(orgs), "orgs": orgs})
+}
+
+func handleAdminCreateOrg(c *gin.Context) {
+	var req OrgCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	org, err := authStore.CreateOrg(strings.TrimSpace(req.Name), strings.ToLower(strings.TrimSpace(req.Plan)))
+	if err != nil {
+		c.JSON(orgError(err))
+		return
+	}
+	c.JSON(http.StatusCreated, org)
+}
+
+func handleAdminGetOrg(c *gin.Context) {
+	withOrgID(c, func(id int64) error { return nil })
+}
+
+func handleAdminDeleteOrg(c *gin.Context) {
+	id, ok := orgID(c)
+	if !ok {
+		return
+	}
+	if err := authStore.DeleteOrg(id); err != nil {
+		c.JSON(orgError(err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": id})
+}
+
+func handleAdminSetOrgPlan(c *gin.Context) {
+	var req struct {
+		Plan string `json:"plan"` // empty removes the pooled limits
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	plan := strings.ToLower(strings.TrimSpace(req.Plan))
+	withOrgID(c, func(id int64) error { return authStore.SetOrgPlan(id, plan) })
+}
+
+// handleAdminSetOrgLimits replaces an organization's limit overrides,
+// like handleAdminSetKeyLimits does for a key
+func handleAdminSetOrgLimits(c *gin.Context) {
+	var o auth.LimitOverrides
+	if err := c.ShouldBindJSON(&o); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	overrides := &o
+	if o == (auth.LimitOverrides{}) {
+		overrides = nil
+	}
+	withOrgID(c, func(id int64) error { return authStore.SetOrgOverrides(id, overrides) })
+}
+
+func handleAdminAddOrgMember(c *gin.Context) {
+	var req OrgMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "member is required"})
+		return
+	}
+	role := strings.ToLower(strings.TrimSpace(req.Role))
+	if role == "" {
+		role = auth.RoleMember
+	}
+	withOrgID(c, func(id int64) error { return authStore.AddMember(id, strings.TrimSpace(req.Member), role) })
+}
+
+func handleAdminRemoveOrgMember(c *gin.Context) {
+	member := c.Param("member")
+	withOrgID(c, func(id int64) error { return authStore.RemoveMember(id, member) })
+}
+
+func handleAdminSetKeyOrg(c *gin.Context) {
+	var req KeyOrgRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	withKeyID(c, func(id int64) error { return authStore.SetKeyOrg(id, req.OrgID) })
+}
+
+// handleAdminProvisionKeys issues a batch of keys owned by an
+// organization and returns them with their plaintext
+func handleAdminProvisionKeys(c *gin.Context) {
+	id, ok := orgID(c)
+	if !ok {
+		return
+	}
+	var req ProvisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "count is required"})
+		return
+	}
+	if req.Count < 1 || req.Count > maxProvisionKeys {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "count must be between 1 and " + strconv.Itoa(maxProvisionKeys)})
+		return
+	}
+	agentPrefix := strings.TrimSpace(req.AgentPrefix)
+	if agentPrefix != "" && !validAgentID.MatchString(agentPrefix) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "agent_prefix must be a valid agent_id"})
+		return
+	}
+	scopes, err := req.Scopes.normalize()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	keys, err := authStore.ProvisionKeys(id, req.Count, agentPrefix, strings.ToLower(strings.TrimSpace(req.Plan)), scopes)
+	if err != nil {
+		c.JSON(orgError(err))
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"org_id": id, "count": len(keys), "keys": keys})
+}
+
+// handleAdminOrgUsage reports an organization's pooled usage and that
+// of each of its keys
+func handleAdminOrgUsage(c *gin.Context) {
+	id, ok := orgID(c)
+	if !ok {
+		return
+	}
+	org, err := authStore.GetOrg(id)
+	if err != nil {
+		c.JSON(orgError(err))
+		return
+	}
+	keys, err := authStore.ListOrgKeys(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	perKey := make([]gin.H, 0, len(keys))
+	for _, k := range keys {
+		total, _ := rateLimiter.GetUsage(ctx, k.UsageKey)
+		last24h, _ := rateLimiter.GetLast24HoursUsage(ctx, k.UsageKey)
+		last7d, _ := rateLimiter.GetLast7DaysUsage(ctx, k.UsageKey)
+		perKey = append(perKey, gin.H{
+			"id":          k.ID,
+			"api_key":     k.Prefix + "...",
+			"agent_id":    k.AgentID,
+			"label":       k.Label,
+			"status":      k.Status,
+			"total":       total,
+			"last_24h":    last24h,
+			"last_7_days": last7d,
+		})
+	}
+
+	usage, _ := rateLimiter.GetPoolUsage(ctx, org.UsageKey(), 7)
+	c.JSON(http.StatusOK, gin.H{
+		"org":             org,
+		"total":           usage.Total,
+		"last_24h":        usage.Last24h,
+		"last_7_days":     usage.Last7Days,
+		"daily_breakdown": usage.Daily,
+		"keys":            perKey,
+	})
+}
+
+// orgID parses the organization ID in the path
+func orgID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid organization id"})
+		return 0, false
+	}
+	return id, true
+}
+
+// withOrgID applies a change to the organization named in the path and
+// responds with it, its members and its keys as they are afterwards
+func withOrgID(c *gin.Context, change func(id int64) error) {
+	id, ok := orgID(c)
+	if !ok {
+		return
+	}
+	if err := change(id); err != nil {
+		c.JSON(orgError(err))
+		return
+	}
+
+	org, err := authStore.GetOrg(id)
+	if err != nil {
+		c.JSON(orgError(err))
+		return
+	}
+	members, err := authStore.ListMembers(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	keys, err := authStore.ListOrgKeys(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if members == nil {
+		members = []auth.Member{}
+	}
+	if keys == nil {
+		keys = []auth.APIKey{}
+	}
+	c.JSON(http.StatusOK, gin.H{"org": org, "members": members, "keys": keys})
+}
+
+// orgError maps an organization change error to a status and body
+func orgError(err error) (int, gin.H) {
+	switch {
+	case errors.Is(err, auth.ErrOrgNotFound), errors.Is(err, auth.ErrMemberNotFound):
+		return http.StatusNotFound, gin.H{"error": err.Error()}
+	case errors.Is(err, auth.ErrOrgExists), errors.Is(err, auth.ErrAgentIDTaken):
+		return http.StatusConflict, gin.H{"error": err.Error()}
+	case errors.Is(err, auth.ErrUnknownPlan), errors.Is(err, auth.ErrInvalidRole):
+		return http.StatusBadRequest, gin.H{"error": err.Error()}
+	}
+	return http.StatusInternalServerError, gin.H{"error": err.Error()}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/edibez/priceforagent/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// KeyPlanRequest moves a key to another plan
+type KeyPlanRequest struct {
+	Plan string `json:"plan" binding:"required"`
+}
+
+func handleAdminListPlans(c *gin.Context) {
+	plans, err := authStore.ListPlans()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"count": len(plans), "plans": plans})
+}
+
+func handleAdminSavePlan(c *gin.Context) {
+	var limits auth.Limits
+	if err := c.ShouldBindJSON(&limits); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if limits.PerSecond < 0 || limits.Burst < 0 || limits.Daily < 0 || limits.Monthly < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limits must be zero (unlimited) or positive"})
+		return
+	}
+
+	plan := auth.Plan{Name: strings.ToLower(strings.TrimSpace(c.Param("name"))), Limits: limits}
+	if err := authStore.SavePlan(plan); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, plan)
+}
+
+func handleAdminSetKeyPlan(c *gin.Context) {
+	var req KeyPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "plan is required"})
+		return
+	}
+	plan := strings.ToLower(strings.TrimSpace(req.Plan))
+	withKeyID(c, func(id int64) error { return authStore.SetPlan(id, plan) })
+}
+
+// handleAdminSetKeyLimits replaces a key's limit overrides. Fields left
+// out use the plan's value; an empty body clears all overrides.
+func handleAdminSetKeyLimits(c *gin.Context) {
+	var o auth.LimitOverrides
+	if err := c.ShouldBindJSON(&o); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	overrides := &o
+	if o == (auth.LimitOverrides{}) {
+		overrides = nil
+	}
+	withKeyID(c, func(id int64) error { return authStore.SetOverrides(id, overrides) })
+}
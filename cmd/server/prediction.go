@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/edibez/priceforagent/internal/ai"
+	"github.com/edibez/priceforagent/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// OutcomeOdds is the priced outcome of a prediction market
+type OutcomeOdds struct {
+	Outcome     string  `json:"outcome"`
+	Code        string  `json:"code"`
+	Price       float64 `json:"price"`
+	Probability float64 `json:"probability"`
+}
+
+// predictionOdds prices every outcome of a market. Share prices are
+// implied probabilities; sources quoting in cents are scaled down.
+func predictionOdds(m ai.PredictionMarket, tr *Trace) gin.H {
+	outcomes := make([]OutcomeOdds, 0, len(m.Outcomes))
+	for _, o := range m.Outcomes {
+		data, _ := tracedPrice(tr, o.Code)
+		if data == nil {
+			continue
+		}
+		p, _ := strconv.ParseFloat(data.Price, 64)
+		prob := p
+		if prob > 1 && prob <= 100 {
+			prob /= 100
+		}
+		outcomes = append(outcomes, OutcomeOdds{Outcome: o.Outcome, Code: o.Code, Price: p, Probability: prob})
+	}
+
+	return gin.H{
+		"market":    m.Market,
+		"venue":     m.Venue,
+		"name":      m.Name,
+		"outcomes":  outcomes,
+		"timestamp": time.Now().Unix(),
+	}
+}
+
+// handlePredictionPrice answers /v1/price for a prediction market code
+// with the probabilities of all its outcomes
+func handlePredictionPrice(c *gin.Context, code string, tr *Trace) {
+	start := time.Now()
+	parts := ai.ParseCode(code)
+	m, ok := catalog.PredictionMarket(parts.Base, parts.Market)
+	tr.add("resolve", start, gin.H{"code": code, "listed": ok, "market": parts.Base, "venue": parts.Market})
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "prediction market not found", "code": code})
+		return
+	}
+	c.JSON(http.StatusOK, withExplain(predictionOdds(m, tr), tr))
+}
+
+// handleOddsQuery answers "odds of X" with the best matching markets
+func handleOddsQuery(c *gin.Context, req QueryRequest, parsed ai.Query, tr *Trace) {
+	start := time.Now()
+	matched := catalog.MatchPredictions(parsed.Topic, 3)
+	names := make([]string, 0, len(matched))
+	for _, m := range matched {
+		names = append(names, m.Venue+":"+m.Market)
+	}
+	markets := allowedMarkets(requestKey(c), matched)
+	tr.add("match_markets", start, gin.H{
+		"topic":   parsed.Topic,
+		"matched": names,
+		"allowed": len(markets),
+	})
+
+	results := make([]gin.H, 0, len(markets))
+	for _, m := range markets {
+		results = append(results, predictionOdds(m, tr))
+	}
+
+	response := gin.H{
+		"query":   req.Query,
+		"lang":    parsed.Lang,
+		"parser":  parsed.Parser,
+		"intent":  parsed.Intent,
+		"topic":   parsed.Topic,
+		"results": results,
+	}
+	if len(results) == 0 {
+		response["message"] = "No prediction market found for this topic. Try GET /v1/markets/prediction?search=..."
+	}
+	c.JSON(http.StatusOK, withExplain(response, tr))
+}
+
+func handlePredictionMarkets(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	markets := allowedMarkets(requestKey(c), catalog.PredictionMarkets(c.Query("search")))
+	total := len(markets)
+	if len(markets) > limit {
+		markets = markets[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"markets": markets,
+		"count":   len(markets),
+		"total":   total,
+	})
+}
+
+// allowedMarkets drops markets with an outcome the key may not price
+func allowedMarkets(key *auth.APIKey, markets []ai.PredictionMarket) []ai.PredictionMarket {
+	kept := markets[:0]
+	for _, m := range markets {
+		allowed := true
+		for _, o := range m.Outcomes {
+			allowed = allowed && scopeDenial(key, o.Code) == ""
+		}
+		if allowed {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
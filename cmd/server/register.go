@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edibez/priceforagent/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// Registration throttling: keys a client IP, and its /24 (IPv4) or /64
+// (IPv6) subnet, may register per window. Zero turns a limit off.
+var (
+	registerIPLimit     int64 = 5
+	registerSubnetLimit int64 = 20
+	registerWindow            = time.Hour
+)
+
+// challenger issues proof-of-work challenges; nil when registering
+// doesn't need one
+var challenger *auth.Challenger
+
+// validAgentID is what an agent_id may look like
+var validAgentID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:@/-]{0,63}$`)
+
+// configureRegistration reads the REGISTER_* environment variables
+func configureRegistration() {
+	if n, err := strconv.ParseInt(os.Getenv("REGISTER_IP_LIMIT"), 10, 64); err == nil && n >= 0 {
+		registerIPLimit = n
+	}
+	if n, err := strconv.ParseInt(os.Getenv("REGISTER_SUBNET_LIMIT"), 10, 64); err == nil && n >= 0 {
+		registerSubnetLimit = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("REGISTER_WINDOW")); err == nil && d > 0 {
+		registerWindow = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("REGISTER_POW_DIFFICULTY")); err == nil && n > 0 {
+		if n > 32 {
+			n = 32
+		}
+		challenger = auth.NewChallenger(os.Getenv("REGISTER_POW_SECRET"), n)
+	}
+}
+
+// trustedProxies reads TRUSTED_PROXIES, the comma separated addresses
+// or CIDRs whose X-Forwarded-For is believed. With none set the client
+// IP is the connection's, so it can't be spoofed to dodge throttling.
+func trustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
+// RegisterRequest is the body of a registration. Challenge and Solution
+// are needed when proof of work is on, InviteCode when registration is
+// invite-only.
+type RegisterRequest struct {
+	AgentID    string `json:"agent_id"`
+	InviteCode string `json:"invite_code"`
+	Challenge  string `json:"challenge"`
+	Solution   string `json:"solution"`
+}
+
+func handleRegister(c *gin.Context) {
+	var req RegisterRequest
+	c.ShouldBindJSON(&req)
+	req.AgentID = strings.TrimSpace(req.AgentID)
+	req.InviteCode = strings.TrimSpace(req.InviteCode)
+	ctx := context.Background()
+
+	wait, err := registerThrottled(ctx, c.ClientIP())
+	if err != nil {
+		log.Printf("Registration throttle error: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Registration is unavailable, try again later",
+			"code":  "registration_unavailable",
+		})
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "Too many registrations from your network, try again later",
+			"code":  "registration_throttled",
+		})
+		return
+	}
+
+	inviteOnly, err := authStore.InviteOnly()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+	if inviteOnly && req.InviteCode == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Registration requires an invite code", "code": "invite_required"})
+		return
+	}
+
+	if req.AgentID != "" && !validAgentID.MatchString(req.AgentID) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "agent_id must be 1-64 letters, digits or . _ : @ / -, starting with a letter or digit",
+			"code":  "invalid_agent_id",
+		})
+		return
+	}
+
+	if challenger != nil {
+		if status, body := checkProofOfWork(ctx, req.Challenge, req.Solution); body != nil {
+			c.JSON(status, body)
+			return
+		}
+	}
+
+	if req.AgentID != "" {
+		taken, err := authStore.AgentIDTaken(req.AgentID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+			return
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{"error": auth.ErrAgentIDTaken.Error(), "code": "agent_id_taken"})
+			return
+		}
+	}
+
+	// An invite use is only taken with the key it issues, so a key that
+	// can't be created (an agent_id taken in a race) leaves it unused
+	var apiKey *auth.APIKey
+	if inviteOnly {
+		apiKey, err = authStore.GenerateInvitedKey(req.InviteCode, req.AgentID, auth.Scopes{})
+	} else {
+		apiKey, err = authStore.GenerateKey(req.AgentID, auth.Scopes{})
+	}
+	if errors.Is(err, auth.ErrInvalidInvite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": "invalid_invite"})
+		return
+	}
+	if errors.Is(err, auth.ErrAgentIDTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": "agent_id_taken"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_key":    apiKey.Key,
+		"key_id":     apiKey.ID,
+		"key_prefix": apiKey.Prefix,
+		"message":    "API key generated successfully. Include this in X-API-Key header.",
+		"created_at": apiKey.CreatedAt,
+	})
+}
+
+// handleRegisterChallenge returns a proof-of-work challenge to solve
+// before registering
+func handleRegisterChallenge(c *gin.Context) {
+	if challenger == nil {
+		c.JSON(http.StatusOK, gin.H{"required": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"required": true, "challenge": challenger.Issue()})
+}
+
+// checkProofOfWork returns the error response for a missing, wrong or
+// reused solution, or a nil body when it is good
+func checkProofOfWork(ctx context.Context, challenge, solution string) (int, gin.H) {
+	if challenge == "" {
+		return http.StatusForbidden, gin.H{
+			"error": "Registration requires a proof-of-work solution, see GET /v1/register/challenge",
+			"code":  "pow_required",
+		}
+	}
+	expires, err := challenger.Verify(challenge, solution)
+	if err != nil {
+		return http.StatusForbidden, gin.H{"error": err.Error(), "code": "invalid_pow"}
+	}
+	first, err := rateLimiter.Claim(ctx, "pow:"+challenge, time.Until(expires)+time.Minute)
+	if err != nil {
+		log.Printf("Proof-of-work replay check error: %v", err)
+		return http.StatusServiceUnavailable, gin.H{
+			"error": "Registration is unavailable, try again later",
+			"code":  "registration_unavailable",
+		}
+	}
+	if !first {
+		return http.StatusForbidden, gin.H{"error": "Challenge has already been used", "code": "invalid_pow"}
+	}
+	return 0, nil
+}
+
+// registerThrottled counts a registration against the client's IP and
+// subnet, and returns how long to wait when either is over its limit.
+// An error means the limits couldn't be checked.
+func registerThrottled(ctx context.Context, ip string) (time.Duration, error) {
+	checks := []struct {
+		key   string
+		limit int64
+	}{
+		{"register:ip:" + ip, registerIPLimit},
+		{"register:net:" + subnet(ip), registerSubnetLimit},
+	}
+	for _, check := range checks {
+		if check.limit == 0 {
+			continue
+		}
+		ok, wait, err := rateLimiter.Throttle(ctx, check.key, check.limit, registerWindow)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return wait, nil
+		}
+	}
+	return 0, nil
+}
+
+// subnet is the network an IP is throttled with: its /24 for IPv4 and
+// its /64 for IPv6
+func subnet(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return parsed.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// RegistrationSettings is how registration is set up
+type RegistrationSettings struct {
+	InviteOnly    bool   `json:"invite_only"`
+	IPLimit       int64  `json:"ip_limit,omitempty"`
+	SubnetLimit   int64  `json:"subnet_limit,omitempty"`
+	Window        string `json:"window,omitempty"`
+	PowDifficulty int    `json:"pow_difficulty,omitempty"`
+}
+
+func handleAdminGetRegistration(c *gin.Context) {
+	inviteOnly, err := authStore.InviteOnly()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	settings := RegistrationSettings{
+		InviteOnly:  inviteOnly,
+		IPLimit:     registerIPLimit,
+		SubnetLimit: registerSubnetLimit,
+		Window:      registerWindow.String(),
+	}
+	if challenger != nil {
+		settings.PowDifficulty = challenger.Difficulty
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// handleAdminSetRegistration turns invite-only registration on or off.
+// The throttle and proof-of-work settings come from the environment.
+func handleAdminSetRegistration(c *gin.Context) {
+	var req struct {
+		InviteOnly *bool `json:"invite_only"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.InviteOnly == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invite_only is required"})
+		return
+	}
+	if err := authStore.SetInviteOnly(*req.InviteOnly); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	handleAdminGetRegistration(c)
+}
+
+// InviteCreateRequest issues an invite code
+type InviteCreateRequest struct {
+	Note      string     `json:"note"`
+	MaxUses   int        `json:"max_uses"` // defaults to 1
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func handleAdminListInvites(c *gin.Context) {
+	invites, err := authStore.ListInvites()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if invites == nil {
+		invites = []auth.Invite{}
+	}
+	c.JSON(http.StatusOK, gin.H{"invites": invites, "count": len(invites)})
+}
+
+func handleAdminCreateInvite(c *gin.Context) {
+	var req InviteCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if req.MaxUses < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_uses must be positive"})
+		return
+	}
+	invite, err := authStore.CreateInvite(strings.TrimSpace(req.Note), req.MaxUses, req.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, invite)
+}
+
+func handleAdminDeleteInvite(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invite id"})
+		return
+	}
+	if err := authStore.DeleteInvite(id); err != nil {
+		if errors.Is(err, auth.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": id})
+}
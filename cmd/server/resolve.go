@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/edibez/priceforagent/internal/ai"
+	"github.com/edibez/priceforagent/internal/price"
+	"github.com/gin-gonic/gin"
+)
+
+// catalog mirrors the cached pairs list for code resolution
+var catalog = ai.NewCatalog()
+
+// startCatalogRefresh loads the pairs cache into the catalog now and
+// every few minutes, so a fresh daily sync is picked up
+func startCatalogRefresh(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(5 * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			loadCatalog(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func loadCatalog(ctx context.Context) {
+	allPairs, err := pairsSyncer.GetAll(ctx)
+	if err != nil || len(allPairs) == 0 {
+		return
+	}
+
+	entries := make([]ai.CatalogEntry, 0, len(allPairs))
+	for _, p := range allPairs {
+		entries = append(entries, ai.CatalogEntry{
+			Code:  p.Code,
+			Name:  p.Name,
+			Base:  p.Base,
+			Quote: p.Quote,
+		})
+	}
+
+	before := catalog.Len()
+	catalog.Load(entries)
+	if before != len(entries) {
+		log.Printf("Catalog loaded: %d pairs", len(entries))
+	}
+}
+
+// ConversionInfo shows how a converted price was derived
+type ConversionInfo struct {
+	From string  `json:"from"`
+	To   string  `json:"to"`
+	Via  string  `json:"via"`
+	Rate float64 `json:"rate"`
+}
+
+// priceResolution fetches the price for a resolved code, applying the
+// quote conversion when the resolution has one. Fetches are added to
+// tr when it is set.
+func priceResolution(res ai.Resolution, tr *Trace) (*PriceResponse, bool) {
+	data, source := tracedPrice(tr, res.Code)
+	if data == nil {
+		return nil, false
+	}
+
+	resp := toPriceResponse(res.Asset, data)
+	resp.Source = source
+	resp.Code = res.Code
+	resp.Venue = res.Venue
+	resp.Unit = ai.Unit(res.Asset)
+
+	if res.Invert && resp.Price != 0 {
+		// The ask of the reverse pair is the bid of this one
+		ask, bid := resp.Ask, resp.Bid
+		resp.Price = 1 / resp.Price
+		resp.Ask, resp.Bid = 0, 0
+		if bid != 0 {
+			resp.Ask = 1 / bid
+		}
+		if ask != 0 {
+			resp.Bid = 1 / ask
+		}
+		resp.Currency = res.Quote
+		resp.Inverted = true
+	}
+
+	if res.Convert != nil {
+		rateData, _ := tracedPrice(tr, res.Convert.Code)
+		if rateData == nil {
+			return nil, false
+		}
+		rate, _ := strconv.ParseFloat(rateData.Price, 64)
+		if rate == 0 {
+			return nil, false
+		}
+		if res.Convert.Invert {
+			rate = 1 / rate
+		}
+
+		resp.Price *= rate
+		resp.Ask *= rate
+		resp.Bid *= rate
+		resp.Currency = res.Quote
+		resp.Conversion = &ConversionInfo{
+			From: res.Convert.From,
+			To:   res.Convert.To,
+			Via:  res.Convert.Code,
+			Rate: rate,
+		}
+	}
+
+	return &resp, true
+}
+
+// tracedPrice is getPriceWithCache recording which cache answered
+func tracedPrice(tr *Trace, code string) (*price.PriceData, string) {
+	start := time.Now()
+	data, source := getPriceWithCache(code)
+	cache := source
+	if data == nil {
+		cache = "miss"
+	}
+	tr.add("price", start, gin.H{"code": code, "cache": cache})
+	return data, source
+}
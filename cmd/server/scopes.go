@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/edibez/priceforagent/internal/ai"
+	"github.com/edibez/priceforagent/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// Route names used in key scopes, by route path
+var endpointScopes = map[string]string{
+	"/v1/query":              "query",
+	"/v1/price/:pair":        "price",
+	"/v1/batch":              "batch",
+	"/v1/pairs":              "pairs",
+	"/v1/usage":              "usage",
+	"/v1/top":                "top",
+	"/v1/markets/prediction": "markets",
+}
+
+// Scope denial codes
+const (
+	scopeEndpoint = "scope_endpoint"
+	scopeClass    = "scope_class"
+	scopePair     = "scope_pair"
+)
+
+// scopeMiddleware rejects routes the scopes of the key, or of its
+// parent, leave out. Asset class and pair scopes are checked by the
+// handlers once codes are resolved.
+func scopeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.MustGet("api_key").(*auth.APIKey)
+		name := endpointScopes[c.FullPath()]
+		if !key.AllowsEndpoint(name) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":    "API key is not allowed to call this endpoint",
+				"code":     scopeEndpoint,
+				"endpoint": name,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// requestKey returns the authenticated key, or nil
+func requestKey(c *gin.Context) *auth.APIKey {
+	v, _ := c.Get("api_key")
+	key, _ := v.(*auth.APIKey)
+	return key
+}
+
+// scopeDenial returns why a key may not price a code, or "" when it
+// may. Conversion pairs used to re-quote a price are not checked.
+func scopeDenial(key *auth.APIKey, code string) string {
+	if key == nil || key.Unrestricted() {
+		return ""
+	}
+	parts := ai.ParseCode(code)
+	if !key.AllowsClass(string(ai.ClassOfType(parts.Type))) {
+		return scopeClass
+	}
+	if !key.AllowsPair(code, parts.Base, parts.Quote) {
+		return scopePair
+	}
+	return ""
+}
+
+// scopeError is the 403 body for a denied code
+func scopeError(code, denial string) gin.H {
+	return gin.H{"error": "API key is not allowed to price " + code, "code": denial, "pair": code}
+}
+
+// ScopesRequest sets a key's scopes
+type ScopesRequest struct {
+	Endpoints []string `json:"endpoints"`
+	Classes   []string `json:"classes"`
+	Pairs     []string `json:"pairs"`
+}
+
+// normalize checks the names and returns the scopes to store
+func (r ScopesRequest) normalize() (auth.Scopes, error) {
+	var s auth.Scopes
+	for _, e := range r.Endpoints {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if !knownEndpoint(e) {
+			return s, fmt.Errorf("unknown endpoint %q", e)
+		}
+		s.Endpoints = append(s.Endpoints, e)
+	}
+	for _, class := range r.Classes {
+		class = strings.ToLower(strings.TrimSpace(class))
+		if !ai.AssetClass(class).Valid() {
+			return s, fmt.Errorf("unknown asset class %q", class)
+		}
+		s.Classes = append(s.Classes, class)
+	}
+	for _, p := range r.Pairs {
+		if p = strings.TrimSpace(p); p != "" {
+			s.Pairs = append(s.Pairs, p)
+		}
+	}
+	return s, nil
+}
+
+func knownEndpoint(name string) bool {
+	for _, n := range endpointScopes {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyCreateRequest issues a key from the admin API
+type KeyCreateRequest struct {
+	AgentID string        `json:"agent_id"`
+	Scopes  ScopesRequest `json:"scopes"`
+}
+
+func handleAdminCreateKey(c *gin.Context) {
+	var req KeyCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	scopes, err := req.Scopes.normalize()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, err := authStore.GenerateKey(req.AgentID, scopes)
+	if errors.Is(err, auth.ErrAgentIDTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+	c.JSON(http.StatusCreated, key)
+}
+
+func handleAdminSetKeyScopes(c *gin.Context) {
+	var req ScopesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	scopes, err := req.normalize()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	withKeyID(c, func(id int64) error { return authStore.SetScopes(id, scopes) })
+}
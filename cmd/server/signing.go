@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/edibez/priceforagent/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// Signed requests send these headers instead of X-API-Key
+const (
+	headerKeyID     = "X-Key-Id" // the key's prefix
+	headerTimestamp = "X-Timestamp"
+	headerNonce     = "X-Nonce"
+	headerSignature = "X-Signature"
+)
+
+// maxSignedBody is the largest request body a signature is checked
+// over; the whole body is read to hash it
+const maxSignedBody = 1 << 20
+
+// signatureWindow is how far a signed request's timestamp may be from
+// the server's clock. Nonces are remembered for twice as long.
+var signatureWindow = 5 * time.Minute
+
+// signedRequestKey checks a signed request and returns its key, or the
+// status and body to refuse it with
+func signedRequestKey(c *gin.Context) (*auth.APIKey, int, gin.H) {
+	prefix := c.GetHeader(headerKeyID)
+	timestamp := c.GetHeader(headerTimestamp)
+	nonce := c.GetHeader(headerNonce)
+	if prefix == "" || timestamp == "" || nonce == "" || len(nonce) > 128 {
+		return nil, http.StatusUnauthorized, gin.H{
+			"error": "Signed requests need X-Key-Id, X-Timestamp, X-Nonce and X-Signature",
+			"code":  "invalid_signature",
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(unix, 0)).Abs() > signatureWindow {
+		return nil, http.StatusUnauthorized, gin.H{
+			"error": "Request timestamp is outside the allowed window",
+			"code":  "signature_expired",
+		}
+	}
+
+	// The key is looked up before the body is read, so unknown keys
+	// can't make the server buffer anything
+	apiKey, err := authStore.ValidateSigningKey(prefix)
+	if err != nil {
+		status, body := keyError(err)
+		return nil, status, body
+	}
+
+	var body []byte
+	if c.Request.Body != nil {
+		body, err = io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSignedBody))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, http.StatusRequestEntityTooLarge, gin.H{"error": "Signed request body is too large", "code": "body_too_large"}
+		}
+		if err != nil {
+			return nil, http.StatusBadRequest, gin.H{"error": "Failed to read request body"}
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	toSign := auth.StringToSign(c.Request.Method, c.Request.URL.RequestURI(), timestamp, nonce, body)
+	if !apiKey.VerifySignature(toSign, c.GetHeader(headerSignature)) {
+		return nil, http.StatusUnauthorized, gin.H{"error": "Request signature does not match", "code": "invalid_signature"}
+	}
+
+	first, err := rateLimiter.Claim(context.Background(), "nonce:"+apiKey.Prefix+":"+nonce, 2*signatureWindow)
+	if err != nil {
+		log.Printf("Nonce check error: %v", err)
+		return nil, http.StatusServiceUnavailable, gin.H{"error": "Signed requests can't be checked right now, try again later", "code": "signature_unavailable"}
+	}
+	if !first {
+		return nil, http.StatusUnauthorized, gin.H{"error": "Nonce has already been used", "code": "replayed_request"}
+	}
+	return apiKey, 0, nil
+}
+
+// KeySigningRequest turns signature enforcement for a key on or off
+type KeySigningRequest struct {
+	Required *bool `json:"required"`
+}
+
+// handleNewSigningSecret issues a signing secret for the caller's key
+// or one of its child keys
+func handleNewSigningSecret(c *gin.Context) {
+	key, ok := ownedKey(c, true)
+	if !ok {
+		return
+	}
+	newSigningSecret(c, key.ID)
+}
+
+func handleAdminNewSigningSecret(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key id"})
+		return
+	}
+	newSigningSecret(c, id)
+}
+
+func newSigningSecret(c *gin.Context, id int64) {
+	secret, err := authStore.NewSigningSecret(id)
+	if err != nil {
+		withKeyID(c, func(int64) error { return err })
+		return
+	}
+	key, err := authStore.GetKey(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"key_id":            key.ID,
+		"key_prefix":        key.Prefix,
+		"signing_secret":    secret,
+		"require_signature": key.RequireSignature,
+		"message":           "Sign requests with this secret and send the key prefix in X-Key-Id. It replaces any previous secret.",
+	})
+}
+
+// handleSetKeySigning makes the caller's key, or one of its child keys,
+// refuse unsigned requests
+func handleSetKeySigning(c *gin.Context) {
+	var req KeySigningRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Required == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "required is required"})
+		return
+	}
+	if _, ok := ownedKey(c, true); !ok {
+		return
+	}
+	withKeyID(c, func(id int64) error { return authStore.SetRequireSignature(id, *req.Required) })
+}
+
+func handleAdminSetKeySigning(c *gin.Context) {
+	var req KeySigningRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Required == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "required is required"})
+		return
+	}
+	withKeyID(c, func(id int64) error { return authStore.SetRequireSignature(id, *req.Required) })
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edibez/priceforagent/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// tokens issues and checks access tokens; tokenKeys caches the keys
+// behind them so a revoked key's tokens stop working within
+// tokenKeyCacheTTL without a database read per request
+var (
+	tokens           *auth.Tokens
+	tokenKeys        = &keyCache{entries: make(map[int64]cachedKey)}
+	tokenKeyCacheTTL = 30 * time.Second
+)
+
+// maxCachedTokenKeys bounds the token key cache
+const maxCachedTokenKeys = 10000
+
+// configureTokens reads the TOKEN_* environment variables
+func configureTokens() {
+	ttl := 15 * time.Minute
+	if d, err := time.ParseDuration(os.Getenv("TOKEN_TTL")); err == nil && d > 0 {
+		ttl = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("TOKEN_REVOCATION_CACHE")); err == nil && d >= 0 {
+		tokenKeyCacheTTL = d
+	}
+	var err error
+	tokens, err = auth.NewTokens(os.Getenv("TOKEN_SIGNING_KEYS"), ttl)
+	if err != nil {
+		log.Fatalf("Invalid TOKEN_SIGNING_KEYS: %v", err)
+	}
+}
+
+// keyCache remembers validated keys, and why invalid ones were refused,
+// for a short while
+type keyCache struct {
+	mu      sync.Mutex
+	entries map[int64]cachedKey
+}
+
+type cachedKey struct {
+	key *auth.APIKey
+	err error
+	at  time.Time
+}
+
+// get returns a copy of the key with an ID, validating it again once
+// the cached result is older than tokenKeyCacheTTL
+func (kc *keyCache) get(id int64) (*auth.APIKey, error) {
+	kc.mu.Lock()
+	entry, ok := kc.entries[id]
+	kc.mu.Unlock()
+
+	if !ok || time.Since(entry.at) >= tokenKeyCacheTTL {
+		key, err := authStore.ValidateKeyID(id)
+		if err != nil && !isKeyRefusal(err) {
+			return nil, err // don't cache database errors
+		}
+		entry = cachedKey{key: key, err: err, at: time.Now()}
+		kc.mu.Lock()
+		if len(kc.entries) >= maxCachedTokenKeys {
+			kc.prune()
+		}
+		kc.entries[id] = entry
+		kc.mu.Unlock()
+	}
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	key := *entry.key
+	return &key, nil
+}
+
+// prune drops stale entries, and all of them if none are stale. The
+// caller holds kc.mu.
+func (kc *keyCache) prune() {
+	for id, entry := range kc.entries {
+		if time.Since(entry.at) >= tokenKeyCacheTTL {
+			delete(kc.entries, id)
+		}
+	}
+	if len(kc.entries) >= maxCachedTokenKeys {
+		kc.entries = make(map[int64]cachedKey)
+	}
+}
+
+func isKeyRefusal(err error) bool {
+	return errors.Is(err, auth.ErrInvalidKey) || errors.Is(err, auth.ErrKeyDisabled) ||
+		errors.Is(err, auth.ErrKeyRevoked) || errors.Is(err, auth.ErrKeyExpired)
+}
+
+// bearerToken returns the token of an Authorization: Bearer header
+func bearerToken(c *gin.Context) string {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// tokenRequestKey checks an access token and returns the key it was
+// exchanged for, limited to the token's scopes, or the status and body
+// to refuse it with
+func tokenRequestKey(c *gin.Context, token string) (*auth.APIKey, int, gin.H) {
+	claims, err := tokens.Parse(token)
+	if err != nil {
+		return nil, http.StatusUnauthorized, gin.H{"error": err.Error(), "code": "invalid_token"}
+	}
+	apiKey, err := tokenKeys.get(claims.KeyID())
+	if err != nil {
+		status, body := keyError(err)
+		return nil, status, body
+	}
+	// A token stops working once its key's scopes are narrowed below it
+	if !claims.Scopes.Within(apiKey.Scopes) {
+		return nil, http.StatusUnauthorized, gin.H{
+			"error": "API key scopes have changed since the token was issued",
+			"code":  "invalid_token",
+		}
+	}
+	apiKey.Scopes = claims.Scopes
+	c.Set("token", claims)
+	return apiKey, 0, nil
+}
+
+// TokenRequest exchanges an API key for an access token, optionally
+// with narrower scopes or a shorter lifetime
+type TokenRequest struct {
+	Scopes *ScopesRequest `json:"scopes"`
+	TTL    string         `json:"ttl"` // duration such as "5m"
+}
+
+// keyOnlyMiddleware refuses requests authenticated with an access
+// token, for routes that issue or change long-lived credentials
+func keyOnlyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("token"); ok {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "This endpoint needs the API key, not an access token",
+				"code":  "key_required",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func handleIssueToken(c *gin.Context) {
+	key := c.MustGet("api_key").(*auth.APIKey)
+
+	var req TokenRequest
+	c.ShouldBindJSON(&req)
+	scopes := key.Scopes
+	if req.Scopes != nil {
+		narrowed, err := req.Scopes.normalize()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !narrowed.Within(key.Scopes) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token scopes must be within the key's"})
+			return
+		}
+		scopes = narrowed
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 || d > tokens.TTL {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ttl must be a positive duration up to " + tokens.TTL.String()})
+			return
+		}
+		ttl = d
+	}
+
+	token, claims := tokens.Issue(key, scopes, ttl)
+	c.JSON(http.StatusCreated, gin.H{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   claims.ExpiresAt - claims.IssuedAt,
+		"expires_at":   time.Unix(claims.ExpiresAt, 0).UTC(),
+		"key_id":       key.ID,
+		"scopes":       claims.Scopes,
+		"plan":         claims.Plan,
+	})
+}
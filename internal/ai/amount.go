@@ -0,0 +1,82 @@
+package ai
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Quantities like "2.5", "1,5", "10,000", "2.5k", "3 million"
+var numberRegex = regexp.MustCompile(`(?i)\d+(?:[.,]\d+)*(?:[kmb]\b|\s*(?:thousand|million|billion|mn|bn)\b)?`)
+
+// Words allowed between a quantity and its asset ("100 NVDA shares",
+// "100 shares of NVDA", "2 troy ounces of gold")
+var amountFillers = map[string]bool{
+	"of": true, "shares": true, "share": true, "coins": true, "coin": true,
+	"tokens": true, "token": true, "units": true, "unit": true,
+	"oz": true, "ounce": true, "ounces": true, "troy": true,
+}
+
+var amountMultipliers = map[string]float64{
+	"k": 1e3, "thousand": 1e3,
+	"m": 1e6, "mn": 1e6, "million": 1e6,
+	"b": 1e9, "bn": 1e9, "billion": 1e9,
+}
+
+// parseAmount converts a quantity matched by numberRegex to a float.
+// decimalComma is set for languages that write "2.500" for 2500.
+func parseAmount(s string, decimalComma bool) (float64, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	end := len(s)
+	for end > 0 && (s[end-1] < '0' || s[end-1] > '9') {
+		end--
+	}
+	digits, suffix := s[:end], strings.TrimSpace(s[end:])
+
+	n, err := strconv.ParseFloat(normalizeDecimal(digits, decimalComma), 64)
+	if err != nil {
+		return 0, false
+	}
+	if suffix != "" {
+		n *= amountMultipliers[suffix]
+	}
+	return n, n > 0
+}
+
+// normalizeDecimal rewrites "1.000,50", "1,5" and "10,000" into the
+// form strconv expects. With both separators the last one is the decimal
+// point. A lone dot is a decimal point; a lone comma is one unless it is
+// followed by exactly three digits ("10,000"). Repeated separators of
+// one kind are thousands separators. With decimalComma the roles of a
+// lone dot and a lone comma swap.
+func normalizeDecimal(s string, decimalComma bool) string {
+	lastDot, lastComma := strings.LastIndex(s, "."), strings.LastIndex(s, ",")
+	switch {
+	case lastDot >= 0 && lastComma >= 0:
+		if lastComma > lastDot {
+			s = strings.ReplaceAll(s, ".", "")
+			return strings.Replace(s, ",", ".", 1)
+		}
+		return strings.ReplaceAll(s, ",", "")
+	case decimalComma && lastComma >= 0:
+		return strings.Replace(s, ",", ".", 1)
+	case decimalComma && lastDot >= 0:
+		parts := strings.Split(s, ".")
+		if len(parts) == 2 && len(parts[1]) != 3 {
+			return s
+		}
+		return strings.Join(parts, "")
+	case lastComma >= 0:
+		parts := strings.Split(s, ",")
+		if len(parts) == 2 && (len(parts[1]) != 3 || parts[0] == "0") {
+			return parts[0] + "." + parts[1]
+		}
+		return strings.Join(parts, "")
+	case lastDot >= 0:
+		if strings.Count(s, ".") > 1 {
+			return strings.ReplaceAll(s, ".", "")
+		}
+	}
+	return s
+}
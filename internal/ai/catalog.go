@@ -0,0 +1,268 @@
+package ai
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CatalogEntry is a single pair listed by the price source
+type CatalogEntry struct {
+	Code   string `json:"code"`
+	Name   string `json:"name,omitempty"`
+	Type   string `json:"type"`
+	Market string `json:"market"`
+	Base   string `json:"base"`
+	Quote  string `json:"quote"`
+}
+
+// Catalog indexes the source's pair list so codes can be resolved
+// against what actually exists instead of guessed
+type Catalog struct {
+	mu     sync.RWMutex
+	byCode map[string]CatalogEntry
+	byBase map[string][]CatalogEntry
+	names  map[string]string // lowercased name or its first word -> base
+	full   map[string]bool   // names that are whole, not a first word
+
+	version uint64 // bumped when a load changes the contents
+}
+
+// NewCatalog creates an empty catalog
+func NewCatalog() *Catalog {
+	return &Catalog{
+		byCode: make(map[string]CatalogEntry),
+		byBase: make(map[string][]CatalogEntry),
+		names:  make(map[string]string),
+		full:   make(map[string]bool),
+	}
+}
+
+// Load replaces the catalog contents
+func (c *Catalog) Load(entries []CatalogEntry) {
+	byCode := make(map[string]CatalogEntry, len(entries))
+	byBase := make(map[string][]CatalogEntry)
+	names := make(map[string]string)
+	full := make(map[string]bool)
+	for _, e := range entries {
+		parsed := ParseCode(e.Code)
+		if e.Type == "" {
+			e.Type = parsed.Type
+		}
+		if e.Market == "" {
+			e.Market = parsed.Market
+		}
+		if e.Base == "" {
+			e.Base = parsed.Base
+		}
+		if e.Quote == "" {
+			e.Quote = parsed.Quote
+		}
+		e.Base = strings.ToUpper(e.Base)
+		e.Quote = strings.ToUpper(e.Quote)
+		byCode[e.Code] = e
+		byBase[e.Base] = append(byBase[e.Base], e)
+
+		if name := strings.ToLower(strings.TrimSpace(e.Name)); name != "" {
+			names[name] = e.Base
+			full[name] = true
+			if fields := strings.Fields(name); len(fields) > 1 && len(fields[0]) >= fuzzyMinLength {
+				if _, taken := names[fields[0]]; !taken && !isStopWord(fields[0]) {
+					names[fields[0]] = e.Base
+				}
+			}
+		}
+	}
+
+	c.mu.Lock()
+	if !sameEntries(c.byCode, byCode) {
+		c.version++
+	}
+	c.byCode = byCode
+	c.byBase = byBase
+	c.names = names
+	c.full = full
+	c.mu.Unlock()
+}
+
+// sameEntries reports whether two loads hold the same pairs
+func sameEntries(a, b map[string]CatalogEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for code, e := range a {
+		if b[code] != e {
+			return false
+		}
+	}
+	return true
+}
+
+// Version changes whenever a load changes the catalog's contents
+func (c *Catalog) Version() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.version
+}
+
+// Len returns the number of pairs in the catalog
+func (c *Catalog) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.byCode)
+}
+
+// Has checks if a code is listed
+func (c *Catalog) Has(code string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.byCode[code]
+	return ok
+}
+
+// Get returns the entry for a code
+func (c *Catalog) Get(code string) (CatalogEntry, bool) {
+	if c == nil {
+		return CatalogEntry{}, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.byCode[code]
+	return e, ok
+}
+
+// Codes returns every listed code, sorted
+func (c *Catalog) Codes() []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	codes := make([]string, 0, len(c.byCode))
+	for code := range c.byCode {
+		codes = append(codes, code)
+	}
+	c.mu.RUnlock()
+	sort.Strings(codes)
+	return codes
+}
+
+// ByBase returns all pairs with the given base asset
+func (c *Catalog) ByBase(base string) []CatalogEntry {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.byBase[strings.ToUpper(base)]
+}
+
+// pairQuery selects catalog pairs; empty fields match anything
+type pairQuery struct {
+	Base   string
+	Quote  string
+	Venue  string
+	Type   string // preferred code type
+	Strict bool   // only pairs of Type
+}
+
+// nameTerms returns the catalog's lowercased names and their bases
+func (c *Catalog) nameTerms() map[string]string {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.names
+}
+
+// Named returns the base of the pair with the given lowercased name.
+// With firstWord set, the first word of a longer name also counts.
+func (c *Catalog) Named(term string, firstWord bool) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	base, ok := c.names[term]
+	if !ok || (!firstWord && !c.full[term]) {
+		return "", false
+	}
+	return base, true
+}
+
+// Known reports whether an asset code is a built-in asset or listed
+func (c *Catalog) Known(asset string) bool {
+	asset = strings.ToUpper(asset)
+	return ClassOf(asset) != "" || len(classCollisions[asset]) > 0 || len(c.ByBase(asset)) > 0
+}
+
+// find returns the best pair for a query, preferring pairs of the
+// query's type, then the aggregated "ALL" market, then an exact quote
+// match over a dollar-equivalent one
+func (c *Catalog) find(pq pairQuery) (CatalogEntry, bool) {
+	var best CatalogEntry
+	bestScore := -1
+	for _, e := range c.ByBase(pq.Base) {
+		if pq.Quote != "" && !SameCurrency(e.Quote, pq.Quote) {
+			continue
+		}
+		if pq.Venue != "" && !strings.EqualFold(e.Market, pq.Venue) {
+			continue
+		}
+		if pq.Strict && e.Type != pq.Type {
+			continue
+		}
+		score := 0
+		if pq.Type != "" && e.Type == pq.Type {
+			score += 4
+		}
+		if e.Market == "ALL" {
+			score += 2
+		}
+		if pq.Quote != "" && e.Quote == strings.ToUpper(pq.Quote) {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = e, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+// CodeParts is a source code split into its components
+type CodeParts struct {
+	Type   string
+	Market string
+	Base   string
+	Quote  string
+}
+
+// ParseCode splits a code like "Crypto:ALL:BTC/USDT"
+func ParseCode(code string) CodeParts {
+	var p CodeParts
+	parts := strings.SplitN(code, ":", 3)
+	if len(parts) != 3 {
+		return p
+	}
+	p.Type, p.Market = parts[0], parts[1]
+	pair := strings.SplitN(parts[2], "/", 2)
+	p.Base = pair[0]
+	if len(pair) == 2 {
+		p.Quote = pair[1]
+	}
+	return p
+}
+
+// String joins the parts back into a code
+func (p CodeParts) String() string {
+	return p.Type + ":" + p.Market + ":" + p.Base + "/" + p.Quote
+}
@@ -0,0 +1,141 @@
+package ai
+
+import "strings"
+
+// AssetClass groups assets by market; values match the lowercased type
+// prefix of source codes ("Crypto:ALL:BTC/USDT" is crypto)
+type AssetClass string
+
+const (
+	ClassCrypto     AssetClass = "crypto"
+	ClassEquity     AssetClass = "equity"
+	ClassMetal      AssetClass = "metal"
+	ClassForex      AssetClass = "forex"
+	ClassCommodity  AssetClass = "commodity"
+	ClassPrediction AssetClass = "prediction"
+)
+
+// Valid reports whether c is a known class
+func (c AssetClass) Valid() bool {
+	switch c {
+	case ClassCrypto, ClassEquity, ClassMetal, ClassForex, ClassCommodity, ClassPrediction:
+		return true
+	}
+	return false
+}
+
+// Candidate is one possible reading of an ambiguous match
+type Candidate struct {
+	Asset string     `json:"asset"`
+	Class AssetClass `json:"class"`
+	Name  string     `json:"name,omitempty"`
+	Code  string     `json:"code"`
+}
+
+// Tickers that name different assets in different classes, most common
+// reading first
+var classCollisions = map[string][]Candidate{
+	"META": {
+		{Asset: "META", Class: ClassEquity, Name: "Meta Platforms"},
+		{Asset: "META", Class: ClassCrypto, Name: "Metadium"},
+	},
+	"SOL": {
+		{Asset: "SOL", Class: ClassCrypto, Name: "Solana"},
+		{Asset: "PEN", Class: ClassForex, Name: "Peruvian sol"},
+	},
+}
+
+// Aliases that are also everyday English words. Written in lowercase
+// without any price context they are more likely the word.
+var commonWords = map[string]bool{
+	"link": true, "dot": true, "near": true, "op": true, "uni": true,
+	"atom": true, "render": true, "apt": true, "sui": true, "meta": true,
+	"corn": true, "sugar": true, "coffee": true, "cotton": true,
+}
+
+// Words next to a match that settle its class
+var classCues = map[string]AssetClass{
+	"stock": ClassEquity, "stocks": ClassEquity, "share": ClassEquity, "shares": ClassEquity,
+	"equity": ClassEquity, "nasdaq": ClassEquity, "nyse": ClassEquity,
+	"token": ClassCrypto, "tokens": ClassCrypto, "coin": ClassCrypto, "coins": ClassCrypto,
+	"crypto": ClassCrypto, "currency": ClassForex, "peruvian": ClassForex,
+	"commodity": ClassCommodity, "commodities": ClassCommodity, "futures": ClassCommodity,
+}
+
+// Words next to a common-word alias that mark it as an asset
+var priceCues = map[string]bool{
+	"price": true, "prices": true, "worth": true, "value": true, "buy": true, "sell": true,
+	"token": true, "coin": true, "chart": true,
+}
+
+// ClassOf returns the built-in class for an asset code, or "" if unknown
+func ClassOf(asset string) AssetClass {
+	asset = strings.ToUpper(asset)
+	if class, builtin := classOverride(asset); !builtin {
+		return class
+	}
+	switch {
+	case cryptoAssets[asset]:
+		return ClassCrypto
+	case equityAssets[asset]:
+		return ClassEquity
+	case metalAssets[asset]:
+		return ClassMetal
+	case forexAssets[asset]:
+		return ClassForex
+	case commodityAssets[asset]:
+		return ClassCommodity
+	}
+	return ""
+}
+
+// ClassOfType maps a code type prefix ("Crypto") to its class
+func ClassOfType(typ string) AssetClass {
+	return AssetClass(strings.ToLower(typ))
+}
+
+// codeType maps a class to its code type prefix
+func codeType(class AssetClass) string {
+	if class == "" {
+		return ""
+	}
+	return strings.ToUpper(string(class[:1])) + string(class[1:])
+}
+
+// Candidates lists the readings of an asset code: built-in collisions
+// plus every class the catalog lists it under. A single candidate means
+// the code is unambiguous.
+func (c *Catalog) Candidates(asset string) []Candidate {
+	asset = strings.ToUpper(asset)
+	var cands []Candidate
+	seen := make(map[AssetClass]bool)
+
+	for _, cand := range classCollisions[asset] {
+		cand.Code = c.Resolve(Target{Asset: cand.Asset, Class: cand.Class}).Code
+		cands = append(cands, cand)
+		seen[cand.Class] = true
+	}
+	for _, e := range c.ByBase(asset) {
+		class := ClassOfType(e.Type)
+		if seen[class] {
+			continue
+		}
+		seen[class] = true
+		cands = append(cands, Candidate{Asset: asset, Class: class, Name: e.Name, Code: e.Code})
+	}
+
+	if len(cands) == 0 {
+		cands = append(cands, Candidate{Asset: asset, Class: ClassOf(asset), Code: BuildCode(asset)})
+	}
+	return cands
+}
+
+// pickCandidate returns the candidate of the given class
+func pickCandidate(cands []Candidate, class AssetClass) (Candidate, bool) {
+	for _, cand := range cands {
+		if cand.Class == class {
+			return cand, true
+		}
+	}
+	return Candidate{}, false
+}
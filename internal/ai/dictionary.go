@@ -0,0 +1,74 @@
+package ai
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// Dictionary is the runtime-editable word list. Once loaded it replaces
+// the built-in aliases and ticker classes, which become its seed data.
+type Dictionary struct {
+	Aliases map[string]map[string]string // language code -> word -> asset
+	Classes map[string]AssetClass        // asset -> class
+	Blocked map[string]bool              // words never read as assets
+}
+
+var (
+	dictionary        atomic.Pointer[Dictionary]
+	dictionaryVersion atomic.Uint64 // bumped by every load
+)
+
+// LoadDictionary swaps in a dictionary for all following parses
+func LoadDictionary(d Dictionary) {
+	dictionary.Store(&d)
+	dictionaryVersion.Add(1)
+}
+
+// SeedDictionary returns the built-in aliases and ticker classes
+func SeedDictionary() Dictionary {
+	d := Dictionary{
+		Aliases: make(map[string]map[string]string),
+		Classes: make(map[string]AssetClass),
+		Blocked: make(map[string]bool),
+	}
+	for code, lang := range Languages {
+		d.Aliases[code] = lang.Aliases
+	}
+	for class, assets := range map[AssetClass]map[string]bool{
+		ClassCrypto:    cryptoAssets,
+		ClassEquity:    equityAssets,
+		ClassMetal:     metalAssets,
+		ClassForex:     forexAssets,
+		ClassCommodity: commodityAssets,
+	} {
+		for asset := range assets {
+			d.Classes[asset] = class
+		}
+	}
+	return d
+}
+
+// aliasMap returns the pack's aliases from the loaded dictionary, or the
+// built-in ones before any is loaded
+func (l *Language) aliasMap() map[string]string {
+	if d := dictionary.Load(); d != nil {
+		return d.Aliases[l.Code]
+	}
+	return l.Aliases
+}
+
+// classOverride returns the loaded dictionary's class for an asset;
+// builtin is false before any dictionary is loaded
+func classOverride(asset string) (class AssetClass, builtin bool) {
+	d := dictionary.Load()
+	if d == nil {
+		return "", true
+	}
+	return d.Classes[asset], false
+}
+
+// isBlocked reports whether a word may never be read as an asset
+func isBlocked(word string) bool {
+	d := dictionary.Load()
+	return d != nil && d.Blocked[strings.ToLower(word)]
+}
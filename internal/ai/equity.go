@@ -0,0 +1,164 @@
+package ai
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Market is an equity market as the source names it in codes
+// ("Equity:JP:7203/JPY"), with its trading currency
+type Market struct {
+	Code     string
+	Currency string
+	Region   string // wider market the source may list it under instead
+}
+
+// Equity markets by code
+var markets = map[string]Market{
+	"US": {Code: "US", Currency: "USD"},
+	"JP": {Code: "JP", Currency: "JPY"},
+	"HK": {Code: "HK", Currency: "HKD"},
+	"KR": {Code: "KR", Currency: "KRW"},
+	"ID": {Code: "ID", Currency: "IDR"},
+	"SG": {Code: "SG", Currency: "SGD"},
+	"AU": {Code: "AU", Currency: "AUD"},
+	"CA": {Code: "CA", Currency: "CAD"},
+	"GB": {Code: "GB", Currency: "GBP", Region: "EU"},
+	"CH": {Code: "CH", Currency: "CHF", Region: "EU"},
+	"DE": {Code: "DE", Currency: "EUR", Region: "EU"},
+	"FR": {Code: "FR", Currency: "EUR", Region: "EU"},
+	"NL": {Code: "NL", Currency: "EUR", Region: "EU"},
+	"IT": {Code: "IT", Currency: "EUR", Region: "EU"},
+	"ES": {Code: "ES", Currency: "EUR", Region: "EU"},
+	"EU": {Code: "EU", Currency: "EUR"},
+}
+
+// Ticker suffixes ("7203.T") and their markets
+var exchangeSuffixes = map[string]string{
+	"T": "JP", "HK": "HK", "KS": "KR", "KQ": "KR", "JK": "ID", "SI": "SG",
+	"AX": "AU", "TO": "CA", "V": "CA", "L": "GB", "SW": "CH",
+	"DE": "DE", "F": "DE", "PA": "FR", "AS": "NL", "MI": "IT", "MC": "ES",
+	"US": "US",
+}
+
+// Exchange prefixes ("TSE:7203") and their markets
+var exchangePrefixes = map[string]string{
+	"TSE": "JP", "TYO": "JP", "JPX": "JP",
+	"HKEX": "HK", "SEHK": "HK",
+	"KRX": "KR", "KOSPI": "KR", "KOSDAQ": "KR",
+	"IDX": "ID", "SGX": "SG", "ASX": "AU", "TSX": "CA",
+	"LON": "GB", "LSE": "GB", "SIX": "CH",
+	"XETRA": "DE", "ETR": "DE", "FRA": "DE", "EPA": "FR", "AMS": "NL", "BIT": "IT", "BME": "ES",
+	"NASDAQ": "US", "NYSE": "US", "AMEX": "US",
+}
+
+// Ticker is an equity symbol qualified by its market
+type Ticker struct {
+	Symbol string `json:"symbol"`
+	Market string `json:"market"`
+}
+
+var (
+	suffixTickerRegex = regexp.MustCompile(`\b([A-Za-z0-9]{1,6})\.([A-Za-z]{1,2})\b`)
+	prefixTickerRegex = regexp.MustCompile(`\b([A-Za-z]{3,6}):([A-Za-z0-9]{1,6})\b`)
+)
+
+// ParseTicker reads an exchange-qualified ticker such as "7203.T",
+// "0700.HK", "SAP.DE" or "TSE:7203"
+func ParseTicker(s string) (Ticker, bool) {
+	s = strings.TrimSpace(s)
+	if m := prefixTickerRegex.FindStringSubmatch(s); m != nil && m[0] == s {
+		if market, ok := exchangePrefixes[strings.ToUpper(m[1])]; ok {
+			return Ticker{Symbol: strings.ToUpper(m[2]), Market: market}, true
+		}
+	}
+	if m := suffixTickerRegex.FindStringSubmatch(s); m != nil && m[0] == s {
+		if market, ok := exchangeSuffixes[strings.ToUpper(m[2])]; ok {
+			return Ticker{Symbol: strings.ToUpper(m[1]), Market: market}, true
+		}
+	}
+	return Ticker{}, false
+}
+
+// PairTarget reads a price path segment into a resolution target:
+// a qualified ticker ("7203.T") or a pair as ParsePair reads it
+func PairTarget(pair string) Target {
+	if t, ok := ParseTicker(pair); ok {
+		return Target{Asset: t.Symbol, Market: t.Market, Class: ClassEquity}
+	}
+	asset, quote, venue := ParsePair(pair)
+	return Target{Asset: asset, Quote: quote, Venue: venue}
+}
+
+// tickerSpan is a qualified ticker found in query text
+type tickerSpan struct {
+	Ticker
+	Start, End int
+}
+
+// findTickers returns the qualified tickers in text, in order
+func findTickers(text string) []tickerSpan {
+	var spans []tickerSpan
+	for _, re := range []*regexp.Regexp{prefixTickerRegex, suffixTickerRegex} {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			if t, ok := ParseTicker(text[loc[0]:loc[1]]); ok {
+				spans = append(spans, tickerSpan{Ticker: t, Start: loc[0], End: loc[1]})
+			}
+		}
+	}
+	// Keep the first of overlapping spans, in text order
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	var out []tickerSpan
+	for _, s := range spans {
+		if len(out) == 0 || s.Start >= out[len(out)-1].End {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// MarketCurrency returns the trading currency of an equity market
+func MarketCurrency(market string) string {
+	if m, ok := markets[strings.ToUpper(market)]; ok {
+		return m.Currency
+	}
+	return "USD"
+}
+
+// equityCode builds the code for a stock on a market, quoted locally
+func equityCode(asset, market string) string {
+	market = strings.ToUpper(market)
+	if market == "" {
+		market = "US"
+	}
+	return CodeParts{Type: "Equity", Market: market, Base: asset, Quote: MarketCurrency(market)}.String()
+}
+
+// resolveListing resolves a stock on a given market. The source may
+// list European stocks under the wider "EU" market.
+func (c *Catalog) resolveListing(t Target) Resolution {
+	asset := strings.ToUpper(t.Asset)
+	quote := strings.ToUpper(t.Quote)
+	def := ParseCode(equityCode(asset, t.Market))
+	res := Resolution{Asset: asset, Code: def.String(), Quote: def.Quote}
+
+	candidates := []string{def.Market}
+	if region := markets[def.Market].Region; region != "" {
+		candidates = append(candidates, region)
+	}
+	for _, market := range candidates {
+		for _, q := range []string{quote, def.Quote, ""} {
+			pq := pairQuery{Base: asset, Quote: q, Venue: market, Type: def.Type, Strict: true}
+			if e, ok := c.find(pq); ok {
+				return c.listed(res, e, quote)
+			}
+		}
+	}
+
+	if quote != "" && !SameCurrency(quote, def.Quote) {
+		res.Quote = quote
+		res.Convert = c.conversion(def.Quote, quote)
+	}
+	return res
+}
@@ -0,0 +1,135 @@
+package ai
+
+import (
+	"sort"
+	"strings"
+)
+
+// Fuzzy matching tolerates typos like "etherium" or "nvidea". A term
+// matches when its confidence (1 - edits/length) reaches the threshold,
+// which allows one edit from five letters and two from ten. Short words
+// are often real words one edit from an asset ("metal", "apples"), so a
+// match only stands in for the word when it is sure: from six letters
+// and one edit in seven.
+const (
+	fuzzyMinLength = 5
+	fuzzyMaxEdits  = 2
+	fuzzyThreshold = 0.8
+
+	sureMinLength = 6
+	sureThreshold = 0.85
+)
+
+// Suggestion is a fuzzy match of a word against an asset name or alias
+type Suggestion struct {
+	Asset      string  `json:"asset"`
+	Term       string  `json:"term"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Suggest returns up to limit assets whose aliases or catalog names are
+// close to word, best first
+func Suggest(word string, c *Catalog, limit int) []Suggestion {
+	word = strings.ToLower(word)
+	best := make(map[string]Suggestion)
+
+	consider := func(term, asset string) {
+		if abs(len(term)-len(word)) > fuzzyMaxEdits {
+			return
+		}
+		dist := editDistance(word, term)
+		if dist > fuzzyMaxEdits {
+			return
+		}
+		longest := len([]rune(word))
+		if n := len([]rune(term)); n > longest {
+			longest = n
+		}
+		conf := 1 - float64(dist)/float64(longest)
+		if prev, ok := best[asset]; !ok || conf > prev.Confidence {
+			best[asset] = Suggestion{Asset: asset, Term: term, Confidence: conf}
+		}
+	}
+
+	for _, lang := range allVocab() {
+		for alias, asset := range lang.aliasMap() {
+			consider(alias, asset)
+		}
+	}
+	for term, asset := range c.nameTerms() {
+		consider(term, asset)
+	}
+
+	suggestions := make([]Suggestion, 0, len(best))
+	for _, s := range best {
+		suggestions = append(suggestions, s)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Confidence != suggestions[j].Confidence {
+			return suggestions[i].Confidence > suggestions[j].Confidence
+		}
+		return suggestions[i].Asset < suggestions[j].Asset
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
+
+// Correct returns the confident fuzzy match for a misspelled word, if any
+func Correct(word string, c *Catalog) (Suggestion, bool) {
+	lower := strings.ToLower(word)
+	if len([]rune(lower)) < fuzzyMinLength || isStopWord(lower) || isBlocked(lower) {
+		return Suggestion{}, false
+	}
+	suggestions := Suggest(lower, c, 2)
+	if len(suggestions) == 0 || suggestions[0].Confidence < fuzzyThreshold || suggestions[0].Term == lower {
+		return Suggestion{}, false
+	}
+	// Two equally close assets is a guess, not a correction
+	if len(suggestions) == 2 && suggestions[1].Confidence == suggestions[0].Confidence {
+		return Suggestion{}, false
+	}
+	return suggestions[0], true
+}
+
+// Sure reports whether a correction of word is close enough to use in
+// its place; others are only offered as suggestions
+func Sure(word string, fix Suggestion) bool {
+	return len([]rune(word)) >= sureMinLength && fix.Confidence >= sureThreshold
+}
+
+// editDistance is the optimal string alignment distance: insertions,
+// deletions, substitutions and adjacent transpositions each cost one
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
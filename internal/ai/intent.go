@@ -0,0 +1,164 @@
+package ai
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Intent is what a query asks for
+type Intent string
+
+const (
+	IntentPrice   Intent = "price"   // current price, or value when amounts are given
+	IntentChange  Intent = "change"  // 24h move of each asset
+	IntentCompare Intent = "compare" // two or more assets side by side
+	IntentRatio   Intent = "ratio"   // first asset priced in the second
+	IntentRank    Intent = "rank"    // top N by market cap
+	IntentOdds    Intent = "odds"    // prediction market probabilities for Query.Topic
+)
+
+// Comparison metrics
+const (
+	MetricPrice  = "price"
+	MetricChange = "change_24h"
+)
+
+const defaultRankLimit = 10
+
+var compareWords = map[string]bool{
+	"vs": true, "versus": true, "compare": true, "compared": true, "comparison": true,
+	"than": true, "which": true, "cheaper": true, "cheapest": true, "expensive": true,
+	"pricier": true, "better": true, "worse": true, "outperform": true, "outperformed": true,
+}
+
+var changeWords = map[string]bool{
+	"change": true, "changed": true, "move": true, "moved": true, "movement": true,
+	"gain": true, "gained": true, "gains": true, "drop": true, "dropped": true,
+	"fell": true, "fall": true, "rose": true, "rise": true, "risen": true,
+	"up": true, "down": true, "perform": true, "performed": true, "performance": true,
+	"24h": true, "pump": true, "pumped": true, "dump": true, "dumped": true,
+}
+
+// Words that flip a comparison to ascending order
+var ascendingWords = map[string]bool{
+	"cheaper": true, "cheapest": true, "lower": true, "lowest": true,
+	"down": true, "fell": true, "dropped": true, "worse": true, "dump": true, "dumped": true,
+}
+
+// Words that ask for a ranking; true when they need no count or noun
+var rankWords = map[string]bool{
+	"top": false, "biggest": false, "largest": false, "ranking": true, "rankings": true,
+}
+
+var rankNouns = map[string]bool{
+	"coins": true, "coin": true, "crypto": true, "cryptos": true, "cryptocurrencies": true,
+	"tokens": true, "assets": true,
+}
+
+var ratioPhrases = []string{" in terms of ", " relative to ", " ratio ", " denominated in "}
+
+var numberWords = map[string]int{
+	"three": 3, "five": 5, "ten": 10, "twenty": 20, "fifty": 50, "hundred": 100,
+}
+
+// detectIntent classifies the query once assets have been extracted.
+// Rankings need no assets and comparisons need at least two. Ratios need
+// two assets, or one asset quoted in a crypto ("ETH/BTC ratio").
+func detectIntent(q *Query, words []string, v vocab) {
+	q.Intent = IntentPrice
+	text := " " + strings.Join(words, " ") + " "
+
+	if topic, ok := detectOdds(words, v); ok {
+		q.Intent = IntentOdds
+		q.Topic = topic
+		return
+	}
+
+	if limit, ok := rankLimit(words, v); ok {
+		q.Intent = IntentRank
+		q.Limit = limit
+		return
+	}
+
+	if len(q.Matches) == 1 && ClassOf(q.Quote) == ClassCrypto {
+		q.Intent = IntentRatio
+		return
+	}
+	if len(q.Matches) >= 2 {
+		for _, lang := range v {
+			for _, phrase := range lang.RatioPhrases {
+				if strings.Contains(text, phrase) {
+					q.Intent = IntentRatio
+					return
+				}
+			}
+		}
+	}
+
+	compare, change, ascending := false, false, false
+	for _, w := range words {
+		compare = compare || v.has(w, compareSet)
+		change = change || v.has(w, changeSet)
+		ascending = ascending || v.has(w, ascendingSet)
+	}
+
+	if len(q.Matches) >= 2 && compare {
+		q.Intent = IntentCompare
+		q.Metric = MetricPrice
+		if change {
+			q.Metric = MetricChange
+		}
+		q.Order = "desc"
+		if ascending {
+			q.Order = "asc"
+		}
+		return
+	}
+
+	if change && len(q.Matches) > 0 {
+		q.Intent = IntentChange
+	}
+}
+
+// rankLimit recognizes "top 5 coins", "top coins", "biggest cryptos"
+// and, for languages that put the rank word last, "5 koin teratas". It
+// returns how many entries were asked for.
+func rankLimit(words []string, v vocab) (int, bool) {
+	for i, w := range words {
+		standalone, ok := v.rankWord(w)
+		if !ok {
+			continue
+		}
+		if standalone {
+			return defaultRankLimit, true
+		}
+		near := []int{i + 1, i - 1, i + 2, i - 2}
+		for _, j := range near {
+			if j < 0 || j >= len(words) {
+				continue
+			}
+			if n, err := strconv.Atoi(words[j]); err == nil {
+				return clampLimit(n), true
+			}
+			if n, ok := numberWords[words[j]]; ok {
+				return n, true
+			}
+		}
+		for _, j := range near[:2] {
+			if j >= 0 && j < len(words) && v.has(words[j], rankNounSet) {
+				return defaultRankLimit, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func clampLimit(n int) int {
+	if n < 1 {
+		return 1
+	}
+	if n > 100 {
+		return 100
+	}
+	return n
+}
@@ -0,0 +1,373 @@
+package ai
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Language is a pack of the words a query language uses for assets,
+// currencies and intents. Packs other than English are consulted first
+// and fall back to English, since tickers and English asset names are
+// used in every language.
+type Language struct {
+	Code         string
+	Name         string
+	DecimalComma bool // "2.500" is two thousand five hundred, "2,5" is two and a half
+
+	Aliases      map[string]string     // asset names
+	Currencies   map[string]string     // quote currency names
+	StopWords    map[string]bool       // never assets; also drive language detection
+	QuoteMarkers map[string]bool       // "in EUR"
+	VenueMarkers map[string]bool       // "on Binance"
+	Fillers      map[string]bool       // between a quantity and its asset
+	ClassCues    map[string]AssetClass // "stock", "token"
+	PriceCues    map[string]bool       // mark a common word as an asset
+	Compare      map[string]bool
+	Change       map[string]bool
+	Ascending    map[string]bool
+	Rank         map[string]bool // true: a ranking on its own ("ranking"); false: needs a count or noun ("top")
+	RankNouns    map[string]bool
+	RatioPhrases []string
+	Odds         []string // phrases before a prediction market topic
+}
+
+var englishStopWords = map[string]bool{
+	"the": true, "what": true, "whats": true, "is": true, "are": true, "of": true,
+	"how": true, "much": true, "many": true, "for": true, "and": true, "me": true,
+	"show": true, "give": true, "tell": true, "now": true, "was": true, "did": true,
+	"price": true, "prices": true, "worth": true, "value": true, "about": true,
+	"today": true, "which": true, "there": true, "their": true, "where": true,
+	"would": true, "could": true, "should": true, "current": true, "market": true,
+	"right": true, "hello": true, "thanks": true, "please": true, "apply": true,
+	"tender": true, "goggle": true, "cheaper": true, "higher": true,
+	"lower": true, "coins": true, "token": true, "tokens": true, "shares": true,
+	"stock": true, "stocks": true, "dollars": true, "against": true, "compare": true,
+}
+
+var english = &Language{
+	Code:         "en",
+	Name:         "English",
+	Aliases:      assetAliases,
+	Currencies:   currencyAliases,
+	StopWords:    englishStopWords,
+	QuoteMarkers: quoteMarkers,
+	VenueMarkers: venueMarkers,
+	Fillers:      amountFillers,
+	ClassCues:    classCues,
+	PriceCues:    priceCues,
+	Compare:      compareWords,
+	Change:       changeWords,
+	Ascending:    ascendingWords,
+	Rank:         rankWords,
+	RankNouns:    rankNouns,
+	RatioPhrases: ratioPhrases,
+	Odds:         oddsPhrases,
+}
+
+var indonesian = &Language{
+	Code:         "id",
+	Name:         "Indonesian",
+	DecimalComma: true,
+	Aliases: map[string]string{
+		"emas":    "XAU",
+		"perak":   "XAG",
+		"minyak":  "WTI",
+		"tembaga": "COPPER",
+		"kopi":    "COFFEE",
+		"gula":    "SUGAR",
+		"dolar":   "USD",
+		"bca":     "BBCA",
+		"bri":     "BBRI",
+		"bni":     "BBNI",
+		"mandiri": "BMRI",
+		"telkom":  "TLKM",
+		"astra":   "ASII",
+	},
+	Currencies: map[string]string{
+		"dolar": "USD", "rupiah": "IDR", "rp": "IDR", "ringgit": "MYR", "yen": "JPY",
+	},
+	StopWords: map[string]bool{
+		"harga": true, "berapa": true, "hari": true, "ini": true, "sekarang": true,
+		"saat": true, "apa": true, "yang": true, "dan": true, "untuk": true,
+		"saham": true, "koin": true, "nilai": true, "kurs": true, "dengan": true,
+		"lebih": true, "mana": true, "tolong": true, "cek": true, "kemarin": true,
+		"peluang": true, "kemungkinan": true,
+	},
+	QuoteMarkers: map[string]bool{"dalam": true, "ke": true, "jadi": true},
+	VenueMarkers: map[string]bool{"di": true},
+	Fillers:      map[string]bool{"lembar": true, "saham": true, "koin": true, "keping": true},
+	ClassCues: map[string]AssetClass{
+		"saham": ClassEquity, "emiten": ClassEquity, "koin": ClassCrypto, "kripto": ClassCrypto,
+		"mata": ClassForex,
+	},
+	PriceCues: map[string]bool{"harga": true, "nilai": true, "beli": true, "jual": true},
+	Compare: map[string]bool{
+		"dibanding": true, "dibandingkan": true, "banding": true, "bandingkan": true,
+		"vs": true, "atau": true, "murah": true, "mahal": true, "mana": true,
+	},
+	Change: map[string]bool{
+		"naik": true, "turun": true, "berubah": true, "perubahan": true, "kenaikan": true,
+		"penurunan": true, "bergerak": true, "anjlok": true, "melonjak": true,
+	},
+	Ascending: map[string]bool{"murah": true, "termurah": true, "turun": true, "anjlok": true},
+	Rank:      map[string]bool{"top": false, "teratas": false, "terbesar": false, "peringkat": true},
+	RankNouns: map[string]bool{"koin": true, "kripto": true, "saham": true, "aset": true},
+	RatioPhrases: []string{
+		" dalam satuan ", " rasio ",
+	},
+	Odds: []string{"peluang", "kemungkinan", "probabilitas"},
+}
+
+var spanish = &Language{
+	Code:         "es",
+	Name:         "Spanish",
+	DecimalComma: true,
+	Aliases: map[string]string{
+		"oro":      "XAU",
+		"plata":    "XAG",
+		"petróleo": "WTI",
+		"petroleo": "WTI",
+		"cobre":    "COPPER",
+		"trigo":    "WHEAT",
+		"dólar":    "USD",
+		"dolar":    "USD",
+	},
+	Currencies: map[string]string{
+		"dólar": "USD", "dolar": "USD", "dólares": "USD", "dolares": "USD",
+		"euro": "EUR", "euros": "EUR", "peso": "MXN", "pesos": "MXN", "yen": "JPY",
+	},
+	StopWords: map[string]bool{
+		"precio": true, "cuánto": true, "cuanto": true, "cuál": true, "cual": true,
+		"qué": true, "que": true, "el": true, "la": true, "los": true, "las": true,
+		"del": true, "de": true, "hoy": true, "ahora": true, "vale": true,
+		"es": true, "está": true, "y": true, "acciones": true, "monedas": true,
+	},
+	QuoteMarkers: map[string]bool{"en": true, "a": true},
+	VenueMarkers: map[string]bool{"en": true},
+	Fillers:      map[string]bool{"de": true, "acciones": true, "acción": true, "monedas": true},
+	ClassCues: map[string]AssetClass{
+		"acción": ClassEquity, "acciones": ClassEquity, "moneda": ClassCrypto, "monedas": ClassCrypto,
+		"cripto": ClassCrypto, "divisa": ClassForex,
+	},
+	PriceCues: map[string]bool{"precio": true, "valor": true, "comprar": true, "vender": true},
+	Compare: map[string]bool{
+		"contra": true, "comparado": true, "comparar": true, "vs": true, "barato": true,
+		"caro": true, "mejor": true, "peor": true,
+	},
+	Change: map[string]bool{
+		"subió": true, "subio": true, "bajó": true, "bajo": true, "cambio": true,
+		"cambió": true, "sube": true, "baja": true, "movió": true, "movio": true,
+	},
+	Ascending: map[string]bool{"barato": true, "baratas": true, "bajó": true, "bajo": true, "baja": true, "peor": true},
+	Rank:      map[string]bool{"top": false, "principales": false, "mayores": false, "ranking": true},
+	RankNouns: map[string]bool{"monedas": true, "criptomonedas": true, "criptos": true},
+	RatioPhrases: []string{
+		" en términos de ", " en terminos de ",
+	},
+	Odds: []string{"probabilidad de", "probabilidad que", "probabilidades de", "posibilidades de"},
+}
+
+var portuguese = &Language{
+	Code:         "pt",
+	Name:         "Portuguese",
+	DecimalComma: true,
+	Aliases: map[string]string{
+		"ouro":     "XAU",
+		"prata":    "XAG",
+		"petróleo": "WTI",
+		"petroleo": "WTI",
+		"cobre":    "COPPER",
+		"trigo":    "WHEAT",
+		"dólar":    "USD",
+	},
+	Currencies: map[string]string{
+		"dólar": "USD", "dólares": "USD", "dolar": "USD", "real": "BRL", "reais": "BRL",
+		"euro": "EUR", "euros": "EUR",
+	},
+	StopWords: map[string]bool{
+		"preço": true, "preco": true, "quanto": true, "qual": true, "o": true,
+		"os": true, "da": true, "dos": true, "hoje": true, "agora": true,
+		"está": true, "vale": true, "e": true, "ações": true, "moedas": true, "cotação": true,
+	},
+	QuoteMarkers: map[string]bool{"em": true, "para": true},
+	VenueMarkers: map[string]bool{"na": true, "no": true},
+	Fillers:      map[string]bool{"de": true, "ações": true, "ação": true, "moedas": true},
+	ClassCues: map[string]AssetClass{
+		"ação": ClassEquity, "ações": ClassEquity, "moeda": ClassCrypto, "moedas": ClassCrypto,
+		"cripto": ClassCrypto,
+	},
+	PriceCues: map[string]bool{"preço": true, "preco": true, "cotação": true, "valor": true},
+	Compare: map[string]bool{
+		"contra": true, "comparado": true, "comparar": true, "vs": true, "barato": true,
+		"caro": true, "melhor": true, "pior": true,
+	},
+	Change: map[string]bool{
+		"subiu": true, "caiu": true, "variação": true, "variacao": true, "sobe": true,
+		"cai": true, "mudou": true, "alta": true, "queda": true,
+	},
+	Ascending: map[string]bool{"barato": true, "caiu": true, "cai": true, "queda": true, "pior": true},
+	Rank:      map[string]bool{"top": false, "principais": false, "maiores": false, "ranking": true},
+	RankNouns: map[string]bool{"moedas": true, "criptomoedas": true, "criptos": true},
+	RatioPhrases: []string{
+		" em termos de ",
+	},
+	Odds: []string{"chance de", "chances de", "probabilidade de"},
+}
+
+// Languages are the available packs by code
+var Languages = map[string]*Language{
+	english.Code:    english,
+	indonesian.Code: indonesian,
+	spanish.Code:    spanish,
+	portuguese.Code: portuguese,
+}
+
+// DetectLanguage picks the pack whose stop words and vocabulary best
+// cover the query words, defaulting to English. Ties go to English,
+// then to the first code alphabetically, so a query shared by two
+// packs ("vale bitcoin") always gets the same language.
+func DetectLanguage(words []string) string {
+	best, bestScore := english.Code, 0
+	for _, lang := range allVocab() {
+		score := 0
+		for _, w := range words {
+			if lang.StopWords[w] || lang.Change[w] || lang.Compare[w] {
+				score++
+			}
+			if _, ok := lang.aliasMap()[w]; ok && lang != english {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = lang.Code, score
+		}
+	}
+	return best
+}
+
+// languageWords returns the lowercased words of text that may vote in
+// DetectLanguage. Symbols carry no language, so qualified tickers
+// ("SAP.DE", "TSE:7203") and words in capitals ("BTC") are left out,
+// unless the whole query is in capitals.
+func languageWords(text string) []string {
+	tickers := findTickers(text)
+	shouted := strings.ToUpper(text) == text
+	var words []string
+	for _, loc := range wordRegex.FindAllStringIndex(text, -1) {
+		for len(tickers) > 0 && tickers[0].End <= loc[0] {
+			tickers = tickers[1:]
+		}
+		if len(tickers) > 0 && tickers[0].Start <= loc[0] {
+			continue
+		}
+		word := text[loc[0]:loc[1]]
+		lower := strings.ToLower(word)
+		if !shouted && utf8.RuneCountInString(word) > 1 && word == strings.ToUpper(word) && word != lower {
+			continue
+		}
+		words = append(words, lower)
+	}
+	return words
+}
+
+// vocab is the chain of packs consulted for a query, most specific first
+type vocab []*Language
+
+// vocabFor returns the packs for a language code, ending with English
+func vocabFor(code string) vocab {
+	if lang, ok := Languages[code]; ok && lang != english {
+		return vocab{lang, english}
+	}
+	return vocab{english}
+}
+
+// allVocab chains every pack, English first and the rest by code, for
+// inputs with no language such as pair paths
+func allVocab() vocab {
+	v := vocab{english}
+	codes := make([]string, 0, len(Languages))
+	for code := range Languages {
+		if code != english.Code {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		v = append(v, Languages[code])
+	}
+	return v
+}
+
+func (v vocab) primary() *Language {
+	return v[0]
+}
+
+func (v vocab) alias(word string) (string, bool) {
+	word = strings.ToLower(word)
+	for _, lang := range v {
+		if code, ok := lang.aliasMap()[word]; ok {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+func (v vocab) currency(word string) (string, bool) {
+	word = strings.ToLower(word)
+	for _, lang := range v {
+		if code, ok := lang.Currencies[word]; ok {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+func (v vocab) rankWord(word string) (standalone, ok bool) {
+	for _, lang := range v {
+		if standalone, ok := lang.Rank[word]; ok {
+			return standalone, true
+		}
+	}
+	return false, false
+}
+
+func (v vocab) cue(word string) (AssetClass, bool) {
+	for _, lang := range v {
+		if class, ok := lang.ClassCues[word]; ok {
+			return class, true
+		}
+	}
+	return "", false
+}
+
+// has reports whether any pack lists word in the set picked by field
+func (v vocab) has(word string, field func(*Language) map[string]bool) bool {
+	for _, lang := range v {
+		if field(lang)[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// Set selectors for vocab.has
+func stopWords(l *Language) map[string]bool      { return l.StopWords }
+func quoteMarkerSet(l *Language) map[string]bool { return l.QuoteMarkers }
+func venueMarkerSet(l *Language) map[string]bool { return l.VenueMarkers }
+func fillerSet(l *Language) map[string]bool      { return l.Fillers }
+func priceCueSet(l *Language) map[string]bool    { return l.PriceCues }
+func compareSet(l *Language) map[string]bool     { return l.Compare }
+func changeSet(l *Language) map[string]bool      { return l.Change }
+func ascendingSet(l *Language) map[string]bool   { return l.Ascending }
+func rankNounSet(l *Language) map[string]bool    { return l.RankNouns }
+
+// isStopWord reports whether any language treats word as a stop word
+func isStopWord(word string) bool {
+	for _, lang := range Languages {
+		if lang.StopWords[word] {
+			return true
+		}
+	}
+	return false
+}
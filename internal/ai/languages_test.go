@@ -0,0 +1,44 @@
+package ai
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"price of bitcoin", "en"},
+		{"SAP.DE", "en"},
+		{"price of SAP.DE", "en"},
+		{"XETRA:SAP price", "en"},
+		{"precio de bitcoin", "es"},
+		{"precio de SAP.DE", "es"},
+		{"harga emas hari ini", "id"},
+		{"price of SAP DE", "en"},
+		{"PRECIO DE BITCOIN", "es"},
+	}
+	for _, tt := range tests {
+		if got := DetectLanguage(languageWords(tt.text)); got != tt.want {
+			t.Errorf("language of %q = %s (words %q), want %s", tt.text, got, languageWords(tt.text), tt.want)
+		}
+	}
+}
+
+func TestDetectLanguageTies(t *testing.T) {
+	// Spanish and Portuguese score the same on these, and the winner
+	// must not depend on map order
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"vale bitcoin", "es"},
+		{"ouro vs plata", "es"},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if got := DetectLanguage(languageWords(tt.text)); got != tt.want {
+				t.Fatalf("language of %q = %s on run %d, want %s every time", tt.text, got, i, tt.want)
+			}
+		}
+	}
+}
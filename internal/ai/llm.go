@@ -0,0 +1,350 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// LLMConfig configures an OpenAI-compatible chat completions endpoint
+type LLMConfig struct {
+	URL      string // base URL, e.g. "https://api.openai.com/v1"
+	Model    string
+	APIKey   string        // sent as a bearer token when set
+	Timeout  time.Duration // per request; defaults to 5s
+	CacheTTL time.Duration // defaults to 10m
+	MaxCodes int           // largest catalog sent as an enum; defaults to 2000
+}
+
+const (
+	llmCacheSize = 1000
+	llmPrompt    = `You extract price lookups from queries for a market data API.
+Return every asset the query asks about, in query order, as its exact code from the allowed list.
+"text" is the words of the query naming the asset and "amount" its quantity, or 0.
+intent: "price" for prices or values, "change" for 24h moves, "compare" for two or more assets side by side,
+"ratio" for one asset priced in another, "rank" for top N by market cap (set limit, default 10),
+"odds" for the probability of an event on prediction markets (set topic to the event, e.g. "fed rate cut in december").
+metric and order only apply to compare: metric "price" or "change_24h", order "desc" unless the query asks for the cheaper or weaker one.
+quote is the currency the answer is wanted in and venue the exchange named, both empty when not given.`
+)
+
+// LLMParser asks a chat model to parse queries. Answers are constrained
+// to catalog codes by the response schema and checked against the catalog
+// again; anything unusable falls back to the rules parser.
+type LLMParser struct {
+	cfg      LLMConfig
+	client   *http.Client
+	fallback QueryParser
+
+	cacheMu sync.RWMutex
+	cache   map[string]llmCacheEntry
+}
+
+type llmCacheEntry struct {
+	query   Query
+	expires time.Time
+}
+
+// NewLLMParser creates an LLM parser. A nil fallback uses the rules parser.
+func NewLLMParser(cfg LLMConfig, fallback QueryParser) *LLMParser {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = 10 * time.Minute
+	}
+	if cfg.MaxCodes <= 0 {
+		cfg.MaxCodes = 2000
+	}
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+	if fallback == nil {
+		fallback = RulesParser{}
+	}
+	return &LLMParser{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		fallback: fallback,
+		cache:    make(map[string]llmCacheEntry),
+	}
+}
+
+// Parse implements QueryParser
+func (p *LLMParser) Parse(ctx context.Context, text string, opts Options) (Query, error) {
+	// Without a catalog there is nothing to constrain the answer to
+	if opts.Catalog.Len() == 0 {
+		return p.fallback.Parse(ctx, text, opts)
+	}
+
+	// Answers are only reused while the codes and words they were
+	// checked against stay the same
+	key := fmt.Sprintf("%d|%d|%s|%s|%s", dictionaryVersion.Load(), opts.Catalog.Version(),
+		opts.Lang, opts.Class, strings.ToLower(strings.TrimSpace(text)))
+	if q, ok := p.cached(key); ok {
+		return q, nil
+	}
+
+	q, err := p.ask(ctx, text, opts)
+	if err != nil {
+		log.Printf("LLM parser failed, using rules: %v", err)
+		return p.fallback.Parse(ctx, text, opts)
+	}
+	p.store(key, q)
+	return q, nil
+}
+
+// llmAnswer is the structured output the model must return
+type llmAnswer struct {
+	Intent string `json:"intent"`
+	Assets []struct {
+		Code   string  `json:"code"`
+		Text   string  `json:"text"`
+		Amount float64 `json:"amount"`
+	} `json:"assets"`
+	Quote  string `json:"quote"`
+	Venue  string `json:"venue"`
+	Limit  int    `json:"limit"`
+	Metric string `json:"metric"`
+	Order  string `json:"order"`
+	Topic  string `json:"topic"`
+}
+
+func (p *LLMParser) ask(ctx context.Context, text string, opts Options) (Query, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	codes := opts.Catalog.Codes()
+	prompt := llmPrompt
+	if opts.Class != "" {
+		prompt += "\nPrefer " + string(opts.Class) + " assets when a name is ambiguous."
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"model":       p.cfg.Model,
+		"temperature": 0,
+		"messages": []map[string]string{
+			{"role": "system", "content": prompt},
+			{"role": "user", "content": text},
+		},
+		"response_format": map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   "price_query",
+				"strict": true,
+				"schema": p.schema(codes),
+			},
+		},
+	})
+	if err != nil {
+		return Query{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.URL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return Query{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.cfg.APIKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Query{}, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Query{}, fmt.Errorf("LLM returned status %d", resp.StatusCode)
+	}
+
+	var completion struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return Query{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return Query{}, fmt.Errorf("LLM returned no choices")
+	}
+
+	var answer llmAnswer
+	if err := json.Unmarshal([]byte(completion.Choices[0].Message.Content), &answer); err != nil {
+		return Query{}, fmt.Errorf("failed to decode answer: %w", err)
+	}
+	return p.toQuery(text, opts, answer)
+}
+
+// schema is the JSON schema for llmAnswer. Small catalogs are sent as an
+// enum of codes; larger ones rely on the check in toQuery.
+func (p *LLMParser) schema(codes []string) map[string]interface{} {
+	code := map[string]interface{}{"type": "string"}
+	if len(codes) <= p.cfg.MaxCodes {
+		code["enum"] = codes
+	}
+	str := map[string]interface{}{"type": "string"}
+	return map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []string{"intent", "assets", "quote", "venue", "limit", "metric", "order", "topic"},
+		"properties": map[string]interface{}{
+			"intent": map[string]interface{}{
+				"type": "string",
+				"enum": []Intent{IntentPrice, IntentChange, IntentCompare, IntentRatio, IntentRank, IntentOdds},
+			},
+			"assets": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": false,
+					"required":             []string{"code", "text", "amount"},
+					"properties": map[string]interface{}{
+						"code":   code,
+						"text":   str,
+						"amount": map[string]interface{}{"type": "number"},
+					},
+				},
+			},
+			"quote":  str,
+			"venue":  str,
+			"limit":  map[string]interface{}{"type": "integer"},
+			"metric": map[string]interface{}{"type": "string", "enum": []string{"", MetricPrice, MetricChange}},
+			"order":  map[string]interface{}{"type": "string", "enum": []string{"", "asc", "desc"}},
+			"topic":  str,
+		},
+	}
+}
+
+// toQuery validates an answer against the catalog and converts it
+func (p *LLMParser) toQuery(text string, opts Options, a llmAnswer) (Query, error) {
+	q := Query{Text: text, Lang: opts.Lang, Intent: Intent(a.Intent), Parser: ParserLLM}
+	if _, ok := Languages[q.Lang]; !ok {
+		q.Lang = DetectLanguage(languageWords(text))
+	}
+
+	switch q.Intent {
+	case IntentPrice, IntentChange, IntentCompare, IntentRatio:
+	case IntentRank:
+		q.Limit = defaultRankLimit
+		if a.Limit > 0 {
+			q.Limit = clampLimit(a.Limit)
+		}
+		return q, nil
+	case IntentOdds:
+		q.Topic = strings.TrimSpace(a.Topic)
+		if q.Topic == "" {
+			return Query{}, fmt.Errorf("odds intent without a topic")
+		}
+		return q, nil
+	default:
+		return Query{}, fmt.Errorf("unknown intent %q", a.Intent)
+	}
+
+	for _, asset := range a.Assets {
+		e, ok := opts.Catalog.Get(asset.Code)
+		if !ok {
+			return Query{}, fmt.Errorf("code %q is not in the catalog", asset.Code)
+		}
+		m := Match{
+			Asset:  e.Base,
+			Text:   asset.Text,
+			Amount: asset.Amount,
+			Via:    ViaLLM,
+			Class:  ClassOfType(e.Type),
+		}
+		if m.Class == ClassEquity {
+			m.Market = e.Market
+		}
+		if start, end, ok := indexFold(text, asset.Text); ok {
+			m.Text, m.Start, m.End = text[start:end], start, end
+		}
+		q.Matches = append(q.Matches, m)
+	}
+
+	if quote := strings.ToUpper(strings.TrimSpace(a.Quote)); quote != "" {
+		if cur, ok := allVocab().currency(quote); ok {
+			quote = cur
+		}
+		q.Quote = quote
+	}
+	if venue, ok := lookupVenue(a.Venue); ok {
+		q.Venue = venue
+	}
+
+	if q.Intent == IntentCompare {
+		if len(q.Matches) < 2 {
+			q.Intent = IntentPrice
+		} else {
+			q.Metric, q.Order = MetricPrice, "desc"
+			if a.Metric == MetricChange {
+				q.Metric = MetricChange
+			}
+			if a.Order == "asc" {
+				q.Order = "asc"
+			}
+		}
+	}
+	return q, nil
+}
+
+// indexFold finds the first span of s equal to substr under case
+// folding, as byte offsets into s. Offsets found in a lowercased copy
+// would be off wherever lowercasing changes a rune's length ("İ").
+func indexFold(s, substr string) (start, end int, ok bool) {
+	if substr == "" {
+		return 0, 0, false
+	}
+	for start = range s {
+		end = start
+		rest := substr
+		for rest != "" && end < len(s) {
+			a, n := utf8.DecodeRuneInString(s[end:])
+			b, m := utf8.DecodeRuneInString(rest)
+			if a != b && !strings.EqualFold(string(a), string(b)) {
+				break
+			}
+			end, rest = end+n, rest[m:]
+		}
+		if rest == "" {
+			return start, end, true
+		}
+	}
+	return 0, 0, false
+}
+
+func (p *LLMParser) cached(key string) (Query, bool) {
+	p.cacheMu.RLock()
+	defer p.cacheMu.RUnlock()
+	e, ok := p.cache[key]
+	if !ok || time.Now().After(e.expires) {
+		return Query{}, false
+	}
+	return e.query, true
+}
+
+func (p *LLMParser) store(key string, q Query) {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+	if len(p.cache) >= llmCacheSize {
+		now := time.Now()
+		for k, e := range p.cache {
+			if now.After(e.expires) {
+				delete(p.cache, k)
+			}
+		}
+		if len(p.cache) >= llmCacheSize {
+			p.cache = make(map[string]llmCacheEntry)
+		}
+	}
+	p.cache[key] = llmCacheEntry{query: q, expires: time.Now().Add(p.cfg.CacheTTL)}
+}
@@ -0,0 +1,202 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// llmServer answers chat completions with content, counting the calls
+func llmServer(t *testing.T, status int, delay time.Duration, content string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("request to %s, want /chat/completions", r.URL.Path)
+		}
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{
+				{"message": map[string]string{"content": content}},
+			},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+var llmEntries = []CatalogEntry{
+	{Code: "Crypto:ALL:BTC/USDT", Name: "Bitcoin"},
+	{Code: "Crypto:ALL:ETH/USDT", Name: "Ethereum"},
+	{Code: "Stock:US:NVDA/USD", Name: "NVIDIA Corporation"},
+}
+
+func llmCatalog() *Catalog {
+	c := NewCatalog()
+	c.Load(llmEntries)
+	return c
+}
+
+const llmBitcoinAnswer = `{"intent":"compare","assets":[
+	{"code":"Crypto:ALL:BTC/USDT","text":"bitcoin","amount":0},
+	{"code":"Crypto:ALL:ETH/USDT","text":"eth","amount":2}],
+	"quote":"eur","venue":"binance","limit":0,"metric":"change_24h","order":"asc","topic":""}`
+
+func TestLLMParserAnswer(t *testing.T) {
+	srv, _ := llmServer(t, http.StatusOK, 0, llmBitcoinAnswer)
+	p := NewLLMParser(LLMConfig{URL: srv.URL}, nil)
+
+	q, err := p.Parse(context.Background(), "bitcoin vs 2 eth on binance in eur", Options{Catalog: llmCatalog()})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if q.Parser != ParserLLM || q.Intent != IntentCompare {
+		t.Fatalf("parser %q intent %q, want llm compare", q.Parser, q.Intent)
+	}
+	if len(q.Matches) != 2 {
+		t.Fatalf("matches = %+v, want BTC and ETH", q.Matches)
+	}
+	btc, eth := q.Matches[0], q.Matches[1]
+	if btc.Asset != "BTC" || btc.Class != ClassCrypto || btc.Via != ViaLLM || btc.Start != 0 || btc.End != 7 {
+		t.Errorf("first match = %+v, want BTC at 0-7 via llm", btc)
+	}
+	if eth.Asset != "ETH" || eth.Amount != 2 {
+		t.Errorf("second match = %+v, want 2 ETH", eth)
+	}
+	if q.Quote != "EUR" || q.Venue != "BINANCE" || q.Metric != MetricChange || q.Order != "asc" {
+		t.Errorf("quote %q venue %q metric %q order %q, want EUR BINANCE change_24h asc", q.Quote, q.Venue, q.Metric, q.Order)
+	}
+}
+
+func TestLLMParserSpans(t *testing.T) {
+	// "İ" lowercases to three bytes from two, which would shift every
+	// span after it if spans were found in a lowercased copy
+	const answer = `{"intent":"price","assets":[{"code":"Stock:US:NVDA/USD","text":"nvidia","amount":0}],
+		"quote":"","venue":"","limit":0,"metric":"","order":"","topic":""}`
+	tests := []struct {
+		text  string
+		want  span
+		found bool
+	}{
+		{"NVIDIA price", span{"NVDA", "NVIDIA", 0, 6}, true},
+		{"İSTANBUL'da NVIDIA fiyatı", span{"NVDA", "NVIDIA", 13, 19}, true},
+		{"¿cuánto vale Nvidia hoy?", span{"NVDA", "Nvidia", 15, 21}, true},
+		{"harga saham nvda", span{"NVDA", "nvidia", 0, 0}, false},
+	}
+	for _, tt := range tests {
+		srv, _ := llmServer(t, http.StatusOK, 0, answer)
+		p := NewLLMParser(LLMConfig{URL: srv.URL}, nil)
+		q, err := p.Parse(context.Background(), tt.text, Options{Catalog: llmCatalog()})
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		if got := spans(q.Matches); len(got) != 1 || got[0] != tt.want {
+			t.Errorf("Parse(%q) matches = %+v, want %+v", tt.text, got, tt.want)
+			continue
+		}
+		if tt.found && tt.text[tt.want.Start:tt.want.End] != tt.want.Text {
+			t.Errorf("Parse(%q) span %d-%d is %q, want %q", tt.text, tt.want.Start, tt.want.End, tt.text[tt.want.Start:tt.want.End], tt.want.Text)
+		}
+	}
+}
+
+func TestLLMParserFallback(t *testing.T) {
+	offCatalog := `{"intent":"price","assets":[{"code":"Crypto:ALL:DOGE/USDT","text":"bitcoin","amount":0}],
+		"quote":"","venue":"","limit":0,"metric":"","order":"","topic":""}`
+	tests := []struct {
+		name    string
+		status  int
+		delay   time.Duration
+		content string
+	}{
+		{"off-catalog code", http.StatusOK, 0, offCatalog},
+		{"unknown intent", http.StatusOK, 0, `{"intent":"weather","assets":[]}`},
+		{"malformed answer", http.StatusOK, 0, `not json`},
+		{"server error", http.StatusInternalServerError, 0, ""},
+		{"rate limited", http.StatusTooManyRequests, 0, ""},
+		{"timeout", http.StatusOK, 200 * time.Millisecond, llmBitcoinAnswer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, calls := llmServer(t, tt.status, tt.delay, tt.content)
+			p := NewLLMParser(LLMConfig{URL: srv.URL, Timeout: 50 * time.Millisecond}, nil)
+
+			q, err := p.Parse(context.Background(), "bitcoin price", Options{Catalog: llmCatalog()})
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if calls.Load() != 1 {
+				t.Errorf("LLM called %d times, want 1", calls.Load())
+			}
+			if q.Parser != ParserRules {
+				t.Errorf("parser = %q, want the rules fallback", q.Parser)
+			}
+			if len(q.Matches) != 1 || q.Matches[0].Asset != "BTC" {
+				t.Errorf("matches = %+v, want BTC from the rules parser", q.Matches)
+			}
+		})
+	}
+}
+
+func TestLLMParserCache(t *testing.T) {
+	srv, calls := llmServer(t, http.StatusOK, 0, llmBitcoinAnswer)
+	p := NewLLMParser(LLMConfig{URL: srv.URL}, nil)
+	catalog := llmCatalog()
+	opts := Options{Catalog: catalog}
+	t.Cleanup(func() { dictionary.Store(nil) })
+
+	for _, text := range []string{"bitcoin vs 2 eth", "  Bitcoin VS 2 ETH "} {
+		q, err := p.Parse(context.Background(), text, opts)
+		if err != nil || q.Parser != ParserLLM {
+			t.Fatalf("Parse(%q) = %q, %v, want an llm answer", text, q.Parser, err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("LLM called %d times, want 1 with the second answer cached", calls.Load())
+	}
+
+	if _, err := p.Parse(context.Background(), "bitcoin vs 2 eth", Options{Catalog: catalog, Class: ClassCrypto}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("LLM called %d times, want a new call for another class", calls.Load())
+	}
+
+	// Reloading the same pairs keeps the cache; new pairs or words
+	// invalidate it
+	steps := []struct {
+		name  string
+		apply func()
+		calls int32
+	}{
+		{"same catalog", func() { catalog.Load(llmEntries) }, 2},
+		{"new pairs", func() {
+			catalog.Load(append(llmEntries[:len(llmEntries):len(llmEntries)], CatalogEntry{Code: "Crypto:ALL:SOL/USDT"}))
+		}, 3},
+		{"new dictionary", func() { LoadDictionary(SeedDictionary()) }, 4},
+	}
+	for _, step := range steps {
+		step.apply()
+		if _, err := p.Parse(context.Background(), "bitcoin vs 2 eth", opts); err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		if calls.Load() != step.calls {
+			t.Errorf("after %s LLM called %d times, want %d", step.name, calls.Load(), step.calls)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package ai
 import (
 	"regexp"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Common asset aliases
@@ -22,7 +24,7 @@ var assetAliases = map[string]string{
 	"doge":     "DOGE",
 	"bnb":      "BNB",
 	"binance":  "BNB",
-	
+
 	// Crypto - Top 50
 	"tron":      "TRX",
 	"trx":       "TRX",
@@ -64,7 +66,7 @@ var assetAliases = map[string]string{
 	"rndr":      "RNDR",
 	"kaspa":     "KAS",
 	"kas":       "KAS",
-	
+
 	// Stocks
 	"nvidia":    "NVDA",
 	"nvda":      "NVDA",
@@ -80,24 +82,49 @@ var assetAliases = map[string]string{
 	"tsla":      "TSLA",
 	"meta":      "META",
 	"facebook":  "META",
-	
+
 	// Commodities
-	"gold":   "XAU",
-	"xau":    "XAU",
-	"silver": "XAG",
-	"xag":    "XAG",
-	"oil":    "WTI",
-	"wti":    "WTI",
-	
+	"gold":        "XAU",
+	"xau":         "XAU",
+	"silver":      "XAG",
+	"xag":         "XAG",
+	"platinum":    "XPT",
+	"xpt":         "XPT",
+	"palladium":   "XPD",
+	"xpd":         "XPD",
+	"oil":         "WTI",
+	"crude":       "WTI",
+	"crude oil":   "WTI",
+	"wti":         "WTI",
+	"brent":       "BRENT",
+	"natural gas": "NATGAS",
+	"natgas":      "NATGAS",
+	"copper":      "COPPER",
+	"corn":        "CORN",
+	"wheat":       "WHEAT",
+	"soybean":     "SOYBEAN",
+	"soybeans":    "SOYBEAN",
+	"sugar":       "SUGAR",
+	"coffee":      "COFFEE",
+	"cocoa":       "COCOA",
+	"cotton":      "COTTON",
+
 	// Forex
-	"dollar": "USD",
-	"usd":    "USD",
-	"euro":   "EUR",
-	"eur":    "EUR",
-	"yen":    "JPY",
-	"jpy":    "JPY",
-	"pound":  "GBP",
-	"gbp":    "GBP",
+	"dollar":       "USD",
+	"dollars":      "USD",
+	"usd":          "USD",
+	"euro":         "EUR",
+	"eur":          "EUR",
+	"yen":          "JPY",
+	"jpy":          "JPY",
+	"pound":        "GBP",
+	"gbp":          "GBP",
+	"sterling":     "GBP",
+	"franc":        "CHF",
+	"yuan":         "CNY",
+	"rupiah":       "IDR",
+	"dxy":          "DXY",
+	"dollar index": "DXY",
 }
 
 // Asset type detection - expanded list
@@ -124,50 +151,449 @@ var equityAssets = map[string]bool{
 }
 
 var metalAssets = map[string]bool{
-	"XAU": true, "XAG": true,
+	"XAU": true, "XAG": true, "XPT": true, "XPD": true,
+}
+
+var commodityAssets = map[string]bool{
+	"WTI": true, "BRENT": true, "NATGAS": true, "COPPER": true,
+	"CORN": true, "WHEAT": true, "SOYBEAN": true, "SUGAR": true,
+	"COFFEE": true, "COCOA": true, "COTTON": true,
+}
+
+var forexAssets = map[string]bool{
+	"USD": true, "EUR": true, "JPY": true, "GBP": true, "CHF": true, "AUD": true,
+	"CAD": true, "NZD": true, "CNY": true, "HKD": true, "SGD": true, "KRW": true,
+	"INR": true, "IDR": true, "MYR": true, "THB": true, "PHP": true, "MXN": true,
+	"BRL": true, "DXY": true,
+}
+
+// Match is an asset found in a query, with the byte span of the query
+// text that produced it
+type Match struct {
+	Asset  string  `json:"asset"`
+	Text   string  `json:"text"`
+	Start  int     `json:"start"`
+	End    int     `json:"end"`
+	Amount float64 `json:"amount,omitempty"`
+	Market string  `json:"market,omitempty"` // equity market of a qualified ticker
+	Via    string  `json:"via,omitempty"`    // how the asset was found
+
+	Class      AssetClass  `json:"class,omitempty"`
+	Ambiguous  bool        `json:"ambiguous,omitempty"`
+	Reason     string      `json:"reason,omitempty"` // why it is ambiguous
+	Candidates []Candidate `json:"candidates,omitempty"`
+
+	// Set when the text was a typo corrected by fuzzy matching
+	DidYouMean string  `json:"did_you_mean,omitempty"`
+	Confidence float64 `json:"confidence,omitempty"`
 }
 
-// ParseQuery extracts asset codes from natural language
-func ParseQuery(query string) []string {
-	query = strings.ToLower(query)
-	var found []string
+// Reasons a match needs clarification
+const (
+	ReasonMultipleClasses = "multiple_classes"
+	ReasonCommonWord      = "common_word"
+)
+
+// How a match was found, as reported in Match.Via
+const (
+	ViaAlias          = "alias"
+	ViaTicker         = "ticker"
+	ViaExchangeTicker = "exchange_ticker"
+	ViaForexPair      = "forex_pair"
+	ViaCatalogTicker  = "catalog_ticker"
+	ViaCatalogName    = "catalog_name"
+	ViaFuzzy          = "fuzzy"
+	ViaLLM            = "llm"
+)
+
+// Options tune parsing
+type Options struct {
+	Class   AssetClass // preferred class for ambiguous tickers
+	Catalog *Catalog   // adds catalog-listed classes and tickers
+	Lang    string     // language pack code; detected when empty
+}
+
+// Query is the structured form of a natural-language query
+type Query struct {
+	Text    string  `json:"text"`
+	Lang    string  `json:"lang"`
+	Intent  Intent  `json:"intent"`
+	Matches []Match `json:"matches"`
+	Quote   string  `json:"quote,omitempty"`
+	Venue   string  `json:"venue,omitempty"`
+	Limit   int     `json:"limit,omitempty"`  // rank
+	Metric  string  `json:"metric,omitempty"` // compare
+	Order   string  `json:"order,omitempty"`  // compare: "asc" or "desc"
+	Topic   string  `json:"topic,omitempty"`  // odds
+
+	NeedsClarification bool `json:"needs_clarification"`
+
+	// Typos that may name an asset but aren't close enough to be read
+	// as one; each carries its DidYouMean
+	Suggestions []Match `json:"suggestions,omitempty"`
+
+	Parser string `json:"parser"` // QueryParser that produced the query
+}
+
+var wordRegex = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// Tokens returns the lowercased words the parser reads from text
+func Tokens(text string) []string {
+	return wordRegex.FindAllString(strings.ToLower(text), -1)
+}
+
+// Parse extracts assets, the requested quote currency ("ETH in EUR",
+// "BTC/IDR"), the requested venue ("SOL on Binance") and quantities
+// ("0.3 BTC", "100 NVDA shares") from a query.
+// Matches are ordered by where each asset first appears in the query,
+// and an asset mentioned twice is only returned once.
+func Parse(text string) Query {
+	return ParseWith(text, Options{})
+}
+
+// ParseWith is Parse with options. Tickers that name assets in several
+// classes, or aliases that are everyday words, are settled by the
+// preferred class or nearby cue words ("META stock", "link price");
+// otherwise they are returned with candidates and the query is flagged
+// as needing clarification.
+func ParseWith(text string, opts Options) Query {
+	q := Query{Text: text, Parser: ParserRules}
 	seen := make(map[string]bool)
+	words := wordRegex.FindAllStringIndex(text, -1)
+	numbers := numberRegex.FindAllStringIndex(text, -1)
+	tickers := findTickers(text)
+	var amount float64 // quantity waiting for its asset
+	var plain []bool   // per match: a common word without price context
+
+	lowerWords := make([]string, len(words))
+	for i, loc := range words {
+		lowerWords[i] = strings.ToLower(text[loc[0]:loc[1]])
+	}
+
+	q.Lang = opts.Lang
+	if _, ok := Languages[q.Lang]; !ok {
+		q.Lang = DetectLanguage(languageWords(text))
+	}
+	v := vocabFor(q.Lang)
+
+	for i := 0; i < len(words); i++ {
+		loc := words[i]
+		word := text[loc[0]:loc[1]]
+		lower := lowerWords[i]
+
+		// "7203.T", "TSE:7203" - before quantities, which would take "7203"
+		if len(tickers) > 0 && tickers[0].Start <= loc[0] {
+			t := tickers[0]
+			tickers = tickers[1:]
+			for i+1 < len(words) && words[i+1][0] < t.End {
+				i++
+			}
+			if key := t.Symbol + "." + t.Market; !seen[key] {
+				seen[key] = true
+				q.Matches = append(q.Matches, Match{
+					Asset:  t.Symbol,
+					Text:   text[t.Start:t.End],
+					Start:  t.Start,
+					End:    t.End,
+					Amount: amount,
+					Market: t.Market,
+					Via:    ViaExchangeTicker,
+					Class:  ClassEquity,
+				})
+				plain = append(plain, false)
+			}
+			amount = 0
+			continue
+		}
+
+		// Quantities span several words ("2,5", "3 million")
+		for len(numbers) > 0 && numbers[0][1] <= loc[0] {
+			numbers = numbers[1:]
+		}
+		if len(numbers) > 0 && numbers[0][0] <= loc[0] {
+			amount, _ = parseAmount(text[numbers[0][0]:numbers[0][1]], v.primary().DecimalComma)
+			for i+1 < len(words) && words[i+1][0] < numbers[0][1] {
+				i++
+			}
+			continue
+		}
+		if amount > 0 && v.has(lower, fillerSet) {
+			continue
+		}
+
+		// "in EUR", "to rupiah" - only once an asset has been named,
+		// so "interested in BTC" still finds BTC
+		if v.has(lower, quoteMarkerSet) && i+1 < len(words) && len(q.Matches) > 0 && q.Quote == "" {
+			if cur, ok := v.currency(lowerWords[i+1]); ok {
+				q.Quote = cur
+				i++
+				continue
+			}
+		}
+
+		// "on Binance", "via Kraken"
+		if v.has(lower, venueMarkerSet) && i+1 < len(words) && q.Venue == "" {
+			if venue, ok := lookupVenue(lowerWords[i+1]); ok {
+				q.Venue = venue
+				i++
+				continue
+			}
+		}
+
+		// "BTC/IDR", "ETH-EUR"
+		if i > 0 && len(q.Matches) > 0 && q.Quote == "" {
+			gap := text[words[i-1][1]:loc[0]]
+			last := q.Matches[len(q.Matches)-1]
+			if (gap == "/" || gap == "-") && last.End == words[i-1][1] {
+				if cur, ok := v.currency(word); ok {
+					q.Quote = cur
+					continue
+				}
+			}
+		}
+
+		cue := nearbyCue(lowerWords, i, v)
+		start, end := loc[0], loc[1]
+		code, via := lookupWord(word, v)
+		ok := via != ""
+		if i+1 < len(words) && strings.TrimSpace(text[end:words[i+1][0]]) == "" {
+			// Two-word names ("dollar index") win over their first word
+			pair := lower + " " + lowerWords[i+1]
+			c, how := lookupWord(pair, v)
+			found := how != ""
+			if !found {
+				c, found = opts.Catalog.Named(pair, false)
+				how = ViaCatalogName
+			}
+			if found {
+				code, ok, via = c, true, how
+				i++
+				end = words[i][1]
+				word = text[start:end]
+			}
+		}
+		if ok && opts.Catalog.Len() > 0 && len(opts.Catalog.ByBase(code)) == 0 {
+			// The catalog's own listing of a name wins over an unlisted
+			// alias ("natural gas" may be listed as "NG")
+			if named, found := opts.Catalog.Named(strings.ToLower(word), false); found {
+				code, via = named, ViaCatalogName
+			}
+		}
+		if !ok {
+			// "USDJPY", "eurusd"
+			if base, quote, found := splitForexPair(word); found {
+				code, ok, via = base, true, ViaForexPair
+				if q.Quote == "" {
+					q.Quote = quote
+				}
+			}
+		}
+		if !ok && (word == strings.ToUpper(word) || cue != "") && !v.has(lower, stopWords) && !isBlocked(lower) && len(opts.Catalog.ByBase(word)) > 0 {
+			// Catalog tickers such as IDX stocks ("BBCA", "saham bbca")
+			code, ok, via = strings.ToUpper(word), true, ViaCatalogTicker
+		}
+		if !ok && !v.has(lower, stopWords) && !isBlocked(lower) {
+			// Catalog company names; a name's first word alone only
+			// when capitalized ("Toyota", not "general")
+			r, _ := utf8.DecodeRuneInString(word)
+			code, ok = opts.Catalog.Named(lower, unicode.IsUpper(r))
+			via = ViaCatalogName
+		}
+		var fix Suggestion
+		if !ok {
+			// "etherium", "nvidea price"; a less certain fix without a
+			// price cue next to it ("magic", "apples") is only suggested
+			fix, ok = Correct(word, opts.Catalog)
+			code, via = fix.Asset, ViaFuzzy
+			if ok && !Sure(word, fix) && !hasNearby(lowerWords, i, v, priceCueSet) {
+				q.Suggestions = append(q.Suggestions, Match{
+					Asset: code, Text: word, Start: start, End: end, Via: via,
+					DidYouMean: fix.Term, Confidence: fix.Confidence,
+				})
+				ok = false
+			}
+		}
+		if !ok || seen[code] {
+			amount = 0
+			continue
+		}
+		seen[code] = true
+		m := Match{
+			Asset:      code,
+			Text:       word,
+			Start:      start,
+			End:        end,
+			Amount:     amount,
+			Via:        via,
+			DidYouMean: fix.Term,
+			Confidence: fix.Confidence,
+		}
+		settleClass(&m, opts, cue, strings.EqualFold(word, code))
+		q.Matches = append(q.Matches, m)
+		plain = append(plain, commonWords[lower] && word == lower && amount == 0 &&
+			cue == "" && !hasNearby(lowerWords, i, v, priceCueSet))
+		amount = 0
+	}
+
+	q.Matches = dropCommonWords(q.Matches, plain)
+	settleFromMarket(&q)
+	settleFromContext(q.Matches)
+	for _, m := range q.Matches {
+		q.NeedsClarification = q.NeedsClarification || m.Ambiguous
+	}
+
+	detectIntent(&q, lowerWords, v)
+	return q
+}
 
-	// Check for aliases
-	for alias, code := range assetAliases {
-		if strings.Contains(query, alias) && !seen[code] {
-			found = append(found, code)
-			seen[code] = true
+// settleClass picks the match's class, or marks it ambiguous when the
+// ticker has several readings and neither the caller nor the query
+// says which one is meant. Names other than the ticker itself
+// ("solana", "facebook") are never ambiguous.
+func settleClass(m *Match, opts Options, cue AssetClass, ticker bool) {
+	cands := opts.Catalog.Candidates(m.Asset)
+	if !ticker {
+		cands = cands[:1]
+	}
+	if len(cands) == 1 {
+		m.Class = cands[0].Class
+		return
+	}
+	for _, class := range []AssetClass{opts.Class, cue} {
+		if cand, ok := pickCandidate(cands, class); ok && class != "" {
+			m.Asset, m.Class = cand.Asset, cand.Class
+			return
 		}
 	}
+	m.Ambiguous = true
+	m.Reason = ReasonMultipleClasses
+	m.Candidates = cands
+}
 
-	// Check for direct ticker mentions (uppercase in original)
-	tickerRegex := regexp.MustCompile(`\b([A-Z]{2,5})\b`)
-	matches := tickerRegex.FindAllStringSubmatch(strings.ToUpper(query), -1)
-	for _, match := range matches {
-		code := match[1]
-		if !seen[code] && (cryptoAssets[code] || equityAssets[code] || metalAssets[code]) {
-			found = append(found, code)
-			seen[code] = true
+// settleFromContext resolves class collisions using the other assets in
+// the query: in "BTC and SOL" every other asset is crypto, so SOL is too
+func settleFromContext(matches []Match) {
+	var context AssetClass
+	for _, m := range matches {
+		if m.Ambiguous {
+			continue
 		}
+		if context != "" && context != m.Class {
+			return
+		}
+		context = m.Class
+	}
+	if context != "" {
+		settleAs(matches, context)
+	}
+}
+
+// settleFromMarket resolves class collisions using where, or in what,
+// the query asks for a price: "SOL on Binance" and "SOL/USDT" are Solana
+func settleFromMarket(q *Query) {
+	if class := marketClass(q.Venue, q.Quote); class != "" {
+		settleAs(q.Matches, class)
 	}
+}
 
-	return found
+// settleAs gives class collisions the reading in class, where they have one
+func settleAs(matches []Match, class AssetClass) {
+	for i := range matches {
+		m := &matches[i]
+		if m.Reason != ReasonMultipleClasses {
+			continue
+		}
+		if cand, ok := pickCandidate(m.Candidates, class); ok {
+			m.Asset, m.Class = cand.Asset, cand.Class
+			m.Ambiguous, m.Reason, m.Candidates = false, "", nil
+		}
+	}
+}
+
+// dropCommonWords removes everyday words that matched an alias ("link",
+// "dot") when the query names other assets. If nothing else matched they
+// are kept but flagged, since the asset may well be meant.
+func dropCommonWords(matches []Match, plain []bool) []Match {
+	others := false
+	for i := range matches {
+		others = others || !plain[i]
+	}
+
+	kept := matches[:0]
+	for i, m := range matches {
+		if plain[i] {
+			if others {
+				continue
+			}
+			m.Ambiguous = true
+			m.Reason = ReasonCommonWord
+			m.Candidates = []Candidate{{Asset: m.Asset, Class: ClassOf(m.Asset), Code: BuildCode(m.Asset)}}
+		}
+		kept = append(kept, m)
+	}
+	return kept
+}
+
+// nearbyCue returns the class named by a cue word just around word i
+func nearbyCue(words []string, i int, v vocab) AssetClass {
+	for j := i - 2; j <= i+1; j++ {
+		if j >= 0 && j < len(words) && j != i {
+			if class, ok := v.cue(words[j]); ok {
+				return class
+			}
+		}
+	}
+	return ""
+}
+
+// hasNearby reports whether a word right before or after word i is in
+// the set picked by field
+func hasNearby(words []string, i int, v vocab, field func(*Language) map[string]bool) bool {
+	return (i > 0 && v.has(words[i-1], field)) || (i+1 < len(words) && v.has(words[i+1], field))
+}
+
+// ParseQuery extracts asset codes from natural language, in query order
+func ParseQuery(query string) []Match {
+	return Parse(query).Matches
+}
+
+// lookupWord resolves a single query word via aliases, then direct tickers
+func lookupWord(word string, v vocab) (code, via string) {
+	if isBlocked(word) {
+		return "", ""
+	}
+	if code, ok := v.alias(word); ok {
+		return code, ViaAlias
+	}
+	if v.has(strings.ToLower(word), stopWords) {
+		return "", ""
+	}
+	code = strings.ToUpper(word)
+	if len(code) >= 2 && len(code) <= 5 && ClassOf(code) != "" {
+		return code, ViaTicker
+	}
+	return "", ""
 }
 
 // BuildCode constructs the full code for the source API
 func BuildCode(asset string) string {
 	asset = strings.ToUpper(asset)
+	return buildCode(asset, ClassOf(asset))
+}
 
-	// Determine asset type and build code
-	if cryptoAssets[asset] {
-		return "Crypto:ALL:" + asset + "/USDT"
-	}
-	if equityAssets[asset] {
-		return "Equity:US:" + asset + "/USD"
-	}
-	if metalAssets[asset] {
+// buildCode constructs the default code for an asset in a class
+func buildCode(asset string, class AssetClass) string {
+	switch class {
+	case ClassEquity:
+		return equityCode(asset, "US")
+	case ClassMetal:
 		return "Metal:ALL:" + asset + "/USD"
+	case ClassCommodity:
+		return "Commodity:ALL:" + asset + "/USD"
+	case ClassPrediction:
+		return PredictionType + ":ALL:" + asset + "/YES"
+	case ClassForex:
+		return "Forex:ALL:" + asset + "/USD"
 	}
 
 	// Default to crypto
@@ -176,8 +602,7 @@ func BuildCode(asset string) string {
 
 // NormalizeAsset converts alias to standard code
 func NormalizeAsset(input string) string {
-	lower := strings.ToLower(input)
-	if code, ok := assetAliases[lower]; ok {
+	if code, ok := allVocab().alias(input); ok {
 		return code
 	}
 	return strings.ToUpper(input)
@@ -0,0 +1,238 @@
+package ai
+
+import (
+	"reflect"
+	"testing"
+)
+
+// span is the part of a match most tests check
+type span struct {
+	Asset      string
+	Text       string
+	Start, End int
+}
+
+func spans(matches []Match) []span {
+	out := make([]span, 0, len(matches))
+	for _, m := range matches {
+		out = append(out, span{m.Asset, m.Text, m.Start, m.End})
+	}
+	return out
+}
+
+func TestParseOrder(t *testing.T) {
+	tests := []struct {
+		text string
+		want []span
+	}{
+		{"compare ETH and BTC", []span{{"ETH", "ETH", 8, 11}, {"BTC", "BTC", 16, 19}}},
+		{"bitcoin vs ethereum vs solana", []span{
+			{"BTC", "bitcoin", 0, 7}, {"ETH", "ethereum", 11, 19}, {"SOL", "solana", 23, 29},
+		}},
+		{"ETH, then BTC, then ETH again", []span{{"ETH", "ETH", 0, 3}, {"BTC", "BTC", 10, 13}}},
+		{"price of BTC and btc", []span{{"BTC", "BTC", 9, 12}}},
+		{"natural gas price", []span{{"NATGAS", "natural gas", 0, 11}}},
+		{"what's the weather like", []span{}},
+	}
+	for _, tt := range tests {
+		q := ParseWith(tt.text, Options{})
+		if got := spans(q.Matches); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseWith(%q) matches = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseAmounts(t *testing.T) {
+	tests := []struct {
+		text   string
+		asset  string
+		amount float64
+		lang   string
+	}{
+		{"how much is 2.5 ETH", "ETH", 2.5, "en"},
+		{"what is 0.3 BTC worth", "BTC", 0.3, "en"},
+		{"100 NVDA shares", "NVDA", 100, "en"},
+		{"value of 10k DOGE", "DOGE", 10_000, "en"},
+		{"1.5m SHIB in USD", "SHIB", 1_500_000, "en"},
+		{"berapa harga 2,5 BTC", "BTC", 2.5, "id"},
+		{"cuánto valen 1.234,5 ADA", "ADA", 1234.5, "es"},
+		{"precio de 2.500 BTC", "BTC", 2500, "es"},
+		{"price of ETH", "ETH", 0, "en"},
+	}
+	for _, tt := range tests {
+		q := ParseWith(tt.text, Options{})
+		if len(q.Matches) != 1 || q.Matches[0].Asset != tt.asset || q.Matches[0].Amount != tt.amount || q.Lang != tt.lang {
+			t.Errorf("ParseWith(%q) = %+v in %s, want %v %s in %s", tt.text, q.Matches, q.Lang, tt.amount, tt.asset, tt.lang)
+		}
+	}
+}
+
+func TestParseAmbiguity(t *testing.T) {
+	tests := []struct {
+		text       string
+		opts       Options
+		asset      string
+		class      AssetClass // of the settled match
+		reason     string     // when still ambiguous
+		candidates []AssetClass
+	}{
+		{"META price", Options{}, "META", "", ReasonMultipleClasses, []AssetClass{ClassEquity, ClassCrypto}},
+		{"META stock", Options{}, "META", ClassEquity, "", nil},
+		{"META crypto", Options{}, "META", ClassCrypto, "", nil},
+		{"META price", Options{Class: ClassCrypto}, "META", ClassCrypto, "", nil},
+		{"SOL", Options{}, "SOL", "", ReasonMultipleClasses, []AssetClass{ClassCrypto, ClassForex}},
+		{"SOL on Binance", Options{}, "SOL", ClassCrypto, "", nil},
+		{"SOL/USDT", Options{}, "SOL", ClassCrypto, "", nil},
+		{"SOL price in EUR", Options{}, "SOL", "", ReasonMultipleClasses, []AssetClass{ClassCrypto, ClassForex}},
+		{"solana", Options{}, "SOL", ClassCrypto, "", nil},
+		{"link", Options{}, "LINK", ClassCrypto, ReasonCommonWord, []AssetClass{ClassCrypto}},
+		{"send me the link", Options{}, "LINK", ClassCrypto, ReasonCommonWord, []AssetClass{ClassCrypto}},
+		{"link price", Options{}, "LINK", ClassCrypto, "", nil},
+		{"LINK", Options{}, "LINK", ClassCrypto, "", nil},
+	}
+	for _, tt := range tests {
+		q := ParseWith(tt.text, tt.opts)
+		if len(q.Matches) != 1 {
+			t.Errorf("ParseWith(%q) matches = %+v, want one", tt.text, q.Matches)
+			continue
+		}
+		m := q.Matches[0]
+		var classes []AssetClass
+		for _, c := range m.Candidates {
+			classes = append(classes, c.Class)
+		}
+		ambiguous := tt.reason != ""
+		if m.Asset != tt.asset || m.Class != tt.class || m.Ambiguous != ambiguous || m.Reason != tt.reason ||
+			!reflect.DeepEqual(classes, tt.candidates) || q.NeedsClarification != ambiguous {
+			t.Errorf("ParseWith(%q, %+v) = %s %q (ambiguous %v, %q, candidates %v), want %s %q (%q, candidates %v)",
+				tt.text, tt.opts, m.Asset, m.Class, m.Ambiguous, m.Reason, classes, tt.asset, tt.class, tt.reason, tt.candidates)
+		}
+	}
+
+	// Other assets settle the class, and an everyday word is dropped
+	// when something else was named
+	q := ParseWith("BTC and SOL", Options{})
+	if got := spans(q.Matches); len(got) != 2 || q.Matches[1].Class != ClassCrypto || q.NeedsClarification {
+		t.Errorf("ParseWith(BTC and SOL) = %+v, want SOL settled as crypto", q.Matches)
+	}
+	q = ParseWith("ETH and link", Options{})
+	if got := spans(q.Matches); !reflect.DeepEqual(got, []span{{"ETH", "ETH", 0, 3}}) {
+		t.Errorf("ParseWith(ETH and link) = %+v, want only ETH", got)
+	}
+}
+
+func TestParseTypos(t *testing.T) {
+	tests := []struct {
+		text       string
+		match      string // corrected into a match
+		suggestion string // only suggested
+		didYouMean string
+	}{
+		{"what is the price of nvidea", "", "NVDA", "nvidia"},
+		{"nvidea stock", "", "NVDA", "nvidia"},
+		{"nvdia price", "NVDA", "", "nvidia"},
+		{"bitcoinn price", "BTC", "", "bitcoin"},
+		{"bitcoin price", "BTC", "", ""},
+	}
+	for _, tt := range tests {
+		q := ParseWith(tt.text, Options{})
+		var got, suggested, didYouMean string
+		if len(q.Matches) == 1 {
+			got, didYouMean = q.Matches[0].Asset, q.Matches[0].DidYouMean
+		}
+		if len(q.Suggestions) == 1 {
+			suggested, didYouMean = q.Suggestions[0].Asset, q.Suggestions[0].DidYouMean
+		}
+		if len(q.Matches) > 1 || len(q.Suggestions) > 1 || got != tt.match || suggested != tt.suggestion || didYouMean != tt.didYouMean {
+			t.Errorf("ParseWith(%q) = matches %+v, suggestions %+v; want match %q, suggestion %q, did you mean %q",
+				tt.text, q.Matches, q.Suggestions, tt.match, tt.suggestion, tt.didYouMean)
+		}
+	}
+}
+
+func TestParseForex(t *testing.T) {
+	tests := []struct {
+		text   string
+		asset  string
+		quote  string
+		code   string
+		invert bool
+	}{
+		{"EUR/USD", "EUR", "USD", "Forex:ALL:EUR/USD", false},
+		{"eurusd rate", "EUR", "USD", "Forex:ALL:EUR/USD", false},
+		{"JPY/USD", "JPY", "USD", "Forex:ALL:USD/JPY", true},
+		{"yen in dollars", "JPY", "USD", "Forex:ALL:USD/JPY", true},
+		{"USDJPY", "USD", "JPY", "Forex:ALL:USD/JPY", false},
+		{"dollar to yen", "USD", "JPY", "Forex:ALL:USD/JPY", false},
+		{"yen to euro", "JPY", "EUR", "Forex:ALL:USD/JPY", true},
+	}
+	c := forexCatalog()
+	for _, tt := range tests {
+		q := ParseWith(tt.text, Options{Catalog: c})
+		if len(q.Matches) != 1 || q.Matches[0].Asset != tt.asset || q.Matches[0].Class != ClassForex || q.Quote != tt.quote {
+			t.Errorf("ParseWith(%q) = %+v in %q, want forex %s in %s", tt.text, q.Matches, q.Quote, tt.asset, tt.quote)
+			continue
+		}
+		m := q.Matches[0]
+		res := c.Resolve(Target{Asset: m.Asset, Quote: q.Quote, Class: m.Class})
+		if res.Code != tt.code || res.Invert != tt.invert {
+			t.Errorf("%q resolved to %s (invert %v), want %s (invert %v)", tt.text, res.Code, res.Invert, tt.code, tt.invert)
+		}
+	}
+}
+
+func TestParseExchangeTickers(t *testing.T) {
+	tests := []struct {
+		text   string
+		want   span
+		market string
+		amount float64
+	}{
+		{"7203.T", span{"7203", "7203.T", 0, 6}, "JP", 0},
+		{"price of SAP.DE", span{"SAP", "SAP.DE", 9, 15}, "DE", 0},
+		{"TSE:7203 price", span{"7203", "TSE:7203", 0, 8}, "JP", 0},
+		{"0700.HK", span{"0700", "0700.HK", 0, 7}, "HK", 0},
+		{"XETRA:SAP", span{"SAP", "XETRA:SAP", 0, 9}, "DE", 0},
+		{"100 shares of 7203.T", span{"7203", "7203.T", 14, 20}, "JP", 100},
+	}
+	for _, tt := range tests {
+		q := ParseWith(tt.text, Options{})
+		got := spans(q.Matches)
+		if len(got) != 1 || got[0] != tt.want {
+			t.Errorf("ParseWith(%q) matches = %+v, want %+v", tt.text, got, tt.want)
+			continue
+		}
+		m := q.Matches[0]
+		if m.Market != tt.market || m.Amount != tt.amount || m.Via != ViaExchangeTicker || m.Class != ClassEquity {
+			t.Errorf("ParseWith(%q) = %s %v on %q via %q, want %s %v on %q via %q",
+				tt.text, m.Class, m.Amount, m.Market, m.Via, ClassEquity, tt.amount, tt.market, ViaExchangeTicker)
+		}
+	}
+}
+
+func TestParseCommodityUnits(t *testing.T) {
+	tests := []struct {
+		text  string
+		asset string
+		class AssetClass
+		unit  string
+	}{
+		{"oil price", "WTI", ClassCommodity, "per barrel"},
+		{"natural gas price", "NATGAS", ClassCommodity, "per MMBtu"},
+		{"gold price", "XAU", ClassMetal, "per troy ounce"},
+		{"harga emas", "XAU", ClassMetal, "per troy ounce"},
+		{"wheat price", "WHEAT", ClassCommodity, "per bushel"},
+		{"copper price", "COPPER", ClassCommodity, "per pound"},
+		{"bitcoin price", "BTC", ClassCrypto, ""},
+	}
+	for _, tt := range tests {
+		q := ParseWith(tt.text, Options{})
+		if len(q.Matches) != 1 || q.Matches[0].Asset != tt.asset || q.Matches[0].Class != tt.class {
+			t.Errorf("ParseWith(%q) = %+v, want %s %s", tt.text, q.Matches, tt.class, tt.asset)
+			continue
+		}
+		if got := Unit(q.Matches[0].Asset); got != tt.unit {
+			t.Errorf("Unit(%s) = %q, want %q", tt.asset, got, tt.unit)
+		}
+	}
+}
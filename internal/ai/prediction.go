@@ -0,0 +1,151 @@
+package ai
+
+import (
+	"sort"
+	"strings"
+)
+
+// Prediction markets are listed one pair per outcome, with the market
+// as the base and the outcome as the quote ("Prediction:POLYMARKET:
+// FEDCUTDEC/YES"). An outcome's price is the cost of a share paying 1
+// if it happens, which is its implied probability.
+
+// PredictionType is the code type prefix of prediction market pairs
+const PredictionType = "Prediction"
+
+// PredictionMarket groups the outcome pairs of one market
+type PredictionMarket struct {
+	Market   string    `json:"market"`
+	Venue    string    `json:"venue"`
+	Name     string    `json:"name,omitempty"`
+	Outcomes []Outcome `json:"outcomes"`
+}
+
+// Outcome is one side of a prediction market
+type Outcome struct {
+	Outcome string `json:"outcome"`
+	Code    string `json:"code"`
+}
+
+// Phrases that ask for the odds of an event; the words after them are
+// the topic
+var oddsPhrases = []string{
+	"odds of", "odds that", "odds on", "chance of", "chances of", "chance that",
+	"probability of", "probability that", "likelihood of", "likelihood that",
+}
+
+// detectOdds finds an odds phrase and returns the topic after it
+func detectOdds(words []string, v vocab) (string, bool) {
+	text := " " + strings.Join(words, " ") + " "
+	for _, lang := range v {
+		for _, phrase := range lang.Odds {
+			i := strings.Index(text, " "+phrase+" ")
+			if i < 0 {
+				continue
+			}
+			topic := strings.TrimSpace(text[i+len(phrase)+2:])
+			if topic != "" {
+				return topic, true
+			}
+		}
+	}
+	return "", false
+}
+
+// PredictionMarkets lists prediction markets whose code or name
+// contains search, or all of them when search is empty
+func (c *Catalog) PredictionMarkets(search string) []PredictionMarket {
+	search = strings.ToLower(search)
+	return c.predictionMarkets(func(e CatalogEntry) bool {
+		return search == "" ||
+			strings.Contains(strings.ToLower(e.Code), search) ||
+			strings.Contains(strings.ToLower(e.Name), search)
+	})
+}
+
+// PredictionMarket returns the market with the given base, on the
+// given venue if set
+func (c *Catalog) PredictionMarket(market, venue string) (PredictionMarket, bool) {
+	found := c.predictionMarkets(func(e CatalogEntry) bool {
+		return strings.EqualFold(e.Base, market) && (venue == "" || strings.EqualFold(e.Market, venue))
+	})
+	if len(found) == 0 {
+		return PredictionMarket{}, false
+	}
+	return found[0], true
+}
+
+// MatchPredictions ranks markets by how many topic words their name or
+// code contains, best first
+func (c *Catalog) MatchPredictions(topic string, limit int) []PredictionMarket {
+	var terms []string
+	for _, w := range wordRegex.FindAllString(strings.ToLower(topic), -1) {
+		if len(w) >= 3 && !isStopWord(w) {
+			terms = append(terms, w)
+		}
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+
+	scores := make(map[string]int)
+	markets := c.predictionMarkets(func(e CatalogEntry) bool { return true })
+	var matched []PredictionMarket
+	for _, m := range markets {
+		text := strings.ToLower(m.Market + " " + m.Name)
+		score := 0
+		for _, t := range terms {
+			if strings.Contains(text, t) {
+				score++
+			}
+		}
+		if score > 0 {
+			scores[m.Venue+":"+m.Market] = score
+			matched = append(matched, m)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return scores[matched[i].Venue+":"+matched[i].Market] > scores[matched[j].Venue+":"+matched[j].Market]
+	})
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+	return matched
+}
+
+// predictionMarkets groups the prediction pairs that keep returns true
+// for, sorted by venue and market
+func (c *Catalog) predictionMarkets(keep func(CatalogEntry) bool) []PredictionMarket {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	byMarket := make(map[string]*PredictionMarket)
+	for _, e := range c.byCode {
+		if e.Type != PredictionType || !keep(e) {
+			continue
+		}
+		key := e.Market + ":" + e.Base
+		m, ok := byMarket[key]
+		if !ok {
+			m = &PredictionMarket{Market: e.Base, Venue: e.Market, Name: e.Name}
+			byMarket[key] = m
+		}
+		m.Outcomes = append(m.Outcomes, Outcome{Outcome: e.Quote, Code: e.Code})
+	}
+
+	markets := make([]PredictionMarket, 0, len(byMarket))
+	for _, m := range byMarket {
+		sort.Slice(m.Outcomes, func(i, j int) bool { return m.Outcomes[i].Outcome > m.Outcomes[j].Outcome })
+		markets = append(markets, *m)
+	}
+	sort.Slice(markets, func(i, j int) bool {
+		if markets[i].Venue != markets[j].Venue {
+			return markets[i].Venue < markets[j].Venue
+		}
+		return markets[i].Market < markets[j].Market
+	})
+	return markets
+}
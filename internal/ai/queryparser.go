@@ -0,0 +1,23 @@
+package ai
+
+import "context"
+
+// Parser names reported in Query.Parser
+const (
+	ParserRules = "rules"
+	ParserLLM   = "llm"
+)
+
+// QueryParser turns a natural language query into assets and intent
+type QueryParser interface {
+	Parse(ctx context.Context, text string, opts Options) (Query, error)
+}
+
+// RulesParser is the alias and keyword parser. It never fails and is the
+// default and the fallback for other parsers.
+type RulesParser struct{}
+
+// Parse implements QueryParser
+func (RulesParser) Parse(_ context.Context, text string, opts Options) (Query, error) {
+	return ParseWith(text, opts), nil
+}
@@ -0,0 +1,147 @@
+package ai
+
+import "strings"
+
+// Quote currencies that can appear after "in", "to" or a pair separator
+var currencyAliases = map[string]string{
+	// Dollar and stablecoins
+	"usd":     "USD",
+	"dollar":  "USD",
+	"dollars": "USD",
+	"usdt":    "USDT",
+	"tether":  "USDT",
+	"usdc":    "USDC",
+
+	// Fiat
+	"eur":      "EUR",
+	"euro":     "EUR",
+	"euros":    "EUR",
+	"idr":      "IDR",
+	"rupiah":   "IDR",
+	"jpy":      "JPY",
+	"yen":      "JPY",
+	"gbp":      "GBP",
+	"pound":    "GBP",
+	"pounds":   "GBP",
+	"sterling": "GBP",
+	"krw":      "KRW",
+	"won":      "KRW",
+	"cny":      "CNY",
+	"yuan":     "CNY",
+	"renminbi": "CNY",
+	"hkd":      "HKD",
+	"sgd":      "SGD",
+	"aud":      "AUD",
+	"cad":      "CAD",
+	"chf":      "CHF",
+	"franc":    "CHF",
+	"francs":   "CHF",
+	"inr":      "INR",
+	"rupee":    "INR",
+	"rupees":   "INR",
+	"myr":      "MYR",
+	"ringgit":  "MYR",
+	"thb":      "THB",
+	"baht":     "THB",
+
+	// Crypto quotes
+	"btc": "BTC",
+	"eth": "ETH",
+}
+
+// Exchanges that can be named with "on", "at" or "via"
+var venueAliases = map[string]string{
+	"binance":    "BINANCE",
+	"coinbase":   "COINBASE",
+	"kraken":     "KRAKEN",
+	"okx":        "OKX",
+	"bybit":      "BYBIT",
+	"kucoin":     "KUCOIN",
+	"bitfinex":   "BITFINEX",
+	"bitstamp":   "BITSTAMP",
+	"gate":       "GATE",
+	"htx":        "HTX",
+	"huobi":      "HTX",
+	"upbit":      "UPBIT",
+	"bithumb":    "BITHUMB",
+	"indodax":    "INDODAX",
+	"tokocrypto": "TOKOCRYPTO",
+}
+
+// Words that introduce a quote currency ("ETH in EUR") or a venue ("SOL on Binance")
+var quoteMarkers = map[string]bool{"in": true, "to": true, "into": true, "as": true}
+var venueMarkers = map[string]bool{"on": true, "at": true, "via": true}
+
+// Quote currencies only crypto is priced in
+var cryptoQuotes = map[string]bool{"USDT": true, "USDC": true, "BTC": true, "ETH": true}
+
+// Currencies treated as interchangeable when matching quotes
+var usdEquivalents = map[string]bool{"USD": true, "USDT": true, "USDC": true}
+
+// SameCurrency reports whether two quote currencies can be used
+// interchangeably (USD and the major dollar stablecoins)
+func SameCurrency(a, b string) bool {
+	a, b = strings.ToUpper(a), strings.ToUpper(b)
+	return a == b || (usdEquivalents[a] && usdEquivalents[b])
+}
+
+// lookupCurrency resolves a word to a quote currency code
+func lookupCurrency(word string) (string, bool) {
+	code, ok := currencyAliases[strings.ToLower(word)]
+	return code, ok
+}
+
+// marketClass returns the class a venue or quote currency implies, or
+// "" if neither does. Every venue is a crypto exchange and crypto
+// quotes only price crypto.
+func marketClass(venue, quote string) AssetClass {
+	if venue != "" || cryptoQuotes[strings.ToUpper(quote)] {
+		return ClassCrypto
+	}
+	return ""
+}
+
+// lookupVenue resolves a word to an exchange code
+func lookupVenue(word string) (string, bool) {
+	code, ok := venueAliases[strings.ToLower(word)]
+	return code, ok
+}
+
+// ParsePair extracts asset, quote and venue from a path segment such as
+// "BTC", "bitcoin", "BTC-EUR", "BTC/USD", "binance:SOL-USDT" or
+// "ETH_IDR". Quote and venue are empty when not given.
+func ParsePair(pair string) (asset, quote, venue string) {
+	pair = strings.TrimSpace(pair)
+	if i := strings.Index(pair, ":"); i > 0 {
+		if v, ok := lookupVenue(pair[:i]); ok {
+			venue = v
+			pair = pair[i+1:]
+		}
+	}
+
+	parts := strings.FieldsFunc(pair, func(r rune) bool {
+		return r == '/' || r == '-' || r == '_'
+	})
+	if len(parts) == 2 {
+		if q, ok := lookupCurrency(parts[1]); ok {
+			return NormalizeAsset(parts[0]), q, venue
+		}
+	}
+	if base, q, ok := splitForexPair(pair); ok {
+		return base, q, venue
+	}
+	return NormalizeAsset(pair), "", venue
+}
+
+// splitForexPair reads a six-letter forex pair written without a
+// separator, such as "USDJPY" or "eurusd"
+func splitForexPair(word string) (base, quote string, ok bool) {
+	if len(word) != 6 {
+		return "", "", false
+	}
+	base, quote = strings.ToUpper(word[:3]), strings.ToUpper(word[3:])
+	if base == quote || ClassOf(base) != ClassForex || ClassOf(quote) != ClassForex {
+		return "", "", false
+	}
+	return base, quote, true
+}
@@ -0,0 +1,186 @@
+package ai
+
+import "strings"
+
+// Resolution describes which source code prices an asset, and how to
+// convert that price when the requested quote has no direct pair
+type Resolution struct {
+	Asset   string      `json:"asset"`
+	Code    string      `json:"code"`
+	Quote   string      `json:"quote"`
+	Venue   string      `json:"venue,omitempty"`
+	Listed  bool        `json:"listed"`
+	Invert  bool        `json:"invert,omitempty"` // Code is the reverse pair
+	Convert *Conversion `json:"convert,omitempty"`
+}
+
+// Conversion re-expresses a price quoted in From as a price in To,
+// using the rate from Code (inverted when only the reverse pair exists)
+type Conversion struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Code   string `json:"code"`
+	Invert bool   `json:"invert,omitempty"`
+}
+
+// Target is an asset to resolve; all but Asset are optional
+type Target struct {
+	Asset  string
+	Quote  string
+	Venue  string
+	Class  AssetClass // restricts the catalog search when set
+	Market string     // equity market ("JP"); implies ClassEquity
+}
+
+// Resolve picks the code for an asset in the requested quote currency,
+// venue and class. Direct catalog pairs win; otherwise the asset's
+// default pair is used with a conversion to the requested quote.
+// A nil or empty catalog falls back to the codes BuildCode would guess.
+func (c *Catalog) Resolve(t Target) Resolution {
+	if t.Market != "" {
+		return c.resolveListing(t)
+	}
+	asset := strings.ToUpper(t.Asset)
+	quote := strings.ToUpper(t.Quote)
+	venue := t.Venue
+	class := t.Class
+	if class == "" {
+		class = ClassOf(asset)
+	}
+	def := ParseCode(buildCode(asset, class))
+	res := Resolution{Asset: asset, Code: def.String(), Quote: def.Quote, Venue: venue}
+
+	if c.Len() == 0 {
+		if venue != "" {
+			def.Market = venue
+			res.Code = def.String()
+		}
+		if quote != "" && !SameCurrency(quote, def.Quote) {
+			res.Quote = quote
+			res.Convert = c.conversion(def.Quote, quote)
+		}
+		return res
+	}
+
+	want := quote
+	if want == "" {
+		want = def.Quote
+	}
+	pq := pairQuery{Base: asset, Type: def.Type, Strict: t.Class != ""}
+	with := func(quote, venue string) pairQuery {
+		q := pq
+		q.Quote, q.Venue = quote, venue
+		return q
+	}
+
+	// Direct pair, on the requested venue if any
+	if e, ok := c.find(with(want, venue)); ok {
+		return c.listed(res, e, quote)
+	}
+	if venue != "" {
+		// Venue lists the asset in another quote
+		if e, ok := c.find(with("", venue)); ok {
+			return c.listed(res, e, quote)
+		}
+		// Venue doesn't list it at all; use the aggregated price
+		res.Venue = ""
+		if e, ok := c.find(with(want, "")); ok {
+			return c.listed(res, e, quote)
+		}
+	}
+	// Forex is often listed one way only; "JPY/USD" is priced from "USD/JPY"
+	if class == ClassForex {
+		if e, ok := c.find(pairQuery{Base: want, Quote: asset, Type: def.Type, Strict: true}); ok {
+			res.Code, res.Quote, res.Listed, res.Invert = e.Code, want, true, true
+			return res
+		}
+	}
+	if e, ok := c.find(with(def.Quote, "")); ok {
+		return c.listed(res, e, quote)
+	}
+	if e, ok := c.find(with("", "")); ok {
+		return c.listed(res, e, quote)
+	}
+	// Crosses go through the reverse USD leg: "JPY/EUR" is priced from
+	// "USD/JPY" inverted, then converted from USD to EUR
+	if class == ClassForex && asset != "USD" {
+		if e, ok := c.find(pairQuery{Base: "USD", Quote: asset, Type: def.Type, Strict: true}); ok {
+			res.Code, res.Quote, res.Listed, res.Invert = e.Code, e.Base, true, true
+			if !SameCurrency(want, e.Base) {
+				res.Quote = want
+				res.Convert = c.conversion(e.Base, want)
+			}
+			return res
+		}
+	}
+
+	// Not listed; keep the guessed code so the source has the final say
+	if quote != "" && !SameCurrency(quote, def.Quote) {
+		res.Quote = quote
+		res.Convert = c.conversion(def.Quote, quote)
+	}
+	return res
+}
+
+// DefaultCode is the code a target resolves to before the catalog is
+// consulted
+func DefaultCode(t Target) string {
+	asset := strings.ToUpper(t.Asset)
+	if t.Market != "" {
+		return equityCode(asset, t.Market)
+	}
+	class := t.Class
+	if class == "" {
+		class = ClassOf(asset)
+	}
+	return buildCode(asset, class)
+}
+
+// listed fills a resolution from a catalog entry, adding a conversion
+// when the entry isn't quoted in the requested currency
+func (c *Catalog) listed(res Resolution, e CatalogEntry, quote string) Resolution {
+	res.Code = e.Code
+	res.Quote = e.Quote
+	res.Listed = true
+	if res.Venue != "" {
+		res.Venue = e.Market
+	}
+	if quote != "" && !SameCurrency(quote, e.Quote) {
+		res.Quote = quote
+		res.Convert = c.conversion(e.Quote, quote)
+	}
+	return res
+}
+
+// conversion finds a pair giving the rate from one currency to another
+func (c *Catalog) conversion(from, to string) *Conversion {
+	conv := &Conversion{From: from, To: to}
+
+	bases := []string{from}
+	if usdEquivalents[from] && from != "USD" {
+		bases = append(bases, "USD")
+	}
+	for _, base := range bases {
+		if e, ok := c.find(pairQuery{Base: base, Quote: to}); ok {
+			conv.Code = e.Code
+			return conv
+		}
+	}
+	if e, ok := c.find(pairQuery{Base: to, Quote: from}); ok {
+		conv.Code = e.Code
+		conv.Invert = true
+		return conv
+	}
+
+	// Unlisted: crypto quotes are priced in USDT, fiat via USD forex
+	switch {
+	case ClassOf(to) == ClassCrypto:
+		conv.Code = "Crypto:ALL:" + to + "/USDT"
+		conv.Invert = true
+	case usdEquivalents[to]:
+		conv.Code = "Forex:ALL:" + from + "/USD"
+	default:
+		conv.Code = "Forex:ALL:USD/" + to
+	}
+	return conv
+}
@@ -0,0 +1,88 @@
+package ai
+
+import "testing"
+
+// forexCatalog lists the majors one way only, as the source does
+func forexCatalog() *Catalog {
+	c := NewCatalog()
+	c.Load([]CatalogEntry{
+		{Code: "Forex:ALL:EUR/USD"},
+		{Code: "Forex:ALL:GBP/USD"},
+		{Code: "Forex:ALL:USD/JPY"},
+		{Code: "Forex:ALL:USD/CHF"},
+		{Code: "Crypto:ALL:BTC/USDT"},
+	})
+	return c
+}
+
+func TestResolveForex(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  Target
+		code    string
+		quote   string
+		invert  bool
+		convert *Conversion
+	}{
+		{
+			name:   "listed pair",
+			target: Target{Asset: "EUR", Quote: "USD"},
+			code:   "Forex:ALL:EUR/USD",
+			quote:  "USD",
+		},
+		{
+			name:   "reverse pair",
+			target: Target{Asset: "JPY", Quote: "USD"},
+			code:   "Forex:ALL:USD/JPY",
+			quote:  "USD",
+			invert: true,
+		},
+		{
+			name:   "reverse pair in the default quote",
+			target: Target{Asset: "CHF"},
+			code:   "Forex:ALL:USD/CHF",
+			quote:  "USD",
+			invert: true,
+		},
+		{
+			name:    "cross through the inverted USD leg",
+			target:  Target{Asset: "JPY", Quote: "EUR"},
+			code:    "Forex:ALL:USD/JPY",
+			quote:   "EUR",
+			invert:  true,
+			convert: &Conversion{From: "USD", To: "EUR", Code: "Forex:ALL:EUR/USD", Invert: true},
+		},
+		{
+			name:    "cross between two reverse legs",
+			target:  Target{Asset: "CHF", Quote: "JPY"},
+			code:    "Forex:ALL:USD/CHF",
+			quote:   "JPY",
+			invert:  true,
+			convert: &Conversion{From: "USD", To: "JPY", Code: "Forex:ALL:USD/JPY"},
+		},
+		{
+			name:    "cross with a direct leg",
+			target:  Target{Asset: "GBP", Quote: "EUR"},
+			code:    "Forex:ALL:GBP/USD",
+			quote:   "EUR",
+			convert: &Conversion{From: "USD", To: "EUR", Code: "Forex:ALL:EUR/USD", Invert: true},
+		},
+	}
+
+	c := forexCatalog()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := c.Resolve(tt.target)
+			if res.Code != tt.code || res.Quote != tt.quote || res.Invert != tt.invert || !res.Listed {
+				t.Errorf("Resolve(%+v) = %s in %s (invert %v, listed %v), want %s in %s (invert %v, listed)",
+					tt.target, res.Code, res.Quote, res.Invert, res.Listed, tt.code, tt.quote, tt.invert)
+			}
+			switch {
+			case tt.convert == nil && res.Convert != nil:
+				t.Errorf("Resolve(%+v) converts %+v, want no conversion", tt.target, *res.Convert)
+			case tt.convert != nil && (res.Convert == nil || *res.Convert != *tt.convert):
+				t.Errorf("Resolve(%+v) converts %+v, want %+v", tt.target, res.Convert, *tt.convert)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package ai
+
+import "strings"
+
+// Units commodity and metal prices are quoted in
+var assetUnits = map[string]string{
+	// Metals
+	"XAU": "per troy ounce", "XAG": "per troy ounce", "XPT": "per troy ounce", "XPD": "per troy ounce",
+	// Energy
+	"WTI": "per barrel", "BRENT": "per barrel", "NATGAS": "per MMBtu",
+	"CL": "per barrel", "NG": "per MMBtu", // futures symbols some sources list
+	// Industrial and soft
+	"COPPER": "per pound", "HG": "per pound", "SUGAR": "per pound", "COFFEE": "per pound", "COTTON": "per pound",
+	"CORN": "per bushel", "WHEAT": "per bushel", "SOYBEAN": "per bushel",
+	"COCOA": "per metric ton",
+}
+
+// Unit returns what one unit of an asset's price buys ("per barrel"),
+// or "" for assets priced per coin, share or currency unit
+func Unit(asset string) string {
+	return assetUnits[strings.ToUpper(asset)]
+}
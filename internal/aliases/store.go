@@ -0,0 +1,320 @@
+package aliases
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Entry kinds
+const (
+	KindAlias = "alias" // word -> asset, per language
+	KindClass = "class" // asset -> class override
+	KindBlock = "block" // word never read as an asset
+)
+
+// Store keeps the alias dictionary and its change history
+type Store struct {
+	db     *sql.DB
+	shared bool // db belongs to another store and stays open on Close
+}
+
+// Entry is one dictionary row
+type Entry struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	Term      string    `json:"term"`
+	Value     string    `json:"value,omitempty"`
+	Lang      string    `json:"lang,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Change is one history row. Old and new values are empty for creates
+// and deletes respectively.
+type Change struct {
+	ID        int64     `json:"id"`
+	EntryID   int64     `json:"entry_id"`
+	Action    string    `json:"action"`
+	Kind      string    `json:"kind"`
+	Term      string    `json:"term"`
+	Lang      string    `json:"lang,omitempty"`
+	OldValue  string    `json:"old_value,omitempty"`
+	NewValue  string    `json:"new_value,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// ErrNotFound is returned for unknown entry IDs
+var ErrNotFound = fmt.Errorf("alias not found")
+
+// NewStore opens the dictionary tables in the SQLite database at dbPath
+func NewStore(dbPath string) (*Store, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	return open(db)
+}
+
+// NewMemoryStore keeps the dictionary in a private in-memory database,
+// lost when the store is closed
+func NewMemoryStore() (*Store, error) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	// Each connection to ":memory:" is a database of its own
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	return open(db)
+}
+
+// OpenDB keeps the dictionary in a database another store already has
+// open, such as the key store's. Sharing one pool keeps the two from
+// locking each other out of a SQLite file. Close leaves db open.
+func OpenDB(db *sql.DB) (*Store, error) {
+	s, err := open(db)
+	if err != nil {
+		return nil, err
+	}
+	s.shared = true
+	return s, nil
+}
+
+// open creates the dictionary tables if they are missing
+func open(db *sql.DB) (*Store, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS aliases (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind TEXT NOT NULL,
+			term TEXT NOT NULL,
+			value TEXT NOT NULL DEFAULT '',
+			lang TEXT NOT NULL DEFAULT '',
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(kind, term, lang)
+		);
+		CREATE TABLE IF NOT EXISTS alias_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			entry_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			term TEXT NOT NULL,
+			lang TEXT NOT NULL DEFAULT '',
+			old_value TEXT NOT NULL DEFAULT '',
+			new_value TEXT NOT NULL DEFAULT '',
+			changed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Seed inserts built-in entries that are missing, so new releases can
+// add words. Entries an admin has changed or deleted are left alone.
+func (s *Store) Seed(entries []Entry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT OR IGNORE INTO aliases (kind, term, value, lang)
+		SELECT ?, ?, ?, ?
+		WHERE NOT EXISTS (
+			SELECT 1 FROM alias_history WHERE action = 'delete' AND kind = ? AND term = ? AND lang = ?
+		)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var added int64
+	for _, e := range entries {
+		result, err := stmt.Exec(e.Kind, e.Term, e.Value, e.Lang, e.Kind, e.Term, e.Lang)
+		if err != nil {
+			return err
+		}
+		n, _ := result.RowsAffected()
+		added += n
+	}
+	if added > 0 {
+		if _, err := tx.Exec("INSERT INTO alias_history (entry_id, action, kind, term) VALUES (0, 'seed', '', '')"); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// List returns entries, optionally filtered by kind and language
+func (s *Store) List(kind, lang string) ([]Entry, error) {
+	query := "SELECT id, kind, term, value, lang, updated_at FROM aliases WHERE 1=1"
+	var args []interface{}
+	if kind != "" {
+		query += " AND kind = ?"
+		args = append(args, kind)
+	}
+	if lang != "" {
+		query += " AND lang = ?"
+		args = append(args, lang)
+	}
+	query += " ORDER BY kind, lang, term"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.ID, &e.Kind, &e.Term, &e.Value, &e.Lang, &e.UpdatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Get returns an entry by ID
+func (s *Store) Get(id int64) (*Entry, error) {
+	var e Entry
+	err := s.db.QueryRow(
+		"SELECT id, kind, term, value, lang, updated_at FROM aliases WHERE id = ?",
+		id,
+	).Scan(&e.ID, &e.Kind, &e.Term, &e.Value, &e.Lang, &e.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Create adds an entry
+func (s *Store) Create(e Entry) (*Entry, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT INTO aliases (kind, term, value, lang) VALUES (?, ?, ?, ?)",
+		e.Kind, e.Term, e.Value, e.Lang,
+	)
+	if err != nil {
+		return nil, err
+	}
+	e.ID, _ = result.LastInsertId()
+	e.UpdatedAt = time.Now()
+
+	if err := record(tx, "create", e, ""); err != nil {
+		return nil, err
+	}
+	return &e, tx.Commit()
+}
+
+// Update changes an entry's value
+func (s *Store) Update(id int64, value string) (*Entry, error) {
+	old, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE aliases SET value = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", value, id); err != nil {
+		return nil, err
+	}
+	e := *old
+	e.Value = value
+	e.UpdatedAt = time.Now()
+
+	if err := record(tx, "update", e, old.Value); err != nil {
+		return nil, err
+	}
+	return &e, tx.Commit()
+}
+
+// Delete removes an entry
+func (s *Store) Delete(id int64) error {
+	old, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM aliases WHERE id = ?", id); err != nil {
+		return err
+	}
+	e := *old
+	e.Value = ""
+	if err := record(tx, "delete", e, old.Value); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// History returns the most recent changes, newest first
+func (s *Store) History(limit int) ([]Change, error) {
+	rows, err := s.db.Query(
+		`SELECT id, entry_id, action, kind, term, lang, old_value, new_value, changed_at
+		FROM alias_history ORDER BY id DESC LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []Change
+	for rows.Next() {
+		var c Change
+		if err := rows.Scan(&c.ID, &c.EntryID, &c.Action, &c.Kind, &c.Term, &c.Lang, &c.OldValue, &c.NewValue, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// Version returns the latest change ID. It grows with every write, so
+// replicas can poll it to know when to reload.
+func (s *Store) Version() (int64, error) {
+	var version sql.NullInt64
+	err := s.db.QueryRow("SELECT MAX(id) FROM alias_history").Scan(&version)
+	return version.Int64, err
+}
+
+func record(tx *sql.Tx, action string, e Entry, oldValue string) error {
+	_, err := tx.Exec(
+		"INSERT INTO alias_history (entry_id, action, kind, term, lang, old_value, new_value) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		e.ID, action, e.Kind, e.Term, e.Lang, oldValue, e.Value,
+	)
+	return err
+}
+
+// Close closes the database connection, unless it was opened by the
+// caller of OpenDB
+func (s *Store) Close() error {
+	if s.shared {
+		return nil
+	}
+	return s.db.Close()
+}
@@ -0,0 +1,199 @@
+package aliases
+
+import "testing"
+
+// stores opens each way of keeping the dictionary empty, so the tests
+// below hold both to the same behavior
+func stores(t *testing.T) map[string]*Store {
+	file, err := NewStore(t.TempDir() + "/aliases.db")
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	memory, err := NewMemoryStore()
+	if err != nil {
+		t.Fatalf("NewMemoryStore: %v", err)
+	}
+	t.Cleanup(func() {
+		file.Close()
+		memory.Close()
+	})
+	return map[string]*Store{"sqlite": file, "memory": memory}
+}
+
+func TestStoreCRUD(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			created, err := s.Create(Entry{Kind: KindAlias, Term: "emas", Value: "XAU", Lang: "id"})
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			if _, err := s.Create(Entry{Kind: KindAlias, Term: "emas", Value: "XAG", Lang: "id"}); err == nil {
+				t.Error("Create accepted a duplicate kind, term and lang")
+			}
+			if _, err := s.Create(Entry{Kind: KindAlias, Term: "emas", Value: "XAU", Lang: "ms"}); err != nil {
+				t.Errorf("Create(same term in another lang): %v", err)
+			}
+			if _, err := s.Create(Entry{Kind: KindBlock, Term: "link"}); err != nil {
+				t.Fatalf("Create(block): %v", err)
+			}
+
+			got, err := s.Get(created.ID)
+			if err != nil || got.Term != "emas" || got.Value != "XAU" || got.Lang != "id" {
+				t.Errorf("Get = %+v, %v, want the emas alias", got, err)
+			}
+			if list, _ := s.List(KindAlias, "id"); len(list) != 1 || list[0].ID != created.ID {
+				t.Errorf("List(alias, id) = %+v, want the emas alias", list)
+			}
+			if list, _ := s.List("", ""); len(list) != 3 {
+				t.Errorf("List() = %d entries, want 3", len(list))
+			}
+
+			updated, err := s.Update(created.ID, "XAG")
+			if err != nil || updated.Value != "XAG" {
+				t.Fatalf("Update = %+v, %v, want XAG", updated, err)
+			}
+			if got, _ := s.Get(created.ID); got.Value != "XAG" {
+				t.Errorf("Get after Update = %+v, want XAG", got)
+			}
+
+			if err := s.Delete(created.ID); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := s.Get(created.ID); err != ErrNotFound {
+				t.Errorf("Get(deleted) = %v, want ErrNotFound", err)
+			}
+			if _, err := s.Update(created.ID, "XAU"); err != ErrNotFound {
+				t.Errorf("Update(deleted) = %v, want ErrNotFound", err)
+			}
+			if err := s.Delete(created.ID); err != ErrNotFound {
+				t.Errorf("Delete(deleted) = %v, want ErrNotFound", err)
+			}
+
+			history, err := s.History(10)
+			if err != nil {
+				t.Fatalf("History: %v", err)
+			}
+			var actions []string
+			for _, c := range history {
+				actions = append(actions, c.Action)
+			}
+			if len(history) != 5 || history[0].Action != "delete" || history[0].OldValue != "XAG" ||
+				history[1].Action != "update" || history[1].OldValue != "XAU" || history[1].NewValue != "XAG" {
+				t.Errorf("History actions = %v (%+v), want delete, update and three creates, newest first", actions, history)
+			}
+		})
+	}
+}
+
+func TestStoreSeed(t *testing.T) {
+	seed := []Entry{
+		{Kind: KindAlias, Term: "emas", Value: "XAU", Lang: "id"},
+		{Kind: KindAlias, Term: "perak", Value: "XAG", Lang: "id"},
+		{Kind: KindClass, Term: "META", Value: "stock"},
+	}
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := s.Seed(seed); err != nil {
+				t.Fatalf("Seed: %v", err)
+			}
+			entries, _ := s.List("", "")
+			if len(entries) != 3 {
+				t.Fatalf("List after Seed = %+v, want 3 entries", entries)
+			}
+			var emas, perak Entry
+			for _, e := range entries {
+				switch e.Term {
+				case "emas":
+					emas = e
+				case "perak":
+					perak = e
+				}
+			}
+
+			// Admin changes survive the next boot's seed
+			if _, err := s.Update(emas.ID, "XAUUSD"); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			if err := s.Delete(perak.ID); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			version, _ := s.Version()
+			if err := s.Seed(seed); err != nil {
+				t.Fatalf("Seed again: %v", err)
+			}
+			entries, _ = s.List(KindAlias, "id")
+			if len(entries) != 1 || entries[0].Term != "emas" || entries[0].Value != "XAUUSD" {
+				t.Errorf("List after reseed = %+v, want only emas, still XAUUSD", entries)
+			}
+			if after, _ := s.Version(); after != version {
+				t.Errorf("Version after a reseed that added nothing = %d, want %d", after, version)
+			}
+
+			// A new release's words are added
+			if err := s.Seed(append(seed, Entry{Kind: KindBlock, Term: "link"})); err != nil {
+				t.Fatalf("Seed with a new entry: %v", err)
+			}
+			if blocked, _ := s.List(KindBlock, ""); len(blocked) != 1 {
+				t.Errorf("List(block) = %+v, want the new entry", blocked)
+			}
+			if after, _ := s.Version(); after <= version {
+				t.Errorf("Version after seeding a new entry = %d, want above %d", after, version)
+			}
+		})
+	}
+}
+
+func TestStoreVersion(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			version, err := s.Version()
+			if err != nil || version != 0 {
+				t.Fatalf("Version of an empty store = %d, %v, want 0", version, err)
+			}
+			e, err := s.Create(Entry{Kind: KindBlock, Term: "link"})
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			steps := []struct {
+				name  string
+				write func() error
+			}{
+				{"update", func() error { _, err := s.Update(e.ID, ""); return err }},
+				{"create", func() error { _, err := s.Create(Entry{Kind: KindBlock, Term: "dash"}); return err }},
+				{"delete", func() error { return s.Delete(e.ID) }},
+			}
+			last, _ := s.Version()
+			if last <= version {
+				t.Errorf("Version after create = %d, want above %d", last, version)
+			}
+			for _, step := range steps {
+				if err := step.write(); err != nil {
+					t.Fatalf("%s: %v", step.name, err)
+				}
+				v, _ := s.Version()
+				if v <= last {
+					t.Errorf("Version after %s = %d, want above %d", step.name, v, last)
+				}
+				last = v
+			}
+		})
+	}
+}
+
+func TestOpenDBShared(t *testing.T) {
+	owner, err := NewStore(t.TempDir() + "/aliases.db")
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer owner.Close()
+	shared, err := OpenDB(owner.db)
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	if err := shared.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := owner.Create(Entry{Kind: KindBlock, Term: "link"}); err != nil {
+		t.Errorf("Create after closing the sharing store: %v", err)
+	}
+}
@@ -1,114 +1,499 @@
 package auth
 
 import (
+	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// Store handles API key storage
+// Keys are stored as a SHA-256 hash plus a short public prefix. The
+// prefix identifies a key in logs, usage counters and admin views; the
+// plaintext is only ever returned once, when the key is generated.
+
+// Key statuses. Disabled keys can be enabled again; revoked ones can't.
+const (
+	StatusActive   = "active"
+	StatusDisabled = "disabled"
+	StatusRevoked  = "revoked"
+)
+
+// prefixLen is the length of a key's public prefix ("pfa_" + 12 hex)
+const prefixLen = 16
+
+// Store handles API key storage. Keys are read from the database on
+// every request, so status changes apply at once on all replicas
+// sharing it.
 type Store struct {
-	db *sql.DB
+	db       *sql.DB
+	migrated []MigratedKey
+	secrets  cipher.AEAD // encrypts signing secrets; nil disables signing
 }
 
 // APIKey represents an API key record
 type APIKey struct {
 	ID        int64     `json:"id"`
-	Key       string    `json:"key"`
+	Prefix    string    `json:"prefix"`        // public part of the key
+	Key       string    `json:"key,omitempty"` // plaintext, only when generated
 	AgentID   string    `json:"agent_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	LastUsed  time.Time `json:"last_used,omitempty"`
 	HitCount  int64     `json:"hit_count"`
+
+	Status        string     `json:"status"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason string     `json:"revoked_reason,omitempty"`
+
+	Scopes Scopes `json:"scopes"`
+
+	Plan      string          `json:"plan"`
+	Overrides *LimitOverrides `json:"overrides,omitempty"`
+	Limits    Limits          `json:"limits"` // the plan's, with overrides applied
+
+	// Usage counters and quotas are kept under UsageKey, which is the
+	// prefix of the first key in a rotation chain
+	UsageKey    string     `json:"-"`
+	RotatedFrom int64      `json:"rotated_from,omitempty"`
+	SuccessorID int64      `json:"successor_id,omitempty"`
+	RotatedAt   *time.Time `json:"rotated_at,omitempty"`
+
+	// Child keys are issued by a key holder for their sub-agents
+	ParentID int64   `json:"parent_id,omitempty"`
+	Label    string  `json:"label,omitempty"`
+	Parent   *APIKey `json:"-"` // set by ValidateKey
+
+	// Requests may be signed with a secret instead of sending the key
+	SigningSecret    string `json:"-"`
+	RequireSignature bool   `json:"require_signature"`
+
+	// Keys owned by an organization also draw on its pooled limits
+	OrgID int64 `json:"org_id,omitempty"`
+	Org   *Org  `json:"-"` // set by ValidateKey
+}
+
+// MigratedKey is a plaintext key that was hashed when the store opened
+type MigratedKey struct {
+	Key    string
+	Prefix string
+}
+
+// Errors returned by key lookups and status changes
+var (
+	ErrNotFound    = fmt.Errorf("API key not found")
+	ErrInvalidKey  = fmt.Errorf("invalid API key")
+	ErrKeyDisabled = fmt.Errorf("API key is disabled")
+	ErrKeyRevoked  = fmt.Errorf("API key has been revoked")
+	ErrKeyExpired  = fmt.Errorf("API key has expired")
+)
+
+// keyColumns are the columns scanKey reads from keyTables, in order
+const (
+	keyColumns = `k.id, k.key_prefix, k.agent_id, k.created_at, k.last_used, k.hit_count,
+		k.status, k.expires_at, k.revoked_at, k.revoked_reason, k.scopes,
+		k.plan, k.limit_overrides, p.per_second, p.burst, p.daily, p.monthly,
+		k.usage_key, k.rotated_from, k.successor_id, k.rotated_at, k.parent_id, k.label,
+		k.signing_secret, k.require_signature, k.org_id`
+	keyTables = "api_keys k LEFT JOIN plans p ON p.name = k.plan"
+)
+
+const keysTable = `
+	CREATE TABLE IF NOT EXISTS %s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		key_prefix TEXT UNIQUE NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		agent_id TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used DATETIME,
+		hit_count INTEGER DEFAULT 0,
+		status TEXT NOT NULL DEFAULT 'active',
+		expires_at DATETIME,
+		revoked_at DATETIME,
+		revoked_reason TEXT NOT NULL DEFAULT '',
+		scopes TEXT NOT NULL DEFAULT '',
+		plan TEXT NOT NULL DEFAULT 'free',
+		limit_overrides TEXT NOT NULL DEFAULT '',
+		usage_key TEXT NOT NULL DEFAULT '',
+		rotated_from INTEGER NOT NULL DEFAULT 0,
+		successor_id INTEGER NOT NULL DEFAULT 0,
+		rotated_at DATETIME,
+		parent_id INTEGER NOT NULL DEFAULT 0,
+		label TEXT NOT NULL DEFAULT '',
+		signing_secret TEXT NOT NULL DEFAULT '',
+		require_signature INTEGER NOT NULL DEFAULT 0,
+		org_id INTEGER NOT NULL DEFAULT 0
+	)`
+
+// addedColumns are added by the baseline migration to tables created
+// before they existed. Later columns belong in a migration of their own.
+var addedColumns = []struct{ name, def string }{
+	{"status", "TEXT NOT NULL DEFAULT 'active'"},
+	{"expires_at", "DATETIME"},
+	{"revoked_at", "DATETIME"},
+	{"revoked_reason", "TEXT NOT NULL DEFAULT ''"},
+	{"scopes", "TEXT NOT NULL DEFAULT ''"},
+	{"plan", "TEXT NOT NULL DEFAULT 'free'"},
+	{"limit_overrides", "TEXT NOT NULL DEFAULT ''"},
+	{"usage_key", "TEXT NOT NULL DEFAULT ''"},
+	{"rotated_from", "INTEGER NOT NULL DEFAULT 0"},
+	{"successor_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"rotated_at", "DATETIME"},
+	{"parent_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"label", "TEXT NOT NULL DEFAULT ''"},
+	{"signing_secret", "TEXT NOT NULL DEFAULT ''"},
+	{"require_signature", "INTEGER NOT NULL DEFAULT 0"},
+	{"org_id", "INTEGER NOT NULL DEFAULT 0"},
 }
 
-// NewStore creates a new API key store
+// NewStore opens the SQLite key store at dbPath and migrates its
+// schema to the latest version
 func NewStore(dbPath string) (*Store, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	s, err := OpenStore(dbPath)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.Migrate(LatestVersion()); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// busyTimeout is how long a write waits for another connection's lock
+// before failing with "database is locked". The pool has several
+// connections, and other stores share it through DB.
+const busyTimeout = "5000" // milliseconds
 
-	// Create tables
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS api_keys (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			key TEXT UNIQUE NOT NULL,
-			agent_id TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			last_used DATETIME,
-			hit_count INTEGER DEFAULT 0
-		);
-		CREATE INDEX IF NOT EXISTS idx_key ON api_keys(key);
-	`)
+// OpenStore opens the SQLite key store at dbPath without migrating it
+func OpenStore(dbPath string) (*Store, error) {
+	sep := "?"
+	if strings.Contains(dbPath, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite3", dbPath+sep+"_busy_timeout="+busyTimeout)
 	if err != nil {
 		return nil, err
 	}
-
+	if _, err := db.Exec(migrationsTable); err != nil {
+		db.Close()
+		return nil, err
+	}
 	return &Store{db: db}, nil
 }
 
-// GenerateKey creates a new API key
-func (s *Store) GenerateKey(agentID string) (*APIKey, error) {
+// migratePlaintext hashes the keys of databases from before keys were
+// hashed, rebuilding the table in place without the plaintext column
+func (s *Store) migratePlaintext(tx *sql.Tx) error {
+	plaintext, err := hasColumn(tx, "api_keys", "key")
+	if err != nil || !plaintext {
+		return err
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(keysTable, "api_keys_hashed")); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT id, key, agent_id, created_at, last_used, hit_count FROM api_keys")
+	if err != nil {
+		return err
+	}
+	type oldRow struct {
+		id        int64
+		key       string
+		agentID   sql.NullString
+		createdAt sql.NullTime
+		lastUsed  sql.NullTime
+		hitCount  int64
+	}
+	var old []oldRow
+	for rows.Next() {
+		var r oldRow
+		if err := rows.Scan(&r.id, &r.key, &r.agentID, &r.createdAt, &r.lastUsed, &r.hitCount); err != nil {
+			rows.Close()
+			return err
+		}
+		old = append(old, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var migrated []MigratedKey
+	for _, r := range old {
+		_, err := tx.Exec(
+			"INSERT INTO api_keys_hashed (id, key_prefix, key_hash, agent_id, created_at, last_used, hit_count) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			r.id, keyPrefix(r.key), hashKey(r.key), r.agentID, r.createdAt, r.lastUsed, r.hitCount,
+		)
+		if err != nil {
+			return err
+		}
+		migrated = append(migrated, MigratedKey{Key: r.key, Prefix: keyPrefix(r.key)})
+	}
+
+	if _, err := tx.Exec("DROP TABLE api_keys; ALTER TABLE api_keys_hashed RENAME TO api_keys"); err != nil {
+		return err
+	}
+	s.migrated = migrated
+	return nil
+}
+
+// Migrated returns the keys hashed when the store was opened, so data
+// kept under their plaintext elsewhere can be moved to their prefix
+func (s *Store) Migrated() []MigratedKey {
+	return s.migrated
+}
+
+func hasColumn(tx *sql.Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, ctype string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &dflt, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// GenerateKey creates a new API key limited to scopes. The returned
+// record is the only one that carries the plaintext. It returns
+// ErrAgentIDTaken when another live key holds agentID.
+func (s *Store) GenerateKey(agentID string, scopes Scopes) (*APIKey, error) {
+	return generateKey(s.db, agentID, scopes)
+}
+
+// execer is a database or a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// generateKey inserts a new key with db, for GenerateKey and
+// GenerateInvitedKey
+func generateKey(db execer, agentID string, scopes Scopes) (*APIKey, error) {
 	key := generateRandomKey()
 
-	result, err := s.db.Exec(
-		"INSERT INTO api_keys (key, agent_id) VALUES (?, ?)",
-		key, agentID,
+	result, err := db.Exec(
+		"INSERT INTO api_keys (key_prefix, key_hash, agent_id, scopes) VALUES (?, ?, ?, ?)",
+		keyPrefix(key), hashKey(key), agentID, encodeScopes(scopes),
 	)
 	if err != nil {
-		return nil, err
+		return nil, agentIDConflict(err)
 	}
 
 	id, _ := result.LastInsertId()
 	return &APIKey{
 		ID:        id,
+		Prefix:    keyPrefix(key),
 		Key:       key,
 		AgentID:   agentID,
 		CreatedAt: time.Now(),
+		Status:    StatusActive,
+		Scopes:    scopes,
+		Plan:      PlanFree,
+		UsageKey:  keyPrefix(key),
 	}, nil
 }
 
-// ValidateKey checks if an API key exists
+// ValidateKey looks up an API key by its hash and checks that it, and
+// the parent of a child key, may be used. It loads the key's parent and
+// organization.
 func (s *Store) ValidateKey(key string) (*APIKey, error) {
-	var apiKey APIKey
-	err := s.db.QueryRow(
-		"SELECT id, key, agent_id, created_at, hit_count FROM api_keys WHERE key = ?",
-		key,
-	).Scan(&apiKey.ID, &apiKey.Key, &apiKey.AgentID, &apiKey.CreatedAt, &apiKey.HitCount)
+	return s.validate(s.get("k.key_hash = ?", hashKey(key)))
+}
+
+// validate checks a looked up key and loads its parent
+func (s *Store) validate(apiKey *APIKey, err error) (*APIKey, error) {
+	if err == ErrNotFound {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	return checkKey(s, apiKey)
+}
+
+// keyLookup is what checkKey needs from a store
+type keyLookup interface {
+	GetKey(id int64) (*APIKey, error)
+	lookupOrg(id int64) (*Org, error)
+}
+
+// checkKey checks that a key and the parent of a child key may be used,
+// and loads its parent and organization
+func checkKey(l keyLookup, apiKey *APIKey) (*APIKey, error) {
+	if err := apiKey.usable(); err != nil {
+		return nil, err
+	}
+
+	if apiKey.ParentID != 0 {
+		parent, err := l.GetKey(apiKey.ParentID)
+		if err == ErrNotFound {
+			return nil, ErrKeyRevoked
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := parent.usable(); err != nil {
+			return nil, err
+		}
+		apiKey.Parent = parent
+	}
+	if apiKey.OrgID != 0 {
+		org, err := l.lookupOrg(apiKey.OrgID)
+		if err != nil && err != ErrOrgNotFound {
+			return nil, err
+		}
+		apiKey.Org = org
+	}
+	return apiKey, nil
+}
+
+// usable returns why a key may not be used, or nil
+func (k *APIKey) usable() error {
+	switch {
+	case k.Status == StatusRevoked:
+		return ErrKeyRevoked
+	case k.Status == StatusDisabled:
+		return ErrKeyDisabled
+	case k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt):
+		return ErrKeyExpired
+	}
+	return nil
+}
 
+// GetKey returns a key by ID
+func (s *Store) GetKey(id int64) (*APIKey, error) {
+	return s.get("k.id = ?", id)
+}
+
+func (s *Store) get(where string, arg interface{}) (*APIKey, error) {
+	apiKey, err := scanKey(s.db.QueryRow("SELECT "+keyColumns+" FROM "+keyTables+" WHERE "+where, arg))
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("invalid API key")
+		return nil, ErrNotFound
 	}
+	return apiKey, err
+}
+
+// scanKey reads a row of keyColumns
+func scanKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var k APIKey
+	var agentID sql.NullString
+	var lastUsed, expiresAt, revokedAt, rotatedAt sql.NullTime
+	var scopes, overrides string
+	var perSecond, burst, daily, monthly sql.NullInt64
+	err := row.Scan(&k.ID, &k.Prefix, &agentID, &k.CreatedAt, &lastUsed, &k.HitCount,
+		&k.Status, &expiresAt, &revokedAt, &k.RevokedReason, &scopes,
+		&k.Plan, &overrides, &perSecond, &burst, &daily, &monthly,
+		&k.UsageKey, &k.RotatedFrom, &k.SuccessorID, &rotatedAt, &k.ParentID, &k.Label,
+		&k.SigningSecret, &k.RequireSignature, &k.OrgID)
 	if err != nil {
 		return nil, err
 	}
+	k.Scopes = decodeScopes(scopes)
+	k.Overrides = decodeOverrides(overrides)
+	k.Limits = k.Overrides.apply(planLimits(perSecond, burst, daily, monthly))
+	k.AgentID = agentID.String
+	if lastUsed.Valid {
+		k.LastUsed = lastUsed.Time
+	}
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	if rotatedAt.Valid {
+		k.RotatedAt = &rotatedAt.Time
+	}
+	if k.UsageKey == "" {
+		k.UsageKey = k.Prefix
+	}
+	return &k, nil
+}
+
+// SetStatus disables or re-enables a key. Revoked keys stay revoked.
+func (s *Store) SetStatus(id int64, status string) error {
+	if status != StatusActive && status != StatusDisabled {
+		return fmt.Errorf("invalid status %q", status)
+	}
+	return s.update(id, "UPDATE api_keys SET status = ? WHERE id = ? AND status != 'revoked'", status, id)
+}
+
+// Revoke permanently stops a key from being used
+func (s *Store) Revoke(id int64, reason string) error {
+	return s.update(id,
+		"UPDATE api_keys SET status = 'revoked', revoked_at = ?, revoked_reason = ? WHERE id = ? AND status != 'revoked'",
+		time.Now().UTC(), reason, id,
+	)
+}
 
-	return &apiKey, nil
+// SetExpiry sets when a key stops working; nil means never
+func (s *Store) SetExpiry(id int64, expiresAt *time.Time) error {
+	var at interface{}
+	if expiresAt != nil {
+		at = expiresAt.UTC()
+	}
+	return s.update(id, "UPDATE api_keys SET expires_at = ? WHERE id = ?", at, id)
+}
+
+// SetScopes replaces a key's scopes
+func (s *Store) SetScopes(id int64, scopes Scopes) error {
+	return s.update(id, "UPDATE api_keys SET scopes = ? WHERE id = ?", encodeScopes(scopes), id)
+}
+
+// DeleteKey removes a key
+func (s *Store) DeleteKey(id int64) error {
+	return s.update(id, "DELETE FROM api_keys WHERE id = ?", id)
+}
+
+// update runs a statement on one key, telling a missing key apart from
+// a revoked one the statement skipped
+func (s *Store) update(id int64, query string, args ...interface{}) error {
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return nil
+	}
+	k, err := s.GetKey(id)
+	if err != nil {
+		return err
+	}
+	if k.Status == StatusRevoked {
+		return ErrKeyRevoked
+	}
+	return nil
 }
 
 // IncrementUsage updates the hit count and last used time
-func (s *Store) IncrementUsage(key string) error {
+func (s *Store) IncrementUsage(id int64) error {
 	_, err := s.db.Exec(
-		"UPDATE api_keys SET hit_count = hit_count + 1, last_used = CURRENT_TIMESTAMP WHERE key = ?",
-		key,
+		"UPDATE api_keys SET hit_count = hit_count + 1, last_used = CURRENT_TIMESTAMP WHERE id = ?",
+		id,
 	)
 	return err
 }
 
-// GetUsageStats returns usage statistics for a key
-func (s *Store) GetUsageStats(key string) (*APIKey, error) {
-	return s.ValidateKey(key)
-}
-
 // ListKeys returns all API keys (admin)
 func (s *Store) ListKeys() ([]APIKey, error) {
-	rows, err := s.db.Query(
-		"SELECT id, key, agent_id, created_at, last_used, hit_count FROM api_keys ORDER BY created_at DESC",
-	)
+	rows, err := s.db.Query("SELECT " + keyColumns + " FROM " + keyTables + " ORDER BY k.created_at DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -116,27 +501,44 @@ func (s *Store) ListKeys() ([]APIKey, error) {
 
 	var keys []APIKey
 	for rows.Next() {
-		var k APIKey
-		var lastUsed sql.NullTime
-		err := rows.Scan(&k.ID, &k.Key, &k.AgentID, &k.CreatedAt, &lastUsed, &k.HitCount)
+		k, err := scanKey(rows)
 		if err != nil {
 			continue
 		}
-		if lastUsed.Valid {
-			k.LastUsed = lastUsed.Time
-		}
-		keys = append(keys, k)
+		keys = append(keys, *k)
 	}
 
 	return keys, nil
 }
 
+// generateRandomKey returns "pfa_" and 44 hex digits: a 12-digit public
+// prefix followed by 128 secret bits
 func generateRandomKey() string {
-	bytes := make([]byte, 16)
+	bytes := make([]byte, 22)
 	rand.Read(bytes)
 	return "pfa_" + hex.EncodeToString(bytes)
 }
 
+// keyPrefix is the public part of a key. Keys from before hashing are
+// shorter, and keep 80 secret bits after theirs.
+func keyPrefix(key string) string {
+	if len(key) < prefixLen {
+		return key
+	}
+	return key[:prefixLen]
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// DB returns the store's database, so other tables can live in the same
+// file and share its connections instead of opening a second pool
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
 // Close closes the database connection
 func (s *Store) Close() error {
 	return s.db.Close()
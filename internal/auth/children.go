@@ -0,0 +1,74 @@
+package auth
+
+import "fmt"
+
+// Errors returned when issuing child keys
+var (
+	ErrNestedChild  = fmt.Errorf("child keys can't issue keys")
+	ErrWiderScopes  = fmt.Errorf("child key scopes must be within the parent's")
+	ErrHigherLimits = fmt.Errorf("child key limits must be within the parent's")
+)
+
+// CreateChild issues a key for one of a key holder's sub-agents. It is
+// on the parent's plan, may only narrow its scopes and limits, and
+// stops working when the parent does.
+func (s *Store) CreateChild(parent *APIKey, label string, scopes Scopes, overrides *LimitOverrides) (*APIKey, error) {
+	if err := checkChild(parent, scopes, overrides); err != nil {
+		return nil, err
+	}
+
+	key := generateRandomKey()
+	result, err := s.db.Exec(
+		"INSERT INTO api_keys (key_prefix, key_hash, agent_id, scopes, plan, limit_overrides, parent_id, label, org_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		keyPrefix(key), hashKey(key), parent.AgentID, encodeScopes(scopes), parent.Plan, encodeOverrides(overrides), parent.ID, label, parent.OrgID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := result.LastInsertId()
+	child, err := s.GetKey(id)
+	if err != nil {
+		return nil, err
+	}
+	child.Key = key
+	return child, nil
+}
+
+// checkChild returns why a key may not issue a child key with scopes
+// and overrides, or nil
+func checkChild(parent *APIKey, scopes Scopes, overrides *LimitOverrides) error {
+	switch {
+	case parent.ParentID != 0:
+		return ErrNestedChild
+	case !scopes.Within(parent.Scopes):
+		return ErrWiderScopes
+	case !overrides.apply(parent.Limits).Within(parent.Limits):
+		return ErrHigherLimits
+	}
+	return nil
+}
+
+// ListChildren returns the child keys of a key, newest first
+func (s *Store) ListChildren(parentID int64) ([]APIKey, error) {
+	rows, err := s.db.Query("SELECT "+keyColumns+" FROM "+keyTables+" WHERE k.parent_id = ? ORDER BY k.created_at DESC, k.id DESC", parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// SetLabel names a key
+func (s *Store) SetLabel(id int64, label string) error {
+	return s.update(id, "UPDATE api_keys SET label = ? WHERE id = ?", label, id)
+}
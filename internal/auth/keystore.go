@@ -0,0 +1,61 @@
+package auth
+
+import "time"
+
+// KeyStore is where keys, plans, invites and organizations are kept.
+// Store keeps them in SQLite; MemoryStore keeps them in process memory.
+type KeyStore interface {
+	GenerateKey(agentID string, scopes Scopes) (*APIKey, error)
+	ValidateKey(key string) (*APIKey, error)
+	ValidateKeyID(id int64) (*APIKey, error)
+	ValidateSigningKey(prefix string) (*APIKey, error)
+	GetKey(id int64) (*APIKey, error)
+	ListKeys() ([]APIKey, error)
+	SetStatus(id int64, status string) error
+	Revoke(id int64, reason string) error
+	SetExpiry(id int64, expiresAt *time.Time) error
+	SetScopes(id int64, scopes Scopes) error
+	DeleteKey(id int64) error
+	IncrementUsage(id int64) error
+	Migrated() []MigratedKey
+
+	Rotate(id int64, grace time.Duration) (*APIKey, error)
+	CreateChild(parent *APIKey, label string, scopes Scopes, overrides *LimitOverrides) (*APIKey, error)
+	ListChildren(parentID int64) ([]APIKey, error)
+	SetLabel(id int64, label string) error
+	NewSigningSecret(id int64) (string, error)
+	SetRequireSignature(id int64, required bool) error
+
+	ListPlans() ([]Plan, error)
+	SavePlan(p Plan) error
+	SetPlan(id int64, plan string) error
+	SetOverrides(id int64, o *LimitOverrides) error
+
+	InviteOnly() (bool, error)
+	SetInviteOnly(on bool) error
+	AgentIDTaken(agentID string) (bool, error)
+	CreateInvite(note string, maxUses int, expiresAt *time.Time) (*Invite, error)
+	ListInvites() ([]Invite, error)
+	DeleteInvite(id int64) error
+	GenerateInvitedKey(code, agentID string, scopes Scopes) (*APIKey, error)
+
+	CreateOrg(name, plan string) (*Org, error)
+	GetOrg(id int64) (*Org, error)
+	ListOrgs() ([]Org, error)
+	DeleteOrg(id int64) error
+	SetOrgPlan(id int64, plan string) error
+	SetOrgOverrides(id int64, o *LimitOverrides) error
+	AddMember(orgID int64, member, role string) error
+	RemoveMember(orgID int64, member string) error
+	ListMembers(orgID int64) ([]Member, error)
+	SetKeyOrg(id, orgID int64) error
+	ListOrgKeys(orgID int64) ([]APIKey, error)
+	ProvisionKeys(orgID int64, n int, agentPrefix, plan string, scopes Scopes) ([]*APIKey, error)
+
+	Close() error
+}
+
+var (
+	_ KeyStore = (*Store)(nil)
+	_ KeyStore = (*MemoryStore)(nil)
+)
@@ -0,0 +1,822 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keyStores opens each KeyStore implementation empty, so the suite
+// below holds both to the same behavior
+func keyStores(t *testing.T) map[string]func(t *testing.T) KeyStore {
+	return map[string]func(t *testing.T) KeyStore{
+		"sqlite": func(t *testing.T) KeyStore {
+			s, err := NewStore(t.TempDir() + "/keys.db")
+			if err != nil {
+				t.Fatalf("NewStore: %v", err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+		"memory": func(t *testing.T) KeyStore {
+			return NewMemoryStore()
+		},
+	}
+}
+
+func TestKeyStore(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s KeyStore)
+	}{
+		{"key lifecycle", testKeyLifecycle},
+		{"child keys", testChildKeys},
+		{"rotation", testRotation},
+		{"plans", testPlans},
+		{"invites", testInvites},
+		{"agent ids", testAgentIDs},
+		{"organizations", testOrgs},
+	}
+	for name, open := range keyStores(t) {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				tt.run(t, open(t))
+			})
+		}
+	}
+}
+
+func testKeyLifecycle(t *testing.T, s KeyStore) {
+	scopes := Scopes{Endpoints: []string{"price"}, Classes: []string{"crypto"}}
+	key, err := s.GenerateKey("agent", scopes)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if key.Key == "" || key.Plan != PlanFree {
+		t.Fatalf("GenerateKey = %+v, want plaintext key on the free plan", key)
+	}
+
+	got, err := s.ValidateKey(key.Key)
+	if err != nil {
+		t.Fatalf("ValidateKey: %v", err)
+	}
+	if got.ID != key.ID || got.AgentID != "agent" || got.Key != "" {
+		t.Errorf("ValidateKey = %+v, want key %d without plaintext", got, key.ID)
+	}
+	if !got.Scopes.AllowsEndpoint("price") || got.Scopes.AllowsEndpoint("batch") {
+		t.Errorf("scopes = %+v, want only the price endpoint", got.Scopes)
+	}
+	if _, err := s.ValidateKey("pfa_not_a_key"); err != ErrInvalidKey {
+		t.Errorf("ValidateKey(unknown) = %v, want ErrInvalidKey", err)
+	}
+
+	if err := s.SetStatus(key.ID, StatusDisabled); err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+	if _, err := s.ValidateKey(key.Key); err != ErrKeyDisabled {
+		t.Errorf("ValidateKey(disabled) = %v, want ErrKeyDisabled", err)
+	}
+	if err := s.SetStatus(key.ID, StatusActive); err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	if err := s.SetExpiry(key.ID, &past); err != nil {
+		t.Fatalf("SetExpiry: %v", err)
+	}
+	if _, err := s.ValidateKey(key.Key); err != ErrKeyExpired {
+		t.Errorf("ValidateKey(expired) = %v, want ErrKeyExpired", err)
+	}
+	if err := s.SetExpiry(key.ID, nil); err != nil {
+		t.Fatalf("SetExpiry: %v", err)
+	}
+
+	if err := s.Revoke(key.ID, "leaked"); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := s.ValidateKey(key.Key); err != ErrKeyRevoked {
+		t.Errorf("ValidateKey(revoked) = %v, want ErrKeyRevoked", err)
+	}
+	if err := s.SetStatus(key.ID, StatusActive); err != ErrKeyRevoked {
+		t.Errorf("SetStatus(revoked) = %v, want ErrKeyRevoked", err)
+	}
+
+	if err := s.DeleteKey(key.ID); err != nil {
+		t.Fatalf("DeleteKey: %v", err)
+	}
+	if _, err := s.GetKey(key.ID); err != ErrNotFound {
+		t.Errorf("GetKey(deleted) = %v, want ErrNotFound", err)
+	}
+}
+
+func testChildKeys(t *testing.T, s KeyStore) {
+	parent, err := s.GenerateKey("owner", Scopes{Classes: []string{"crypto", "stock"}})
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	parent, err = s.GetKey(parent.ID)
+	if err != nil {
+		t.Fatalf("GetKey: %v", err)
+	}
+
+	for _, scopes := range []Scopes{{Classes: []string{"forex"}}, {Classes: []string{"crypto", "forex"}}, {}} {
+		if _, err := s.CreateChild(parent, "wide", scopes, nil); err != ErrWiderScopes {
+			t.Errorf("CreateChild(%+v) = %v, want ErrWiderScopes", scopes, err)
+		}
+	}
+	burst := parent.Limits.Burst + 1
+	if _, err := s.CreateChild(parent, "fast", parent.Scopes, &LimitOverrides{Burst: &burst}); err != ErrHigherLimits {
+		t.Errorf("CreateChild(higher limits) = %v, want ErrHigherLimits", err)
+	}
+
+	child, err := s.CreateChild(parent, "bot", Scopes{Classes: []string{"crypto"}}, nil)
+	if err != nil {
+		t.Fatalf("CreateChild: %v", err)
+	}
+	if child.AgentID != "owner" || child.ParentID != parent.ID || child.Plan != parent.Plan {
+		t.Errorf("child = %+v, want the parent's agent and plan", child)
+	}
+	if _, err := s.CreateChild(child, "nested", Scopes{}, nil); err != ErrNestedChild {
+		t.Errorf("CreateChild(of child) = %v, want ErrNestedChild", err)
+	}
+
+	got, err := s.ValidateKey(child.Key)
+	if err != nil {
+		t.Fatalf("ValidateKey(child): %v", err)
+	}
+	if got.Parent == nil || got.Parent.ID != parent.ID {
+		t.Errorf("child parent = %+v, want key %d", got.Parent, parent.ID)
+	}
+
+	if err := s.SetLabel(child.ID, "renamed"); err != nil {
+		t.Fatalf("SetLabel: %v", err)
+	}
+	children, err := s.ListChildren(parent.ID)
+	if err != nil {
+		t.Fatalf("ListChildren: %v", err)
+	}
+	if len(children) != 1 || children[0].Label != "renamed" {
+		t.Errorf("ListChildren = %+v, want one child labelled renamed", children)
+	}
+
+	if err := s.SetStatus(parent.ID, StatusDisabled); err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+	if _, err := s.ValidateKey(child.Key); err != ErrKeyDisabled {
+		t.Errorf("ValidateKey(child of disabled) = %v, want ErrKeyDisabled", err)
+	}
+}
+
+func TestScopesWithin(t *testing.T) {
+	parent := Scopes{
+		Endpoints: []string{"price", "batch"},
+		Classes:   []string{"crypto"},
+		Pairs:     []string{"BTC/USDT", "ETH"},
+	}
+	tests := []struct {
+		name   string
+		child  Scopes
+		parent Scopes
+		want   bool
+	}{
+		{"same scopes", parent, parent, true},
+		{"narrower", Scopes{Endpoints: []string{"price"}, Classes: []string{"crypto"}, Pairs: []string{"ETH"}}, parent, true},
+		{"case differs", Scopes{Endpoints: []string{"PRICE"}, Classes: []string{"Crypto"}, Pairs: []string{"btc/usdt"}}, parent, true},
+		{"anything under an unrestricted parent", Scopes{Classes: []string{"forex"}}, Scopes{}, true},
+		{"unrestricted under a restricted parent", Scopes{}, parent, false},
+		{"unrestricted endpoints", Scopes{Classes: []string{"crypto"}, Pairs: []string{"ETH"}}, parent, false},
+		{"extra endpoint", Scopes{Endpoints: []string{"price", "query"}, Classes: []string{"crypto"}, Pairs: []string{"ETH"}}, parent, false},
+		{"extra class", Scopes{Endpoints: []string{"price"}, Classes: []string{"crypto", "stock"}, Pairs: []string{"ETH"}}, parent, false},
+		{"extra pair", Scopes{Endpoints: []string{"price"}, Classes: []string{"crypto"}, Pairs: []string{"SOL"}}, parent, false},
+	}
+	for _, tt := range tests {
+		if got := tt.child.Within(tt.parent); got != tt.want {
+			t.Errorf("%s: Within = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func testRotation(t *testing.T, s KeyStore) {
+	old, err := s.GenerateKey("rotator", Scopes{})
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	parent, _ := s.GetKey(old.ID)
+	child, err := s.CreateChild(parent, "bot", Scopes{}, nil)
+	if err != nil {
+		t.Fatalf("CreateChild: %v", err)
+	}
+
+	successor, err := s.Rotate(old.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if successor.Key == "" || successor.RotatedFrom != old.ID || successor.AgentID != "rotator" {
+		t.Errorf("successor = %+v, want a new key rotated from %d", successor, old.ID)
+	}
+	if successor.UsageKey != old.Prefix {
+		t.Errorf("successor usage key = %q, want %q", successor.UsageKey, old.Prefix)
+	}
+	if _, err := s.Rotate(old.ID, time.Hour); err != ErrAlreadyRotated {
+		t.Errorf("Rotate(again) = %v, want ErrAlreadyRotated", err)
+	}
+
+	for _, key := range []string{old.Key, successor.Key} {
+		if _, err := s.ValidateKey(key); err != nil {
+			t.Errorf("ValidateKey during grace: %v", err)
+		}
+	}
+	got, err := s.ValidateKey(child.Key)
+	if err != nil {
+		t.Fatalf("ValidateKey(child): %v", err)
+	}
+	if got.ParentID != successor.ID {
+		t.Errorf("child parent = %d, want the successor %d", got.ParentID, successor.ID)
+	}
+
+	// The old key's grace ends an hour out; once it has, only the
+	// successor works
+	rotated, err := s.GetKey(old.ID)
+	if err != nil {
+		t.Fatalf("GetKey: %v", err)
+	}
+	if rotated.ExpiresAt == nil || time.Until(*rotated.ExpiresAt) > time.Hour || time.Until(*rotated.ExpiresAt) < 59*time.Minute {
+		t.Errorf("rotated key expires at %v, want in an hour", rotated.ExpiresAt)
+	}
+	past := time.Now().Add(-time.Minute)
+	if err := s.SetExpiry(old.ID, &past); err != nil {
+		t.Fatalf("SetExpiry: %v", err)
+	}
+	if _, err := s.ValidateKey(old.Key); err != ErrKeyExpired {
+		t.Errorf("ValidateKey(after grace) = %v, want ErrKeyExpired", err)
+	}
+	if _, err := s.ValidateKey(successor.Key); err != nil {
+		t.Errorf("ValidateKey(successor after grace): %v", err)
+	}
+
+	// Grace never outlasts the key's own expiry, which the successor keeps
+	expiring, err := s.GenerateKey("expiring", Scopes{})
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	soon := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	if err := s.SetExpiry(expiring.ID, &soon); err != nil {
+		t.Fatalf("SetExpiry: %v", err)
+	}
+	next, err := s.Rotate(expiring.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	rotated, err = s.GetKey(expiring.ID)
+	if err != nil {
+		t.Fatalf("GetKey: %v", err)
+	}
+	if rotated.ExpiresAt == nil || !rotated.ExpiresAt.Equal(soon) {
+		t.Errorf("rotated key expires at %v, want its own expiry %v", rotated.ExpiresAt, soon)
+	}
+	if next.ExpiresAt == nil || !next.ExpiresAt.Equal(soon) {
+		t.Errorf("successor expires at %v, want %v", next.ExpiresAt, soon)
+	}
+}
+
+func testPlans(t *testing.T, s KeyStore) {
+	plans, err := s.ListPlans()
+	if err != nil {
+		t.Fatalf("ListPlans: %v", err)
+	}
+	if len(plans) != len(DefaultPlans) {
+		t.Errorf("ListPlans = %d plans, want the %d defaults", len(plans), len(DefaultPlans))
+	}
+
+	if err := s.SavePlan(Plan{Name: "team", Limits: Limits{PerSecond: 7, Burst: 9}}); err != nil {
+		t.Fatalf("SavePlan: %v", err)
+	}
+	key, err := s.GenerateKey("", Scopes{})
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if err := s.SetPlan(key.ID, "missing"); err != ErrUnknownPlan {
+		t.Errorf("SetPlan(missing) = %v, want ErrUnknownPlan", err)
+	}
+	if err := s.SetPlan(key.ID, "team"); err != nil {
+		t.Fatalf("SetPlan: %v", err)
+	}
+	daily := int64(50)
+	if err := s.SetOverrides(key.ID, &LimitOverrides{Daily: &daily}); err != nil {
+		t.Fatalf("SetOverrides: %v", err)
+	}
+
+	got, err := s.GetKey(key.ID)
+	if err != nil {
+		t.Fatalf("GetKey: %v", err)
+	}
+	want := Limits{PerSecond: 7, Burst: 9, Daily: 50}
+	if got.Plan != "team" || got.Limits != want {
+		t.Errorf("key plan %q limits %+v, want team %+v", got.Plan, got.Limits, want)
+	}
+}
+
+func testInvites(t *testing.T, s KeyStore) {
+	if on, err := s.InviteOnly(); err != nil || on {
+		t.Fatalf("InviteOnly = %v, %v, want off", on, err)
+	}
+	if err := s.SetInviteOnly(true); err != nil {
+		t.Fatalf("SetInviteOnly: %v", err)
+	}
+	if on, _ := s.InviteOnly(); !on {
+		t.Error("InviteOnly = false after turning it on")
+	}
+
+	invite, err := s.CreateInvite("beta", 1, nil)
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	// A key that can't be created leaves the invite's use untaken
+	if _, err := s.GenerateKey("taken", Scopes{}); err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if _, err := s.GenerateInvitedKey(invite.Code, "taken", Scopes{}); err != ErrAgentIDTaken {
+		t.Errorf("GenerateInvitedKey(taken agent id) = %v, want ErrAgentIDTaken", err)
+	}
+	key, err := s.GenerateInvitedKey(invite.Code, "invited", Scopes{})
+	if err != nil {
+		t.Fatalf("GenerateInvitedKey: %v", err)
+	}
+	if _, err := s.ValidateKey(key.Key); err != nil {
+		t.Errorf("ValidateKey(invited): %v", err)
+	}
+	if _, err := s.GenerateInvitedKey(invite.Code, "", Scopes{}); err != ErrInvalidInvite {
+		t.Errorf("GenerateInvitedKey(used up) = %v, want ErrInvalidInvite", err)
+	}
+	if _, err := s.GenerateInvitedKey("nope", "", Scopes{}); err != ErrInvalidInvite {
+		t.Errorf("GenerateInvitedKey(unknown) = %v, want ErrInvalidInvite", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	expired, err := s.CreateInvite("", 5, &past)
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	if _, err := s.GenerateInvitedKey(expired.Code, "", Scopes{}); err != ErrInvalidInvite {
+		t.Errorf("GenerateInvitedKey(expired) = %v, want ErrInvalidInvite", err)
+	}
+
+	invites, err := s.ListInvites()
+	if err != nil {
+		t.Fatalf("ListInvites: %v", err)
+	}
+	if len(invites) != 2 {
+		t.Errorf("ListInvites = %d invites, want 2", len(invites))
+	}
+	if err := s.DeleteInvite(invite.ID); err != nil {
+		t.Fatalf("DeleteInvite: %v", err)
+	}
+}
+
+func testAgentIDs(t *testing.T, s KeyStore) {
+	key, err := s.GenerateKey("Agent-1", Scopes{})
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if taken, _ := s.AgentIDTaken("agent-1"); !taken {
+		t.Error("AgentIDTaken = false for a registered agent_id")
+	}
+	if _, err := s.GenerateKey("agent-1", Scopes{}); err != ErrAgentIDTaken {
+		t.Errorf("GenerateKey(taken) = %v, want ErrAgentIDTaken", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.GenerateKey("", Scopes{}); err != nil {
+			t.Errorf("GenerateKey(no agent_id): %v", err)
+		}
+	}
+
+	parent, _ := s.GetKey(key.ID)
+	if _, err := s.CreateChild(parent, "bot", Scopes{}, nil); err != nil {
+		t.Errorf("CreateChild sharing the agent_id: %v", err)
+	}
+	if _, err := s.Rotate(key.ID, 0); err != nil {
+		t.Errorf("Rotate sharing the agent_id: %v", err)
+	}
+
+	if err := s.Revoke(key.ID, ""); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := s.GenerateKey("agent-1", Scopes{}); err != nil {
+		t.Errorf("GenerateKey(after revoke): %v", err)
+	}
+}
+
+func testOrgs(t *testing.T, s KeyStore) {
+	org, err := s.CreateOrg("acme", PlanPro)
+	if err != nil {
+		t.Fatalf("CreateOrg: %v", err)
+	}
+	if _, err := s.CreateOrg("acme", ""); err != ErrOrgExists {
+		t.Errorf("CreateOrg(duplicate) = %v, want ErrOrgExists", err)
+	}
+	if _, err := s.CreateOrg("nope", "missing"); err != ErrUnknownPlan {
+		t.Errorf("CreateOrg(unknown plan) = %v, want ErrUnknownPlan", err)
+	}
+
+	if err := s.AddMember(org.ID, "a@example.com", RoleOwner); err != nil {
+		t.Fatalf("AddMember: %v", err)
+	}
+	if err := s.AddMember(org.ID, "b@example.com", "boss"); err != ErrInvalidRole {
+		t.Errorf("AddMember(bad role) = %v, want ErrInvalidRole", err)
+	}
+	if err := s.RemoveMember(org.ID, "b@example.com"); err != ErrMemberNotFound {
+		t.Errorf("RemoveMember(missing) = %v, want ErrMemberNotFound", err)
+	}
+	members, err := s.ListMembers(org.ID)
+	if err != nil || len(members) != 1 {
+		t.Fatalf("ListMembers = %v, %v, want one member", members, err)
+	}
+
+	keys, err := s.ProvisionKeys(org.ID, 3, "fleet", "", Scopes{})
+	if err != nil {
+		t.Fatalf("ProvisionKeys: %v", err)
+	}
+	if len(keys) != 3 || keys[0].AgentID != "fleet-1" || keys[2].OrgID != org.ID {
+		t.Fatalf("ProvisionKeys = %+v, want fleet-1 to fleet-3 in the org", keys)
+	}
+	loose, err := s.GenerateKey("", Scopes{})
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if err := s.SetKeyOrg(loose.ID, org.ID); err != nil {
+		t.Fatalf("SetKeyOrg: %v", err)
+	}
+
+	got, err := s.ValidateKey(keys[0].Key)
+	if err != nil {
+		t.Fatalf("ValidateKey: %v", err)
+	}
+	if got.Org == nil || got.Org.ID != org.ID || got.Org.Plan != PlanPro {
+		t.Errorf("key org = %+v, want acme on pro", got.Org)
+	}
+	if _, err := s.ProvisionKeys(org.ID, 5, "fleet", "", Scopes{}); err != ErrAgentIDTaken {
+		t.Errorf("ProvisionKeys(clashing agent ids) = %v, want ErrAgentIDTaken", err)
+	}
+	orgKeys, err := s.ListOrgKeys(org.ID)
+	if err != nil || len(orgKeys) != 4 {
+		t.Errorf("ListOrgKeys = %d keys, %v, want 4", len(orgKeys), err)
+	}
+	if got, _ := s.GetOrg(org.ID); got == nil || got.KeyCount != 4 {
+		t.Errorf("GetOrg = %+v, want 4 keys", got)
+	}
+	if orgs, _ := s.ListOrgs(); len(orgs) != 1 || orgs[0].KeyCount != 4 {
+		t.Errorf("ListOrgs = %+v, want acme with 4 keys", orgs)
+	}
+
+	if err := s.DeleteOrg(org.ID); err != nil {
+		t.Fatalf("DeleteOrg: %v", err)
+	}
+	if _, err := s.GetOrg(org.ID); err != ErrOrgNotFound {
+		t.Errorf("GetOrg(deleted) = %v, want ErrOrgNotFound", err)
+	}
+	if _, err := s.ValidateKey(keys[0].Key); err != nil {
+		t.Errorf("ValidateKey(key of deleted org): %v", err)
+	}
+}
+
+func TestMigrateDuplicateAgentIDs(t *testing.T) {
+	path := t.TempDir() + "/keys.db"
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore: %v", err)
+	}
+	if err := s.Migrate(2); err != nil {
+		t.Fatalf("Migrate(2): %v", err)
+	}
+	var keys []*APIKey
+	for _, agentID := range []string{"bot", "Bot", "bot", "solo"} {
+		key, err := s.GenerateKey(agentID, Scopes{})
+		if err != nil {
+			t.Fatalf("GenerateKey(%q) before the unique index: %v", agentID, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := s.SetStatus(keys[2].ID, StatusDisabled); err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+	s.Close()
+
+	s, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore over duplicate agent_ids: %v", err)
+	}
+	defer s.Close()
+
+	want := map[int64]string{
+		keys[0].ID: fmt.Sprintf("bot#%d", keys[0].ID),
+		keys[1].ID: "Bot",
+		keys[2].ID: fmt.Sprintf("bot#%d", keys[2].ID),
+		keys[3].ID: "solo",
+	}
+	for _, key := range keys {
+		got, err := s.ValidateKey(key.Key)
+		if key.ID == keys[2].ID {
+			got, err = s.GetKey(key.ID)
+		}
+		if err != nil {
+			t.Fatalf("key %d after migrating: %v", key.ID, err)
+		}
+		if got.AgentID != want[key.ID] {
+			t.Errorf("key %d agent_id = %q, want %q", key.ID, got.AgentID, want[key.ID])
+		}
+	}
+	if _, err := s.GenerateKey("bot", Scopes{}); err != ErrAgentIDTaken {
+		t.Errorf("GenerateKey(bot) after migrating = %v, want ErrAgentIDTaken", err)
+	}
+}
+
+func TestSigningSecretsEncrypted(t *testing.T) {
+	s, err := NewStore(t.TempDir() + "/keys.db")
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+	key, err := s.GenerateKey("signer", Scopes{})
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	if _, err := s.NewSigningSecret(key.ID); err != ErrSigningDisabled {
+		t.Errorf("NewSigningSecret without a secret key = %v, want ErrSigningDisabled", err)
+	}
+	if err := s.SetSecretKey("too short"); err == nil {
+		t.Error("SetSecretKey accepted a short key")
+	}
+
+	// A secret stored in the clear before encryption was configured
+	legacy := newSigningSecret()
+	if _, err := s.db.Exec("UPDATE api_keys SET signing_secret = ? WHERE id = ?", legacy, key.ID); err != nil {
+		t.Fatalf("storing a plaintext secret: %v", err)
+	}
+	if _, err := s.ValidateSigningKey(key.Prefix); err != ErrSigningDisabled {
+		t.Errorf("ValidateSigningKey without a secret key = %v, want ErrSigningDisabled", err)
+	}
+
+	const secretKey = "0123456789abcdef0123456789abcdef"
+	if err := s.SetSecretKey(secretKey); err != nil {
+		t.Fatalf("SetSecretKey: %v", err)
+	}
+	var stored string
+	s.db.QueryRow("SELECT signing_secret FROM api_keys WHERE id = ?", key.ID).Scan(&stored)
+	if !strings.HasPrefix(stored, sealedPrefix) || strings.Contains(stored, legacy) {
+		t.Errorf("plaintext secret stored as %q after SetSecretKey, want it encrypted", stored)
+	}
+	got, err := s.ValidateSigningKey(key.Prefix)
+	if err != nil || got.SigningSecret != legacy {
+		t.Errorf("ValidateSigningKey = %v, %v, want the legacy secret", got, err)
+	}
+
+	secret, err := s.NewSigningSecret(key.ID)
+	if err != nil {
+		t.Fatalf("NewSigningSecret: %v", err)
+	}
+	s.db.QueryRow("SELECT signing_secret FROM api_keys WHERE id = ?", key.ID).Scan(&stored)
+	if strings.Contains(stored, secret) {
+		t.Errorf("signing secret stored in the clear: %q", stored)
+	}
+	got, err = s.ValidateSigningKey(key.Prefix)
+	if err != nil {
+		t.Fatalf("ValidateSigningKey: %v", err)
+	}
+	toSign := StringToSign("GET", "/v1/price/BTC", "1700000000", "n1", nil)
+	if !got.VerifySignature(toSign, Sign(secret, toSign)) {
+		t.Error("VerifySignature rejected a signature made with the issued secret")
+	}
+
+	if err := s.SetSecretKey("fedcba9876543210fedcba9876543210"); err != nil {
+		t.Fatalf("SetSecretKey: %v", err)
+	}
+	if _, err := s.ValidateSigningKey(key.Prefix); err == nil {
+		t.Error("ValidateSigningKey decrypted a secret with a different key")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	const secret = "whsec_test"
+	key := &APIKey{SigningSecret: secret}
+	body := []byte(`{"query":"BTC"}`)
+	signed := StringToSign("POST", "/v1/query", "1700000000", "n1", body)
+	signature := Sign(secret, signed)
+
+	tests := []struct {
+		name      string
+		key       *APIKey
+		toSign    string
+		signature string
+		want      bool
+	}{
+		{"signed request", key, signed, signature, true},
+		{"upper case hex", key, signed, strings.ToUpper(signature), true},
+		{"method is lower case", key, StringToSign("post", "/v1/query", "1700000000", "n1", body), signature, true},
+		// A captured signature can't be replayed under a fresh nonce or time
+		{"other nonce", key, StringToSign("POST", "/v1/query", "1700000000", "n2", body), signature, false},
+		{"other timestamp", key, StringToSign("POST", "/v1/query", "1700000060", "n1", body), signature, false},
+		{"other body", key, StringToSign("POST", "/v1/query", "1700000000", "n1", []byte(`{"query":"ETH"}`)), signature, false},
+		{"other path", key, StringToSign("POST", "/v1/query?explain=1", "1700000000", "n1", body), signature, false},
+		{"other secret", key, signed, Sign("whsec_other", signed), false},
+		{"key without a secret", &APIKey{}, signed, signature, false},
+	}
+	for _, tt := range tests {
+		if got := tt.key.VerifySignature(tt.toSign, tt.signature); got != tt.want {
+			t.Errorf("%s: VerifySignature = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	const (
+		newSecret = "new-0123456789abcdef0123456789abcdef"
+		oldSecret = "old-0123456789abcdef0123456789abcdef"
+	)
+	for _, spec := range []string{"k1:short", ":" + newSecret, newSecret} {
+		if _, err := NewTokens(spec, time.Hour); err == nil {
+			t.Errorf("NewTokens(%q) accepted a bad spec", spec)
+		}
+	}
+
+	tokens, err := NewTokens("k2:"+newSecret+", k1:"+oldSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("NewTokens: %v", err)
+	}
+	key := &APIKey{ID: 42, Prefix: "pfa_abcd", Plan: PlanFree}
+	scopes := Scopes{Endpoints: []string{"price"}}
+
+	token, issued := tokens.Issue(key, scopes, 10*time.Minute)
+	claims, err := tokens.Parse(token)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if claims.KeyID() != 42 || claims.KeyPrefix != "pfa_abcd" || claims.Plan != PlanFree || claims.ID != issued.ID ||
+		!claims.Scopes.AllowsEndpoint("price") || claims.Scopes.AllowsEndpoint("batch") {
+		t.Errorf("Parse = %+v, want the claims issued for key 42", claims)
+	}
+	if ttl := claims.ExpiresAt - claims.IssuedAt; ttl != 600 {
+		t.Errorf("token lives %ds, want 600", ttl)
+	}
+	for _, ttl := range []time.Duration{0, 48 * time.Hour} {
+		if _, c := tokens.Issue(key, scopes, ttl); c.ExpiresAt-c.IssuedAt != 3600 {
+			t.Errorf("Issue(ttl %v) lives %ds, want the 3600s cap", ttl, c.ExpiresAt-c.IssuedAt)
+		}
+	}
+	if _, second := tokens.Issue(key, scopes, 0); second.ID == issued.ID {
+		t.Error("Issue reused a token ID")
+	}
+
+	// sign builds a token the way Issue does, with any header and claims
+	sign := func(secret string, header map[string]string, c TokenClaims) string {
+		h, _ := json.Marshal(header)
+		p, _ := json.Marshal(c)
+		unsigned := encodeSegment(h) + "." + encodeSegment(p)
+		return unsigned + "." + encodeSegment(signSegment([]byte(secret), unsigned))
+	}
+	hs256 := func(kid string) map[string]string { return map[string]string{"alg": "HS256", "typ": "JWT", "kid": kid} }
+	expired := *issued
+	expired.IssuedAt, expired.ExpiresAt = time.Now().Add(-2*time.Hour).Unix(), time.Now().Add(-time.Hour).Unix()
+	widened := *issued
+	widened.Scopes = Scopes{}
+	parts := strings.Split(token, ".")
+	payload, _ := json.Marshal(widened)
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"signed with the old signing key", sign(oldSecret, hs256("k1"), *issued), true},
+		{"expired", sign(newSecret, hs256("k2"), expired), false},
+		{"forged with another secret", sign("forged-0123456789abcdef0123456789ab", hs256("k2"), *issued), false},
+		{"unknown kid", sign(newSecret, hs256("k3"), *issued), false},
+		{"alg none", sign(newSecret, map[string]string{"alg": "none", "kid": "k2"}, *issued), false},
+		{"claims swapped under the signature", parts[0] + "." + encodeSegment(payload) + "." + parts[2], false},
+		{"other issuer", sign(newSecret, hs256("k2"), TokenClaims{Issuer: "elsewhere", Subject: "42", ExpiresAt: issued.ExpiresAt}), false},
+		{"not a token", "pfa_abcd", false},
+	}
+	for _, tt := range tests {
+		_, err := tokens.Parse(tt.token)
+		if tt.valid && err != nil {
+			t.Errorf("%s: Parse = %v, want it accepted", tt.name, err)
+		}
+		if !tt.valid && err != ErrInvalidToken {
+			t.Errorf("%s: Parse = %v, want ErrInvalidToken", tt.name, err)
+		}
+	}
+}
+
+// originalSchema is the key table as the first release created it,
+// before keys were hashed and before versioned migrations
+const originalSchema = `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		key TEXT UNIQUE NOT NULL,
+		agent_id TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used DATETIME,
+		hit_count INTEGER DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_key ON api_keys(key);`
+
+func TestMigrateFromOriginalSchema(t *testing.T) {
+	path := t.TempDir() + "/keys.db"
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	if _, err := db.Exec(originalSchema); err != nil {
+		t.Fatalf("creating the original schema: %v", err)
+	}
+	old := []struct {
+		key     string
+		agentID interface{}
+		hits    int64
+	}{
+		{"pfa_00112233445566778899aabbccddeeff", "bot", 7},
+		{"pfa_ffeeddccbbaa99887766554433221100", "bot", 2},
+		{"pfa_0123456789abcdef0123456789abcdef", nil, 0},
+	}
+	for _, k := range old {
+		if _, err := db.Exec("INSERT INTO api_keys (key, agent_id, hit_count) VALUES (?, ?, ?)", k.key, k.agentID, k.hits); err != nil {
+			t.Fatalf("inserting an original key: %v", err)
+		}
+	}
+	db.Close()
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore over the original schema: %v", err)
+	}
+	defer s.Close()
+	if version, err := s.SchemaVersion(); err != nil || version != LatestVersion() {
+		t.Errorf("SchemaVersion = %d, %v, want %d", version, err, LatestVersion())
+	}
+	if plaintext, _ := s.db.Query("SELECT key FROM api_keys"); plaintext != nil {
+		plaintext.Close()
+		t.Error("the plaintext key column is still there")
+	}
+
+	migrated := s.Migrated()
+	if len(migrated) != len(old) {
+		t.Fatalf("Migrated = %+v, want the %d original keys", migrated, len(old))
+	}
+	var ids []int64
+	for i, k := range old {
+		if migrated[i].Key != k.key || migrated[i].Prefix != keyPrefix(k.key) {
+			t.Errorf("Migrated[%d] = %+v, want %s under its prefix", i, migrated[i], k.key)
+		}
+		got, err := s.ValidateKey(k.key)
+		if err != nil {
+			t.Fatalf("ValidateKey(original key %d): %v", i, err)
+		}
+		if got.HitCount != k.hits || got.Plan != PlanFree || got.Status != StatusActive || got.UsageKey != keyPrefix(k.key) {
+			t.Errorf("original key %d = %+v, want %d hits, active on the free plan, counted under its prefix", i, got, k.hits)
+		}
+		ids = append(ids, got.ID)
+	}
+
+	// Of the duplicate agent_ids only the newest key keeps its own
+	first, _ := s.GetKey(ids[0])
+	second, _ := s.GetKey(ids[1])
+	if first.AgentID != fmt.Sprintf("bot#%d", ids[0]) || second.AgentID != "bot" {
+		t.Errorf("agent_ids = %q, %q, want the older one suffixed", first.AgentID, second.AgentID)
+	}
+
+	// Everything added since works on the upgraded database
+	parent, _ := s.GetKey(ids[1])
+	if _, err := s.CreateChild(parent, "bot", Scopes{}, nil); err != nil {
+		t.Errorf("CreateChild: %v", err)
+	}
+	if _, err := s.Rotate(ids[2], time.Hour); err != nil {
+		t.Errorf("Rotate: %v", err)
+	}
+	org, err := s.CreateOrg("acme", "")
+	if err != nil {
+		t.Fatalf("CreateOrg: %v", err)
+	}
+	if err := s.SetKeyOrg(ids[0], org.ID); err != nil {
+		t.Errorf("SetKeyOrg: %v", err)
+	}
+	if _, err := s.CreateInvite("beta", 1, nil); err != nil {
+		t.Errorf("CreateInvite: %v", err)
+	}
+	if _, err := s.GenerateKey("bot", Scopes{}); err != ErrAgentIDTaken {
+		t.Errorf("GenerateKey(bot) = %v, want ErrAgentIDTaken", err)
+	}
+
+	// A second boot has nothing left to migrate
+	s.Close()
+	s, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore again: %v", err)
+	}
+	defer s.Close()
+	if migrated := s.Migrated(); len(migrated) != 0 {
+		t.Errorf("Migrated on the second boot = %+v, want none", migrated)
+	}
+}
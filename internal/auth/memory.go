@@ -0,0 +1,758 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps keys, plans, invites and organizations in process
+// memory. Nothing survives a restart and replicas don't share it, so it
+// suits development and tests.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	keys     map[int64]*APIKey
+	hashes   map[string]int64 // key hash to ID
+	prefixes map[string]int64 // key prefix to ID
+	lastKey  int64
+
+	plans      map[string]Limits
+	inviteOnly bool
+
+	invites    map[int64]*Invite
+	inviteHash map[int64]string
+	lastInvite int64
+
+	orgs    map[int64]*Org
+	members map[int64]map[string]Member
+	lastOrg int64
+}
+
+// NewMemoryStore creates an empty in-memory store with the default plans
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		keys:       make(map[int64]*APIKey),
+		hashes:     make(map[string]int64),
+		prefixes:   make(map[string]int64),
+		plans:      make(map[string]Limits),
+		invites:    make(map[int64]*Invite),
+		inviteHash: make(map[int64]string),
+		orgs:       make(map[int64]*Org),
+		members:    make(map[int64]map[string]Member),
+	}
+	for _, p := range DefaultPlans {
+		s.plans[p.Name] = p.Limits
+	}
+	return s
+}
+
+// insert stores a new key generated as key and returns it as read back
+func (s *MemoryStore) insert(k *APIKey, key string) *APIKey {
+	s.lastKey++
+	k.ID = s.lastKey
+	k.Prefix = keyPrefix(key)
+	k.CreatedAt = time.Now().UTC()
+	if k.Status == "" {
+		k.Status = StatusActive
+	}
+	if k.UsageKey == "" {
+		k.UsageKey = k.Prefix
+	}
+	s.keys[k.ID] = k
+	s.hashes[hashKey(key)] = k.ID
+	s.prefixes[k.Prefix] = k.ID
+
+	view := s.view(k)
+	view.Key = key
+	return view
+}
+
+// view returns a copy of a stored key with its effective limits
+func (s *MemoryStore) view(k *APIKey) *APIKey {
+	v := *k
+	v.Limits = v.Overrides.apply(s.planLimits(v.Plan))
+	return &v
+}
+
+// planLimits returns a plan's limits. Like a key row joined to a plan
+// that was removed, an unknown plan gets the free plan's.
+func (s *MemoryStore) planLimits(plan string) Limits {
+	if limits, ok := s.plans[plan]; ok {
+		return limits
+	}
+	return DefaultPlans[0].Limits
+}
+
+// orgView returns a copy of a stored organization with its effective
+// limits
+func (s *MemoryStore) orgView(o *Org) *Org {
+	v := *o
+	var limits Limits
+	if v.Plan != "" {
+		limits = s.planLimits(v.Plan)
+	}
+	v.Limits = v.Overrides.apply(limits)
+	return &v
+}
+
+// orgKeyCounts returns how many keys each organization owns
+func (s *MemoryStore) orgKeyCounts() map[int64]int {
+	counts := make(map[int64]int)
+	for _, k := range s.keys {
+		if k.OrgID != 0 {
+			counts[k.OrgID]++
+		}
+	}
+	return counts
+}
+
+// sortedKeys returns the keys matching a filter, newest first
+func (s *MemoryStore) sortedKeys(match func(*APIKey) bool) []APIKey {
+	var keys []APIKey
+	for _, k := range s.keys {
+		if match(k) {
+			keys = append(keys, *s.view(k))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.After(keys[j].CreatedAt)
+		}
+		return keys[i].ID > keys[j].ID
+	})
+	return keys
+}
+
+// change applies a change to a stored key
+func (s *MemoryStore) change(id int64, apply func(k *APIKey) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	return apply(k)
+}
+
+// GenerateKey creates a new API key limited to scopes
+func (s *MemoryStore) GenerateKey(agentID string, scopes Scopes) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.agentIDHeld(agentID) {
+		return nil, ErrAgentIDTaken
+	}
+	return s.insert(&APIKey{AgentID: agentID, Scopes: scopes, Plan: PlanFree}, generateRandomKey()), nil
+}
+
+// agentIDHeld reports whether a live root key holds agentID, as the
+// unique index does for Store. The caller holds s.mu.
+func (s *MemoryStore) agentIDHeld(agentID string) bool {
+	if agentID == "" {
+		return false
+	}
+	for _, k := range s.keys {
+		if k.ParentID == 0 && k.RotatedFrom == 0 && k.Status != StatusRevoked && strings.EqualFold(k.AgentID, agentID) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateKey looks up an API key and checks it like Store.ValidateKey
+func (s *MemoryStore) ValidateKey(key string) (*APIKey, error) {
+	s.mu.Lock()
+	id, ok := s.hashes[hashKey(key)]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	return s.ValidateKeyID(id)
+}
+
+// ValidateKeyID looks up a key by ID and checks it like ValidateKey
+func (s *MemoryStore) ValidateKeyID(id int64) (*APIKey, error) {
+	k, err := s.GetKey(id)
+	if err == ErrNotFound {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	return checkKey(s, k)
+}
+
+// ValidateSigningKey looks up the key a signed request names by prefix
+func (s *MemoryStore) ValidateSigningKey(prefix string) (*APIKey, error) {
+	s.mu.Lock()
+	id, ok := s.prefixes[prefix]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	k, err := s.ValidateKeyID(id)
+	if err != nil {
+		return nil, err
+	}
+	if k.SigningSecret == "" {
+		return nil, ErrInvalidKey
+	}
+	return k, nil
+}
+
+// GetKey returns a key by ID
+func (s *MemoryStore) GetKey(id int64) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s.view(k), nil
+}
+
+// ListKeys returns all API keys, newest first
+func (s *MemoryStore) ListKeys() ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedKeys(func(*APIKey) bool { return true }), nil
+}
+
+// SetStatus disables or re-enables a key. Revoked keys stay revoked.
+func (s *MemoryStore) SetStatus(id int64, status string) error {
+	if status != StatusActive && status != StatusDisabled {
+		return fmt.Errorf("invalid status %q", status)
+	}
+	return s.change(id, func(k *APIKey) error {
+		if k.Status == StatusRevoked {
+			return ErrKeyRevoked
+		}
+		k.Status = status
+		return nil
+	})
+}
+
+// Revoke permanently stops a key from being used
+func (s *MemoryStore) Revoke(id int64, reason string) error {
+	return s.change(id, func(k *APIKey) error {
+		if k.Status == StatusRevoked {
+			return ErrKeyRevoked
+		}
+		now := time.Now().UTC()
+		k.Status, k.RevokedAt, k.RevokedReason = StatusRevoked, &now, reason
+		return nil
+	})
+}
+
+// SetExpiry sets when a key stops working; nil means never
+func (s *MemoryStore) SetExpiry(id int64, expiresAt *time.Time) error {
+	return s.change(id, func(k *APIKey) error {
+		k.ExpiresAt = nil
+		if expiresAt != nil {
+			at := expiresAt.UTC()
+			k.ExpiresAt = &at
+		}
+		return nil
+	})
+}
+
+// SetScopes replaces a key's scopes
+func (s *MemoryStore) SetScopes(id int64, scopes Scopes) error {
+	return s.change(id, func(k *APIKey) error {
+		k.Scopes = scopes
+		return nil
+	})
+}
+
+// DeleteKey removes a key
+func (s *MemoryStore) DeleteKey(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.prefixes, k.Prefix)
+	for hash, hid := range s.hashes {
+		if hid == id {
+			delete(s.hashes, hash)
+		}
+	}
+	delete(s.keys, id)
+	return nil
+}
+
+// IncrementUsage updates the hit count and last used time
+func (s *MemoryStore) IncrementUsage(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.keys[id]; ok {
+		k.HitCount++
+		k.LastUsed = time.Now().UTC()
+	}
+	return nil
+}
+
+// Migrated is always empty; there are no plaintext keys to hash
+func (s *MemoryStore) Migrated() []MigratedKey {
+	return nil
+}
+
+// Rotate issues a successor to a key like Store.Rotate
+func (s *MemoryStore) Rotate(id int64, grace time.Duration) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.keys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	now := time.Now().UTC()
+	sunset, err := rotationSunset(old, now, grace)
+	if err != nil {
+		return nil, err
+	}
+
+	successor := s.insert(&APIKey{
+		AgentID:          old.AgentID,
+		Status:           old.Status,
+		ExpiresAt:        old.ExpiresAt,
+		Scopes:           old.Scopes,
+		Plan:             old.Plan,
+		Overrides:        old.Overrides,
+		UsageKey:         old.UsageKey,
+		RotatedFrom:      old.ID,
+		ParentID:         old.ParentID,
+		Label:            old.Label,
+		SigningSecret:    old.SigningSecret,
+		RequireSignature: old.RequireSignature,
+		OrgID:            old.OrgID,
+	}, generateRandomKey())
+	old.SuccessorID, old.RotatedAt, old.ExpiresAt = successor.ID, &now, &sunset
+	for _, k := range s.keys {
+		if k.ParentID == id {
+			k.ParentID = successor.ID
+		}
+	}
+	return successor, nil
+}
+
+// CreateChild issues a child key like Store.CreateChild
+func (s *MemoryStore) CreateChild(parent *APIKey, label string, scopes Scopes, overrides *LimitOverrides) (*APIKey, error) {
+	if err := checkChild(parent, scopes, overrides); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.insert(&APIKey{
+		AgentID:   parent.AgentID,
+		Scopes:    scopes,
+		Plan:      parent.Plan,
+		Overrides: overrides,
+		ParentID:  parent.ID,
+		Label:     label,
+		OrgID:     parent.OrgID,
+	}, generateRandomKey()), nil
+}
+
+// ListChildren returns the child keys of a key, newest first
+func (s *MemoryStore) ListChildren(parentID int64) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedKeys(func(k *APIKey) bool { return k.ParentID == parentID }), nil
+}
+
+// SetLabel names a key
+func (s *MemoryStore) SetLabel(id int64, label string) error {
+	return s.change(id, func(k *APIKey) error {
+		k.Label = label
+		return nil
+	})
+}
+
+// NewSigningSecret issues a signing secret for a key
+func (s *MemoryStore) NewSigningSecret(id int64) (string, error) {
+	secret := newSigningSecret()
+	err := s.change(id, func(k *APIKey) error {
+		if k.Status == StatusRevoked {
+			return ErrKeyRevoked
+		}
+		k.SigningSecret = secret
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// SetRequireSignature makes a key refuse, or accept again, unsigned
+// requests
+func (s *MemoryStore) SetRequireSignature(id int64, required bool) error {
+	return s.change(id, func(k *APIKey) error {
+		if required && k.SigningSecret == "" {
+			return ErrNoSigningSecret
+		}
+		k.RequireSignature = required
+		return nil
+	})
+}
+
+// ListPlans returns all plans
+func (s *MemoryStore) ListPlans() ([]Plan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plans := make([]Plan, 0, len(s.plans))
+	for name, limits := range s.plans {
+		plans = append(plans, Plan{Name: name, Limits: limits})
+	}
+	sort.Slice(plans, func(i, j int) bool { return plans[i].Name < plans[j].Name })
+	return plans, nil
+}
+
+// SavePlan creates or updates a plan
+func (s *MemoryStore) SavePlan(p Plan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.plans[p.Name] = p.Limits
+	return nil
+}
+
+// SetPlan moves a key, and its child keys, to another plan
+func (s *MemoryStore) SetPlan(id int64, plan string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.plans[plan]; !ok {
+		return ErrUnknownPlan
+	}
+	if _, ok := s.keys[id]; !ok {
+		return ErrNotFound
+	}
+	for _, k := range s.keys {
+		if k.ID == id || k.ParentID == id {
+			k.Plan = plan
+		}
+	}
+	return nil
+}
+
+// SetOverrides replaces a key's limit overrides; nil clears them
+func (s *MemoryStore) SetOverrides(id int64, o *LimitOverrides) error {
+	return s.change(id, func(k *APIKey) error {
+		k.Overrides = o
+		return nil
+	})
+}
+
+// InviteOnly reports whether registering needs an invite code
+func (s *MemoryStore) InviteOnly() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inviteOnly, nil
+}
+
+// SetInviteOnly opens or closes registration to everyone
+func (s *MemoryStore) SetInviteOnly(on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inviteOnly = on
+	return nil
+}
+
+// AgentIDTaken reports whether a key that isn't revoked is registered
+// to an agent_id, ignoring case
+func (s *MemoryStore) AgentIDTaken(agentID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.keys {
+		if k.Status != StatusRevoked && strings.EqualFold(k.AgentID, agentID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CreateInvite issues an invite code usable maxUses times
+func (s *MemoryStore) CreateInvite(note string, maxUses int, expiresAt *time.Time) (*Invite, error) {
+	if maxUses < 1 {
+		maxUses = 1
+	}
+	code := newInviteCode()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastInvite++
+	inv := &Invite{
+		ID:        s.lastInvite,
+		Prefix:    keyPrefix(code),
+		Note:      note,
+		MaxUses:   maxUses,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now().UTC(),
+	}
+	s.invites[inv.ID] = inv
+	s.inviteHash[inv.ID] = hashKey(code)
+
+	created := *inv
+	created.Code = code
+	return &created, nil
+}
+
+// ListInvites returns all invite codes, newest first
+func (s *MemoryStore) ListInvites() ([]Invite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var invites []Invite
+	for _, inv := range s.invites {
+		invites = append(invites, *inv)
+	}
+	sort.Slice(invites, func(i, j int) bool { return invites[i].ID > invites[j].ID })
+	return invites, nil
+}
+
+// DeleteInvite withdraws an invite code
+func (s *MemoryStore) DeleteInvite(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.invites[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.invites, id)
+	delete(s.inviteHash, id)
+	return nil
+}
+
+// GenerateInvitedKey takes one use of an invite code and creates a key
+// like Store.GenerateInvitedKey
+func (s *MemoryStore) GenerateInvitedKey(code, agentID string, scopes Scopes) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := hashKey(code)
+	for id, h := range s.inviteHash {
+		inv := s.invites[id]
+		if h != hash || inv.Uses >= inv.MaxUses || (inv.ExpiresAt != nil && !time.Now().Before(*inv.ExpiresAt)) {
+			continue
+		}
+		if s.agentIDHeld(agentID) {
+			return nil, ErrAgentIDTaken
+		}
+		inv.Uses++
+		return s.insert(&APIKey{AgentID: agentID, Scopes: scopes, Plan: PlanFree}, generateRandomKey()), nil
+	}
+	return nil, ErrInvalidInvite
+}
+
+// CreateOrg creates an organization, optionally on a plan
+func (s *MemoryStore) CreateOrg(name, plan string) (*Org, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkPlan(plan); err != nil {
+		return nil, err
+	}
+	for _, o := range s.orgs {
+		if o.Name == name {
+			return nil, ErrOrgExists
+		}
+	}
+	s.lastOrg++
+	org := &Org{ID: s.lastOrg, Name: name, Plan: plan, CreatedAt: time.Now().UTC()}
+	s.orgs[org.ID] = org
+	return s.orgView(org), nil
+}
+
+// GetOrg returns an organization by ID with its key count
+func (s *MemoryStore) GetOrg(id int64) (*Org, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orgs[id]
+	if !ok {
+		return nil, ErrOrgNotFound
+	}
+	v := s.orgView(o)
+	v.KeyCount = s.orgKeyCounts()[id]
+	return v, nil
+}
+
+// lookupOrg returns an organization by ID without counting its keys
+func (s *MemoryStore) lookupOrg(id int64) (*Org, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orgs[id]
+	if !ok {
+		return nil, ErrOrgNotFound
+	}
+	return s.orgView(o), nil
+}
+
+// ListOrgs returns all organizations with their key counts
+func (s *MemoryStore) ListOrgs() ([]Org, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := s.orgKeyCounts()
+	var orgs []Org
+	for _, o := range s.orgs {
+		v := s.orgView(o)
+		v.KeyCount = counts[o.ID]
+		orgs = append(orgs, *v)
+	}
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].Name < orgs[j].Name })
+	return orgs, nil
+}
+
+// DeleteOrg removes an organization and its members
+func (s *MemoryStore) DeleteOrg(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orgs[id]; !ok {
+		return ErrOrgNotFound
+	}
+	delete(s.orgs, id)
+	delete(s.members, id)
+	for _, k := range s.keys {
+		if k.OrgID == id {
+			k.OrgID = 0
+		}
+	}
+	return nil
+}
+
+// SetOrgPlan sets the plan whose limits an organization's keys share
+func (s *MemoryStore) SetOrgPlan(id int64, plan string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkPlan(plan); err != nil {
+		return err
+	}
+	o, ok := s.orgs[id]
+	if !ok {
+		return ErrOrgNotFound
+	}
+	o.Plan = plan
+	return nil
+}
+
+// SetOrgOverrides replaces an organization's limit overrides
+func (s *MemoryStore) SetOrgOverrides(id int64, overrides *LimitOverrides) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orgs[id]
+	if !ok {
+		return ErrOrgNotFound
+	}
+	o.Overrides = overrides
+	return nil
+}
+
+// AddMember adds someone to an organization, or changes their role
+func (s *MemoryStore) AddMember(orgID int64, member, role string) error {
+	if role != RoleOwner && role != RoleAdmin && role != RoleMember {
+		return ErrInvalidRole
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orgs[orgID]; !ok {
+		return ErrOrgNotFound
+	}
+	if s.members[orgID] == nil {
+		s.members[orgID] = make(map[string]Member)
+	}
+	m, ok := s.members[orgID][member]
+	if !ok {
+		m = Member{Member: member, AddedAt: time.Now().UTC()}
+	}
+	m.Role = role
+	s.members[orgID][member] = m
+	return nil
+}
+
+// RemoveMember takes someone out of an organization
+func (s *MemoryStore) RemoveMember(orgID int64, member string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.members[orgID][member]; !ok {
+		return ErrMemberNotFound
+	}
+	delete(s.members[orgID], member)
+	return nil
+}
+
+// ListMembers returns an organization's members
+func (s *MemoryStore) ListMembers(orgID int64) ([]Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var members []Member
+	for _, m := range s.members[orgID] {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Member < members[j].Member })
+	return members, nil
+}
+
+// SetKeyOrg moves a key and its child keys to an organization
+func (s *MemoryStore) SetKeyOrg(id, orgID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orgs[orgID]; orgID != 0 && !ok {
+		return ErrOrgNotFound
+	}
+	if _, ok := s.keys[id]; !ok {
+		return ErrNotFound
+	}
+	for _, k := range s.keys {
+		if k.ID == id || k.ParentID == id {
+			k.OrgID = orgID
+		}
+	}
+	return nil
+}
+
+// ListOrgKeys returns the keys an organization owns, newest first
+func (s *MemoryStore) ListOrgKeys(orgID int64) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedKeys(func(k *APIKey) bool { return k.OrgID == orgID }), nil
+}
+
+// ProvisionKeys issues n keys owned by an organization at once
+func (s *MemoryStore) ProvisionKeys(orgID int64, n int, agentPrefix, plan string, scopes Scopes) ([]*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orgs[orgID]; !ok {
+		return nil, ErrOrgNotFound
+	}
+	if plan == "" {
+		plan = PlanFree
+	}
+	if err := s.checkPlan(plan); err != nil {
+		return nil, err
+	}
+
+	agentIDs := make([]string, n)
+	if agentPrefix != "" {
+		for i := range agentIDs {
+			agentIDs[i] = fmt.Sprintf("%s-%d", agentPrefix, i+1)
+			if s.agentIDHeld(agentIDs[i]) {
+				return nil, ErrAgentIDTaken
+			}
+		}
+	}
+
+	keys := make([]*APIKey, 0, n)
+	for _, agentID := range agentIDs {
+		keys = append(keys, s.insert(&APIKey{AgentID: agentID, Scopes: scopes, Plan: plan, OrgID: orgID}, generateRandomKey()))
+	}
+	return keys, nil
+}
+
+// checkPlan returns ErrUnknownPlan unless plan exists or is empty
+func (s *MemoryStore) checkPlan(plan string) error {
+	if _, ok := s.plans[plan]; plan != "" && !ok {
+		return ErrUnknownPlan
+	}
+	return nil
+}
+
+// Close does nothing; the store lives as long as the process
+func (s *MemoryStore) Close() error {
+	return nil
+}
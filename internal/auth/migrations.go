@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// The SQLite schema is changed by numbered migrations, each applied in
+// its own transaction and recorded in schema_migrations. New tables and
+// columns get a new migration; applied ones are never edited.
+
+// Migration is one versioned schema change and its undo
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(s *Store, tx *sql.Tx) error
+	Down    func(s *Store, tx *sql.Tx) error
+}
+
+// MigrationStatus is a migration and when it was applied, if it was
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+const migrationsTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+// migrations are in version order
+var migrations = []Migration{
+	{Version: 1, Name: "baseline", Up: baselineUp, Down: execSQL(`
+		DROP TABLE IF EXISTS org_members;
+		DROP TABLE IF EXISTS orgs;
+		DROP TABLE IF EXISTS invites;
+		DROP TABLE IF EXISTS settings;
+		DROP TABLE IF EXISTS plans;
+		DROP TABLE IF EXISTS api_keys`)},
+	{Version: 2, Name: "key lookup indexes", Up: execSQL(`
+		CREATE INDEX IF NOT EXISTS idx_api_keys_parent_id ON api_keys (parent_id);
+		CREATE INDEX IF NOT EXISTS idx_api_keys_org_id ON api_keys (org_id);
+		CREATE INDEX IF NOT EXISTS idx_api_keys_agent_id ON api_keys (agent_id COLLATE NOCASE)`),
+		Down: execSQL(`
+		DROP INDEX IF EXISTS idx_api_keys_parent_id;
+		DROP INDEX IF EXISTS idx_api_keys_org_id;
+		DROP INDEX IF EXISTS idx_api_keys_agent_id`)},
+	{Version: 3, Name: "unique agent ids", Up: uniqueAgentIDsUp, Down: execSQL(`
+		DROP INDEX IF EXISTS idx_api_keys_agent_id_unique`)},
+}
+
+// LatestVersion is the version of the newest migration
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// baselineUp brings any database from before versioned migrations to
+// the version 1 schema: it creates missing tables, hashes plaintext keys
+// and adds the columns added since the first release
+func baselineUp(s *Store, tx *sql.Tx) error {
+	if _, err := tx.Exec(fmt.Sprintf(keysTable, "api_keys")); err != nil {
+		return err
+	}
+	if err := s.migratePlaintext(tx); err != nil {
+		return fmt.Errorf("failed to hash stored keys: %w", err)
+	}
+	for _, col := range addedColumns {
+		exists, err := hasColumn(tx, "api_keys", col.name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE api_keys ADD COLUMN %s %s", col.name, col.def)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := seedPlans(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(registrationTables); err != nil {
+		return err
+	}
+	_, err := tx.Exec(orgTables)
+	return err
+}
+
+// uniqueAgentIDsUp lets one live root key hold an agent_id, so two
+// registrations racing for it can't both succeed. Child keys and
+// rotation successors share their origin's agent_id and are left out.
+// Registrations used to reuse agent_ids freely, so where several live
+// keys share one the newest active key keeps it and the others get
+// their id appended after a '#', which no registration can choose.
+// Those keys keep working; only their label changes.
+func uniqueAgentIDsUp(_ *Store, tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		UPDATE api_keys SET agent_id = agent_id || '#' || id
+		WHERE ` + rootAgentKey + ` AND id != (
+			SELECT k.id FROM api_keys k
+			WHERE k.agent_id = api_keys.agent_id COLLATE NOCASE AND ` + rootAgentKey + `
+			ORDER BY k.status = 'active' DESC, k.id DESC LIMIT 1)`)
+	if err != nil {
+		return fmt.Errorf("failed to rename duplicate agent_ids: %w", err)
+	}
+	_, err = tx.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_agent_id_unique
+		ON api_keys (agent_id COLLATE NOCASE) WHERE ` + rootAgentKey)
+	return err
+}
+
+// execSQL is a migration step that runs statements
+func execSQL(statements string) func(*Store, *sql.Tx) error {
+	return func(_ *Store, tx *sql.Tx) error {
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
+
+// SchemaVersion returns the version of the newest applied migration, or
+// 0 for an empty database
+func (s *Store) SchemaVersion() (int, error) {
+	var version sql.NullInt64
+	err := s.db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	return int(version.Int64), err
+}
+
+// Migrations lists all migrations and which are applied
+func (s *Store) Migrations() ([]MigrationStatus, error) {
+	applied := make(map[int]time.Time)
+	rows, err := s.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		status[i] = MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			status[i].AppliedAt = &at
+		}
+	}
+	return status, nil
+}
+
+// Migrate applies or undoes migrations until the schema is at version
+func (s *Store) Migrate(version int) error {
+	if version < 0 || version > LatestVersion() {
+		return fmt.Errorf("no migration %d; the latest is %d", version, LatestVersion())
+	}
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > LatestVersion() {
+		return fmt.Errorf("database schema version %d is newer than this build's %d", current, LatestVersion())
+	}
+
+	for _, m := range migrations {
+		if m.Version > current && m.Version <= version {
+			if err := s.runMigration(m.Up, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+			}
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= current && m.Version > version {
+			if err := s.runMigration(m.Down, "DELETE FROM schema_migrations WHERE version = ?", m.Version); err != nil {
+				return fmt.Errorf("undoing migration %d (%s): %w", m.Version, m.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runMigration runs one step and records it in the same transaction
+func (s *Store) runMigration(step func(*Store, *sql.Tx) error, record string, args ...interface{}) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := step(s, tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,351 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Member roles in an organization
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// Org is an organization that owns keys. Its limits are pooled: every
+// request by one of its keys counts toward them, on top of the key's own.
+type Org struct {
+	ID        int64           `json:"id"`
+	Name      string          `json:"name"`
+	Plan      string          `json:"plan,omitempty"` // none means no pooled limits
+	Overrides *LimitOverrides `json:"overrides,omitempty"`
+	Limits    Limits          `json:"limits"`
+	KeyCount  int             `json:"key_count"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// UsageKey is the pool its members' requests are counted toward
+func (o *Org) UsageKey() string {
+	return "org:" + strconv.FormatInt(o.ID, 10)
+}
+
+// Member is someone belonging to an organization
+type Member struct {
+	Member  string    `json:"member"` // e-mail or other identifier
+	Role    string    `json:"role"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// Errors returned by organization changes
+var (
+	ErrOrgNotFound    = fmt.Errorf("organization not found")
+	ErrOrgExists      = fmt.Errorf("organization name is already taken")
+	ErrInvalidRole    = fmt.Errorf("role must be owner, admin or member")
+	ErrMemberNotFound = fmt.Errorf("member not found")
+)
+
+const orgTables = `
+	CREATE TABLE IF NOT EXISTS orgs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL,
+		plan TEXT NOT NULL DEFAULT '',
+		limit_overrides TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS org_members (
+		org_id INTEGER NOT NULL,
+		member TEXT NOT NULL,
+		role TEXT NOT NULL DEFAULT 'member',
+		added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (org_id, member)
+	)`
+
+const orgColumns = `o.id, o.name, o.plan, o.limit_overrides, p.per_second, p.burst, p.daily, p.monthly, o.created_at`
+
+func scanOrg(row interface{ Scan(...interface{}) error }) (*Org, error) {
+	var o Org
+	var overrides string
+	var perSecond, burst, daily, monthly sql.NullInt64
+	if err := row.Scan(&o.ID, &o.Name, &o.Plan, &overrides, &perSecond, &burst, &daily, &monthly, &o.CreatedAt); err != nil {
+		return nil, err
+	}
+	var limits Limits
+	if o.Plan != "" {
+		limits = planLimits(perSecond, burst, daily, monthly)
+	}
+	o.Overrides = decodeOverrides(overrides)
+	o.Limits = o.Overrides.apply(limits)
+	return &o, nil
+}
+
+// CreateOrg creates an organization, optionally on a plan
+func (s *Store) CreateOrg(name, plan string) (*Org, error) {
+	if err := s.checkPlan(plan); err != nil {
+		return nil, err
+	}
+	var taken bool
+	if err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM orgs WHERE name = ?)", name).Scan(&taken); err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, ErrOrgExists
+	}
+	result, err := s.db.Exec("INSERT INTO orgs (name, plan) VALUES (?, ?)", name, plan)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return s.GetOrg(id)
+}
+
+// GetOrg returns an organization by ID with its key count
+func (s *Store) GetOrg(id int64) (*Org, error) {
+	org, err := s.lookupOrg(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM api_keys WHERE org_id = ?", id).Scan(&org.KeyCount); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// lookupOrg returns an organization by ID without counting its keys, as
+// every request by one of them does
+func (s *Store) lookupOrg(id int64) (*Org, error) {
+	org, err := scanOrg(s.db.QueryRow("SELECT "+orgColumns+" FROM orgs o LEFT JOIN plans p ON p.name = o.plan WHERE o.id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrOrgNotFound
+	}
+	return org, err
+}
+
+// ListOrgs returns all organizations with their key counts
+func (s *Store) ListOrgs() ([]Org, error) {
+	counts, err := s.orgKeyCounts()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query("SELECT " + orgColumns + " FROM orgs o LEFT JOIN plans p ON p.name = o.plan ORDER BY o.name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orgs []Org
+	for rows.Next() {
+		o, err := scanOrg(rows)
+		if err != nil {
+			return nil, err
+		}
+		o.KeyCount = counts[o.ID]
+		orgs = append(orgs, *o)
+	}
+	return orgs, rows.Err()
+}
+
+// orgKeyCounts returns how many keys each organization owns
+func (s *Store) orgKeyCounts() (map[int64]int, error) {
+	rows, err := s.db.Query("SELECT org_id, COUNT(*) FROM api_keys WHERE org_id != 0 GROUP BY org_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int64]int)
+	for rows.Next() {
+		var id int64
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	return counts, rows.Err()
+}
+
+// DeleteOrg removes an organization and its members. Its keys keep
+// working without pooled limits.
+func (s *Store) DeleteOrg(id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM orgs WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrOrgNotFound
+	}
+	if _, err := tx.Exec("DELETE FROM org_members WHERE org_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE api_keys SET org_id = 0 WHERE org_id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetOrgPlan sets the plan whose limits an organization's keys share;
+// an empty plan removes the pooled limits
+func (s *Store) SetOrgPlan(id int64, plan string) error {
+	if err := s.checkPlan(plan); err != nil {
+		return err
+	}
+	return s.updateOrg(id, "UPDATE orgs SET plan = ? WHERE id = ?", plan, id)
+}
+
+// SetOrgOverrides replaces an organization's limit overrides; nil
+// clears them
+func (s *Store) SetOrgOverrides(id int64, o *LimitOverrides) error {
+	return s.updateOrg(id, "UPDATE orgs SET limit_overrides = ? WHERE id = ?", encodeOverrides(o), id)
+}
+
+// AddMember adds someone to an organization, or changes their role
+func (s *Store) AddMember(orgID int64, member, role string) error {
+	if role != RoleOwner && role != RoleAdmin && role != RoleMember {
+		return ErrInvalidRole
+	}
+	if _, err := s.lookupOrg(orgID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO org_members (org_id, member, role) VALUES (?, ?, ?)
+		ON CONFLICT(org_id, member) DO UPDATE SET role = excluded.role`,
+		orgID, member, role,
+	)
+	return err
+}
+
+// RemoveMember takes someone out of an organization
+func (s *Store) RemoveMember(orgID int64, member string) error {
+	result, err := s.db.Exec("DELETE FROM org_members WHERE org_id = ? AND member = ?", orgID, member)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrMemberNotFound
+	}
+	return nil
+}
+
+// ListMembers returns an organization's members
+func (s *Store) ListMembers(orgID int64) ([]Member, error) {
+	rows, err := s.db.Query("SELECT member, role, added_at FROM org_members WHERE org_id = ? ORDER BY member", orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []Member
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.Member, &m.Role, &m.AddedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// SetKeyOrg moves a key and its child keys to an organization; 0 takes
+// them out of theirs
+func (s *Store) SetKeyOrg(id, orgID int64) error {
+	if orgID != 0 {
+		if _, err := s.lookupOrg(orgID); err != nil {
+			return err
+		}
+	}
+	return s.update(id, "UPDATE api_keys SET org_id = ? WHERE id = ? OR parent_id = ?", orgID, id, id)
+}
+
+// ListOrgKeys returns the keys an organization owns, newest first
+func (s *Store) ListOrgKeys(orgID int64) ([]APIKey, error) {
+	rows, err := s.db.Query("SELECT "+keyColumns+" FROM "+keyTables+" WHERE k.org_id = ? ORDER BY k.created_at DESC, k.id DESC", orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// ProvisionKeys issues n keys owned by an organization at once, all on
+// plan with the same scopes. Agent IDs are agentPrefix-1 to
+// agentPrefix-n when agentPrefix is set. Either all keys are issued or
+// none: ErrAgentIDTaken when another key holds one of the agent IDs.
+func (s *Store) ProvisionKeys(orgID int64, n int, agentPrefix, plan string, scopes Scopes) ([]*APIKey, error) {
+	if _, err := s.lookupOrg(orgID); err != nil {
+		return nil, err
+	}
+	if plan == "" {
+		plan = PlanFree
+	}
+	if err := s.checkPlan(plan); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	keys := make([]*APIKey, 0, n)
+	for i := 1; i <= n; i++ {
+		var agentID string
+		if agentPrefix != "" {
+			agentID = fmt.Sprintf("%s-%d", agentPrefix, i)
+		}
+		key := generateRandomKey()
+		result, err := tx.Exec(
+			"INSERT INTO api_keys (key_prefix, key_hash, agent_id, scopes, plan, org_id) VALUES (?, ?, ?, ?, ?, ?)",
+			keyPrefix(key), hashKey(key), agentID, encodeScopes(scopes), plan, orgID,
+		)
+		if err != nil {
+			return nil, agentIDConflict(err)
+		}
+		id, _ := result.LastInsertId()
+		keys = append(keys, &APIKey{
+			ID:        id,
+			Prefix:    keyPrefix(key),
+			Key:       key,
+			AgentID:   agentID,
+			CreatedAt: time.Now(),
+			Status:    StatusActive,
+			Scopes:    scopes,
+			Plan:      plan,
+			UsageKey:  keyPrefix(key),
+			OrgID:     orgID,
+		})
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// updateOrg runs a statement on one organization
+func (s *Store) updateOrg(id int64, query string, args ...interface{}) error {
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrOrgNotFound
+	}
+	return nil
+}
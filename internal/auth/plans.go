@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// Built-in plan names
+const (
+	PlanFree       = "free"
+	PlanPro        = "pro"
+	PlanEnterprise = "enterprise"
+	PlanInternal   = "internal"
+)
+
+// Limits cap a key's traffic. Zero means unlimited.
+type Limits struct {
+	PerSecond int   `json:"per_second"` // sustained requests per second
+	Burst     int   `json:"burst"`      // requests allowed at once
+	Daily     int64 `json:"daily"`
+	Monthly   int64 `json:"monthly"`
+}
+
+// Plan is a named set of limits keys are assigned to
+type Plan struct {
+	Name string `json:"name"`
+	Limits
+}
+
+// LimitOverrides replace single plan limits for one key; nil fields
+// keep the plan's value
+type LimitOverrides struct {
+	PerSecond *int   `json:"per_second,omitempty"`
+	Burst     *int   `json:"burst,omitempty"`
+	Daily     *int64 `json:"daily,omitempty"`
+	Monthly   *int64 `json:"monthly,omitempty"`
+}
+
+// ErrUnknownPlan is returned when assigning a plan that doesn't exist
+var ErrUnknownPlan = fmt.Errorf("unknown plan")
+
+// DefaultPlans are created on first start; admins may change them
+var DefaultPlans = []Plan{
+	{Name: PlanFree, Limits: Limits{PerSecond: 2, Burst: 5, Daily: 10000, Monthly: 100000}},
+	{Name: PlanPro, Limits: Limits{PerSecond: 20, Burst: 50, Daily: 500000, Monthly: 10000000}},
+	{Name: PlanEnterprise, Limits: Limits{PerSecond: 100, Burst: 250}},
+	{Name: PlanInternal},
+}
+
+const plansTable = `
+	CREATE TABLE IF NOT EXISTS plans (
+		name TEXT PRIMARY KEY,
+		per_second INTEGER NOT NULL DEFAULT 0,
+		burst INTEGER NOT NULL DEFAULT 0,
+		daily INTEGER NOT NULL DEFAULT 0,
+		monthly INTEGER NOT NULL DEFAULT 0
+	)`
+
+// seedPlans creates the plans table and any missing built-in plan
+func seedPlans(tx *sql.Tx) error {
+	if _, err := tx.Exec(plansTable); err != nil {
+		return err
+	}
+	for _, p := range DefaultPlans {
+		_, err := tx.Exec(
+			"INSERT OR IGNORE INTO plans (name, per_second, burst, daily, monthly) VALUES (?, ?, ?, ?, ?)",
+			p.Name, p.PerSecond, p.Burst, p.Daily, p.Monthly,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListPlans returns all plans
+func (s *Store) ListPlans() ([]Plan, error) {
+	rows, err := s.db.Query("SELECT name, per_second, burst, daily, monthly FROM plans ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plans []Plan
+	for rows.Next() {
+		var p Plan
+		if err := rows.Scan(&p.Name, &p.PerSecond, &p.Burst, &p.Daily, &p.Monthly); err != nil {
+			return nil, err
+		}
+		plans = append(plans, p)
+	}
+	return plans, rows.Err()
+}
+
+// SavePlan creates or updates a plan. Keys on it pick up the new
+// limits on their next request.
+func (s *Store) SavePlan(p Plan) error {
+	_, err := s.db.Exec(`
+		INSERT INTO plans (name, per_second, burst, daily, monthly) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			per_second = excluded.per_second, burst = excluded.burst,
+			daily = excluded.daily, monthly = excluded.monthly`,
+		p.Name, p.PerSecond, p.Burst, p.Daily, p.Monthly,
+	)
+	return err
+}
+
+// SetPlan moves a key, and its child keys, to another plan
+func (s *Store) SetPlan(id int64, plan string) error {
+	if plan == "" {
+		return ErrUnknownPlan
+	}
+	if err := s.checkPlan(plan); err != nil {
+		return err
+	}
+	return s.update(id, "UPDATE api_keys SET plan = ? WHERE id = ? OR parent_id = ?", plan, id, id)
+}
+
+// checkPlan returns ErrUnknownPlan unless plan exists or is empty
+func (s *Store) checkPlan(plan string) error {
+	if plan == "" {
+		return nil
+	}
+	var exists bool
+	if err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM plans WHERE name = ?)", plan).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUnknownPlan
+	}
+	return nil
+}
+
+// SetOverrides replaces a key's limit overrides; nil clears them
+func (s *Store) SetOverrides(id int64, o *LimitOverrides) error {
+	return s.update(id, "UPDATE api_keys SET limit_overrides = ? WHERE id = ?", encodeOverrides(o), id)
+}
+
+// Within reports whether no limit in l is looser than in parent
+func (l Limits) Within(parent Limits) bool {
+	within := func(v, p int64) bool { return p == 0 || (v > 0 && v <= p) }
+	return within(int64(l.PerSecond), int64(parent.PerSecond)) &&
+		within(int64(l.burst()), int64(parent.burst())) &&
+		within(l.Daily, parent.Daily) &&
+		within(l.Monthly, parent.Monthly)
+}
+
+// burst is the effective burst, which is never below the rate
+func (l Limits) burst() int {
+	if l.PerSecond == 0 {
+		return 0
+	}
+	return max(l.Burst, l.PerSecond)
+}
+
+// apply returns the limits with the overrides that are set
+func (o *LimitOverrides) apply(l Limits) Limits {
+	if o == nil {
+		return l
+	}
+	if o.PerSecond != nil {
+		l.PerSecond = *o.PerSecond
+	}
+	if o.Burst != nil {
+		l.Burst = *o.Burst
+	}
+	if o.Daily != nil {
+		l.Daily = *o.Daily
+	}
+	if o.Monthly != nil {
+		l.Monthly = *o.Monthly
+	}
+	return l
+}
+
+// planLimits reads the joined plan columns of a key row. A key whose
+// plan was removed gets the free plan's defaults.
+func planLimits(perSecond, burst, daily, monthly sql.NullInt64) Limits {
+	if !perSecond.Valid {
+		return DefaultPlans[0].Limits
+	}
+	return Limits{
+		PerSecond: int(perSecond.Int64),
+		Burst:     int(burst.Int64),
+		Daily:     daily.Int64,
+		Monthly:   monthly.Int64,
+	}
+}
+
+func encodeOverrides(o *LimitOverrides) string {
+	if o == nil {
+		return ""
+	}
+	b, _ := json.Marshal(o)
+	return string(b)
+}
+
+func decodeOverrides(raw string) *LimitOverrides {
+	if raw == "" {
+		return nil
+	}
+	var o LimitOverrides
+	if json.Unmarshal([]byte(raw), &o) != nil {
+		return nil
+	}
+	return &o
+}
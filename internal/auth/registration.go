@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Errors returned when registering keys
+var (
+	ErrAgentIDTaken  = fmt.Errorf("agent_id is already registered")
+	ErrInvalidInvite = fmt.Errorf("invalid or used invite code")
+)
+
+// Invite is a code that lets someone register while registration is
+// invite-only
+type Invite struct {
+	ID        int64      `json:"id"`
+	Prefix    string     `json:"prefix"`
+	Code      string     `json:"code,omitempty"` // plaintext, only when created
+	Note      string     `json:"note,omitempty"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+const registrationTables = `
+	CREATE TABLE IF NOT EXISTS settings (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS invites (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code_prefix TEXT NOT NULL,
+		code_hash TEXT UNIQUE NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		max_uses INTEGER NOT NULL DEFAULT 1,
+		uses INTEGER NOT NULL DEFAULT 0,
+		expires_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+// settingInviteOnly names the setting that closes open registration
+const settingInviteOnly = "registration.invite_only"
+
+// InviteOnly reports whether registering needs an invite code
+func (s *Store) InviteOnly() (bool, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM settings WHERE name = ?", settingInviteOnly).Scan(&value)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return value == "true", err
+}
+
+// SetInviteOnly opens or closes registration to everyone
+func (s *Store) SetInviteOnly(on bool) error {
+	_, err := s.db.Exec(
+		"INSERT INTO settings (name, value) VALUES (?, ?) ON CONFLICT(name) DO UPDATE SET value = excluded.value",
+		settingInviteOnly, fmt.Sprint(on),
+	)
+	return err
+}
+
+// rootAgentKey matches the keys an agent_id is unique among: live keys
+// that are neither children nor rotation successors
+const rootAgentKey = "agent_id != '' AND parent_id = 0 AND rotated_from = 0 AND status != 'revoked'"
+
+// agentIDConflict turns a clash on the unique agent_id index into
+// ErrAgentIDTaken
+func agentIDConflict(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique &&
+		strings.Contains(sqliteErr.Error(), "agent_id") {
+		return ErrAgentIDTaken
+	}
+	return err
+}
+
+// AgentIDTaken reports whether a key that isn't revoked is registered
+// to an agent_id, ignoring case
+func (s *Store) AgentIDTaken(agentID string) (bool, error) {
+	var taken bool
+	err := s.db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM api_keys WHERE agent_id = ? COLLATE NOCASE AND status != 'revoked')",
+		agentID,
+	).Scan(&taken)
+	return taken, err
+}
+
+// CreateInvite issues an invite code usable maxUses times
+func (s *Store) CreateInvite(note string, maxUses int, expiresAt *time.Time) (*Invite, error) {
+	if maxUses < 1 {
+		maxUses = 1
+	}
+	code := newInviteCode()
+	var at interface{}
+	if expiresAt != nil {
+		at = expiresAt.UTC()
+	}
+	result, err := s.db.Exec(
+		"INSERT INTO invites (code_prefix, code_hash, note, max_uses, expires_at) VALUES (?, ?, ?, ?, ?)",
+		keyPrefix(code), hashKey(code), note, maxUses, at,
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return &Invite{
+		ID:        id,
+		Prefix:    keyPrefix(code),
+		Code:      code,
+		Note:      note,
+		MaxUses:   maxUses,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// newInviteCode returns "inv_" and 44 hex digits
+func newInviteCode() string {
+	return "inv_" + strings.TrimPrefix(generateRandomKey(), "pfa_")
+}
+
+// ListInvites returns all invite codes, newest first
+func (s *Store) ListInvites() ([]Invite, error) {
+	rows, err := s.db.Query("SELECT id, code_prefix, note, max_uses, uses, expires_at, created_at FROM invites ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []Invite
+	for rows.Next() {
+		var inv Invite
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&inv.ID, &inv.Prefix, &inv.Note, &inv.MaxUses, &inv.Uses, &expiresAt, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			inv.ExpiresAt = &expiresAt.Time
+		}
+		invites = append(invites, inv)
+	}
+	return invites, rows.Err()
+}
+
+// DeleteInvite withdraws an invite code
+func (s *Store) DeleteInvite(id int64) error {
+	result, err := s.db.Exec("DELETE FROM invites WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GenerateInvitedKey takes one use of an invite code and creates a key
+// like GenerateKey in the same transaction, so the use is only taken
+// when the key is created. It returns ErrInvalidInvite for an unknown,
+// used up or expired code.
+func (s *Store) GenerateInvitedKey(code, agentID string, scopes Scopes) (*APIKey, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE invites SET uses = uses + 1 WHERE code_hash = ? AND uses < max_uses AND (expires_at IS NULL OR expires_at > ?)",
+		hashKey(code), time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrInvalidInvite
+	}
+	key, err := generateKey(tx, agentID, scopes)
+	if err != nil {
+		return nil, err
+	}
+	return key, tx.Commit()
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrAlreadyRotated is returned when rotating a key that already has a
+// successor
+var ErrAlreadyRotated = fmt.Errorf("API key has already been rotated")
+
+// Rotate issues a successor to a key with the same owner, scopes, plan
+// and usage counters, and lets the old key expire after grace. Both
+// keys work until then. The successor keeps the old key's own expiry
+// and takes over its child keys and signing secret.
+func (s *Store) Rotate(id int64, grace time.Duration) (*APIKey, error) {
+	old, err := s.GetKey(id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	sunset, err := rotationSunset(old, now, grace)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	key := generateRandomKey()
+	var expiresAt interface{}
+	if old.ExpiresAt != nil {
+		expiresAt = old.ExpiresAt.UTC()
+	}
+	result, err := tx.Exec(`
+		INSERT INTO api_keys (key_prefix, key_hash, agent_id, status, expires_at, scopes, plan, limit_overrides, usage_key, rotated_from, parent_id, label, signing_secret, require_signature, org_id)
+		SELECT ?, ?, agent_id, status, ?, scopes, plan, limit_overrides, ?, id, parent_id, label, signing_secret, require_signature, org_id FROM api_keys WHERE id = ?`,
+		keyPrefix(key), hashKey(key), expiresAt, old.UsageKey, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	newID, _ := result.LastInsertId()
+
+	// The successor check guards against a concurrent rotation
+	result, err = tx.Exec(
+		"UPDATE api_keys SET successor_id = ?, rotated_at = ?, expires_at = ? WHERE id = ? AND successor_id = 0",
+		newID, now, sunset, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrAlreadyRotated
+	}
+	// Child keys belong to the successor from now on
+	if _, err := tx.Exec("UPDATE api_keys SET parent_id = ? WHERE parent_id = ?", newID, id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	successor, err := s.GetKey(newID)
+	if err != nil {
+		return nil, err
+	}
+	successor.Key = key
+	return successor, nil
+}
+
+// rotationSunset returns when a key rotated at now stops working, or
+// why it can't be rotated
+func rotationSunset(old *APIKey, now time.Time, grace time.Duration) (time.Time, error) {
+	switch {
+	case old.Status == StatusRevoked:
+		return time.Time{}, ErrKeyRevoked
+	case old.SuccessorID != 0:
+		return time.Time{}, ErrAlreadyRotated
+	}
+	sunset := now.Add(grace)
+	if old.ExpiresAt != nil && old.ExpiresAt.Before(sunset) {
+		sunset = *old.ExpiresAt
+	}
+	return sunset, nil
+}
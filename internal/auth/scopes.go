@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Scopes restrict what a key may do. Empty lists allow everything, so
+// keys issued before scopes existed keep full access.
+type Scopes struct {
+	Endpoints []string `json:"endpoints,omitempty"` // route names such as "price" or "batch"
+	Classes   []string `json:"classes,omitempty"`   // asset classes such as "crypto"
+	Pairs     []string `json:"pairs,omitempty"`     // codes, "BASE/QUOTE" pairs or bare bases
+}
+
+// Unrestricted reports whether the scopes allow everything
+func (s Scopes) Unrestricted() bool {
+	return len(s.Endpoints) == 0 && len(s.Classes) == 0 && len(s.Pairs) == 0
+}
+
+// AllowsEndpoint reports whether a route may be called
+func (s Scopes) AllowsEndpoint(name string) bool {
+	return len(s.Endpoints) == 0 || contains(s.Endpoints, name)
+}
+
+// AllowsClass reports whether assets of a class may be priced
+func (s Scopes) AllowsClass(class string) bool {
+	return len(s.Classes) == 0 || contains(s.Classes, class)
+}
+
+// AllowsPair reports whether a pair is on the allowlist, by full code,
+// by "BASE/QUOTE" or by base alone
+func (s Scopes) AllowsPair(code, base, quote string) bool {
+	if len(s.Pairs) == 0 {
+		return true
+	}
+	return contains(s.Pairs, code) || contains(s.Pairs, base+"/"+quote) || contains(s.Pairs, base)
+}
+
+// scopeChain returns the scopes a request by the key must pass: its
+// own and, for a child key, its parent's as they are now, since the
+// parent may have been narrowed after the child was issued
+func (k *APIKey) scopeChain() []Scopes {
+	if k.Parent == nil {
+		return []Scopes{k.Scopes}
+	}
+	return []Scopes{k.Scopes, k.Parent.Scopes}
+}
+
+// Unrestricted reports whether the key and its parent allow everything
+func (k *APIKey) Unrestricted() bool {
+	for _, s := range k.scopeChain() {
+		if !s.Unrestricted() {
+			return false
+		}
+	}
+	return true
+}
+
+// AllowsEndpoint reports whether the key and its parent may call a route
+func (k *APIKey) AllowsEndpoint(name string) bool {
+	for _, s := range k.scopeChain() {
+		if !s.AllowsEndpoint(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// AllowsClass reports whether the key and its parent may price a class
+func (k *APIKey) AllowsClass(class string) bool {
+	for _, s := range k.scopeChain() {
+		if !s.AllowsClass(class) {
+			return false
+		}
+	}
+	return true
+}
+
+// AllowsPair reports whether the key and its parent may price a pair
+func (k *APIKey) AllowsPair(code, base, quote string) bool {
+	for _, s := range k.scopeChain() {
+		if !s.AllowsPair(code, base, quote) {
+			return false
+		}
+	}
+	return true
+}
+
+// Within reports whether s allows nothing that parent doesn't
+func (s Scopes) Within(parent Scopes) bool {
+	return within(s.Endpoints, parent.Endpoints) &&
+		within(s.Classes, parent.Classes) &&
+		within(s.Pairs, parent.Pairs)
+}
+
+// within reports whether list is no wider than parent; empty lists
+// allow everything
+func within(list, parent []string) bool {
+	if len(parent) == 0 {
+		return true
+	}
+	if len(list) == 0 {
+		return false
+	}
+	for _, v := range list {
+		if !contains(parent, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeScopes stores scopes as JSON, or empty when unrestricted
+func encodeScopes(s Scopes) string {
+	if s.Unrestricted() {
+		return ""
+	}
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func decodeScopes(raw string) Scopes {
+	var s Scopes
+	if raw != "" {
+		json.Unmarshal([]byte(raw), &s)
+	}
+	return s
+}
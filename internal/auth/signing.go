@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// A signed request names its key by prefix and carries an HMAC-SHA256,
+// keyed with the key's signing secret, over StringToSign. The key
+// itself never goes over the wire. Signing secrets can't be hashed like
+// keys, since checking a signature needs them, so the SQLite store
+// encrypts them with a server-side key and signing is off without one.
+
+var (
+	// ErrNoSigningSecret is returned when requiring signatures from a
+	// key that has no signing secret
+	ErrNoSigningSecret = fmt.Errorf("API key has no signing secret")
+	// ErrSigningDisabled is returned for signing secrets when the store
+	// has no key to encrypt them with
+	ErrSigningDisabled = fmt.Errorf("request signing is not enabled on this server")
+)
+
+// sealedPrefix marks an encrypted signing secret
+const sealedPrefix = "enc:"
+
+// SetSecretKey sets the key signing secrets are encrypted with, and
+// encrypts any stored before there was one. The key must be at least
+// 32 characters; changing it makes existing secrets unusable. Without
+// one, signing secrets can't be issued or checked.
+func (s *Store) SetSecretKey(key string) error {
+	if key == "" {
+		s.secrets = nil
+		return nil
+	}
+	if len(key) < 32 {
+		return fmt.Errorf("the signing secret key must be at least 32 characters")
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return err
+	}
+	if s.secrets, err = cipher.NewGCM(block); err != nil {
+		return err
+	}
+
+	rows, err := s.db.Query("SELECT id, signing_secret FROM api_keys WHERE signing_secret != '' AND signing_secret NOT LIKE ?", sealedPrefix+"%")
+	if err != nil {
+		return err
+	}
+	plain := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var secret string
+		if err := rows.Scan(&id, &secret); err != nil {
+			rows.Close()
+			return err
+		}
+		plain[id] = secret
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, secret := range plain {
+		if _, err := s.db.Exec("UPDATE api_keys SET signing_secret = ? WHERE id = ?", s.seal(secret), id); err != nil {
+			return fmt.Errorf("failed to encrypt signing secrets: %w", err)
+		}
+	}
+	return nil
+}
+
+// seal encrypts a signing secret for storage
+func (s *Store) seal(secret string) string {
+	nonce := make([]byte, s.secrets.NonceSize())
+	rand.Read(nonce)
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(s.secrets.Seal(nonce, nonce, []byte(secret), nil))
+}
+
+// unseal decrypts a stored signing secret
+func (s *Store) unseal(stored string) (string, error) {
+	if s.secrets == nil {
+		return "", ErrSigningDisabled
+	}
+	n := s.secrets.NonceSize()
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if !strings.HasPrefix(stored, sealedPrefix) || err != nil || len(sealed) < n {
+		return "", fmt.Errorf("stored signing secret is malformed")
+	}
+	secret, err := s.secrets.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", fmt.Errorf("stored signing secret can't be decrypted; was the signing secret key changed?")
+	}
+	return string(secret), nil
+}
+
+// NewSigningSecret issues a signing secret for a key, replacing any it
+// had. The secret is only returned here.
+func (s *Store) NewSigningSecret(id int64) (string, error) {
+	if s.secrets == nil {
+		return "", ErrSigningDisabled
+	}
+	secret := newSigningSecret()
+	if err := s.update(id, "UPDATE api_keys SET signing_secret = ? WHERE id = ? AND status != 'revoked'", s.seal(secret), id); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// newSigningSecret returns "pfs_" and 256 random bits in hex
+func newSigningSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return "pfs_" + hex.EncodeToString(b)
+}
+
+// SetRequireSignature makes a key refuse, or accept again, requests
+// that send the key instead of a signature
+func (s *Store) SetRequireSignature(id int64, required bool) error {
+	if required {
+		k, err := s.GetKey(id)
+		if err != nil {
+			return err
+		}
+		if k.SigningSecret == "" {
+			return ErrNoSigningSecret
+		}
+	}
+	return s.update(id, "UPDATE api_keys SET require_signature = ? WHERE id = ?", required, id)
+}
+
+// ValidateSigningKey looks up the key a signed request names by its
+// prefix and checks it like ValidateKey. The signature is checked by
+// the caller with APIKey.VerifySignature.
+func (s *Store) ValidateSigningKey(prefix string) (*APIKey, error) {
+	k, err := s.validate(s.get("k.key_prefix = ?", prefix))
+	if err != nil {
+		return nil, err
+	}
+	if k.SigningSecret == "" {
+		return nil, ErrInvalidKey
+	}
+	if k.SigningSecret, err = s.unseal(k.SigningSecret); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// StringToSign is what a request signature covers: the method, path
+// with query string, timestamp, nonce and hex SHA-256 of the body, one
+// per line
+func StringToSign(method, path, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{strings.ToUpper(method), path, timestamp, nonce, hex.EncodeToString(sum[:])}, "\n")
+}
+
+// Sign returns the hex HMAC-SHA256 of a string to sign
+func Sign(secret, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether signature is the key's signature of
+// stringToSign
+func (k *APIKey) VerifySignature(stringToSign, signature string) bool {
+	if k.SigningSecret == "" {
+		return false
+	}
+	return hmac.Equal([]byte(strings.ToLower(signature)), []byte(Sign(k.SigningSecret, stringToSign)))
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Access tokens are HS256 JWTs exchanged for an API key. They are
+// checked without the database; revocation of the key behind a token is
+// the caller's to check. The header's kid names the signing key, so
+// signing keys can be rotated while tokens signed with the old one are
+// still out.
+
+// tokenIssuer is the iss claim of tokens issued here
+const tokenIssuer = "priceforagent"
+
+// ErrInvalidToken is returned for a malformed, forged or expired token
+var ErrInvalidToken = fmt.Errorf("invalid or expired access token")
+
+// TokenClaims are the claims of an access token
+type TokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"` // the key's ID
+	ID        string `json:"jti"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	KeyPrefix string `json:"key_prefix"`
+	Scopes    Scopes `json:"scopes"`
+	Plan      string `json:"plan"`
+}
+
+// KeyID is the ID of the key a token was exchanged for
+func (c *TokenClaims) KeyID() int64 {
+	id, _ := strconv.ParseInt(c.Subject, 10, 64)
+	return id
+}
+
+// TokenSigningKey is a named HMAC secret tokens are signed with
+type TokenSigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+// Tokens issues and checks access tokens. The first signing key signs;
+// all of them verify.
+type Tokens struct {
+	keys []TokenSigningKey
+	TTL  time.Duration // longest lifetime of a token
+}
+
+// NewTokens creates a token issuer from "kid:secret" pairs separated by
+// commas, newest first. An empty spec gets a random key, which only
+// works with a single replica and doesn't survive restarts.
+func NewTokens(spec string, ttl time.Duration) (*Tokens, error) {
+	t := &Tokens{TTL: ttl}
+	for _, pair := range strings.Split(spec, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kid, secret, ok := strings.Cut(pair, ":")
+		if !ok || kid == "" || len(secret) < 32 {
+			return nil, fmt.Errorf("token signing keys must be kid:secret with a secret of at least 32 characters")
+		}
+		t.keys = append(t.keys, TokenSigningKey{ID: kid, Secret: []byte(secret)})
+	}
+	if len(t.keys) == 0 {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		t.keys = append(t.keys, TokenSigningKey{ID: "ephemeral", Secret: secret})
+	}
+	return t, nil
+}
+
+// Issue returns a token for a key with the given scopes, which live for
+// ttl, capped at t.TTL
+func (t *Tokens) Issue(key *APIKey, scopes Scopes, ttl time.Duration) (string, *TokenClaims) {
+	if ttl <= 0 || ttl > t.TTL {
+		ttl = t.TTL
+	}
+	jti := make([]byte, 12)
+	rand.Read(jti)
+	now := time.Now()
+	claims := &TokenClaims{
+		Issuer:    tokenIssuer,
+		Subject:   strconv.FormatInt(key.ID, 10),
+		ID:        hex.EncodeToString(jti),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		KeyPrefix: key.Prefix,
+		Scopes:    scopes,
+		Plan:      key.Plan,
+	}
+
+	signer := t.keys[0]
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT", "kid": signer.ID})
+	payload, _ := json.Marshal(claims)
+	unsigned := encodeSegment(header) + "." + encodeSegment(payload)
+	return unsigned + "." + encodeSegment(signSegment(signer.Secret, unsigned)), claims
+}
+
+// Parse checks a token's signature and expiry and returns its claims
+func (t *Tokens) Parse(token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var signer *TokenSigningKey
+	for i := range t.keys {
+		if t.keys[i].ID == header.Kid {
+			signer = &t.keys[i]
+		}
+	}
+	if signer == nil || !hmac.Equal(sig, signSegment(signer.Secret, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims TokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Issuer != tokenIssuer || claims.KeyID() == 0 || time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// ValidateKeyID looks up a key by ID and checks it like ValidateKey,
+// for requests authenticated another way
+func (s *Store) ValidateKeyID(id int64) (*APIKey, error) {
+	return s.validate(s.GetKey(id))
+}
+
+func signSegment(secret []byte, unsigned string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
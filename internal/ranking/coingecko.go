@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
@@ -92,3 +93,18 @@ func (c *CoinGecko) GetSymbols(limit int) ([]string, error) {
 
 	return symbols, nil
 }
+
+// FindSymbol returns ranking info for a symbol among the top 100 coins
+func (c *CoinGecko) FindSymbol(symbol string) (*CoinRank, error) {
+	coins, err := c.GetTopCoins(100)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range coins {
+		if strings.EqualFold(coins[i].Symbol, symbol) {
+			return &coins[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%s not in top 100", symbol)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -12,12 +13,70 @@ import (
 // Limiter handles rate limiting using Redis
 type Limiter struct {
 	client *redis.Client
-	limit  int
-	window time.Duration
 }
 
+// Limits are the caps a request is checked against. Zero means
+// unlimited.
+type Limits struct {
+	PerSecond int
+	Burst     int
+	Daily     int64
+	Monthly   int64
+}
+
+// Result is the outcome of Allow
+type Result struct {
+	Allowed          bool
+	Reason           string // "rate", "daily" or "monthly" when denied
+	Remaining        int    // requests left in the burst
+	DailyRemaining   int64
+	MonthlyRemaining int64
+	RetryAfter       time.Duration
+}
+
+// Denial reasons
+const (
+	ReasonRate    = "rate"
+	ReasonDaily   = "daily"
+	ReasonMonthly = "monthly"
+)
+
+// tokenBuckets checks one bucket per key in KEYS. Bucket i refills at
+// ARGV[2i] tokens per second up to ARGV[2i+1]; ARGV[1] is the time in
+// milliseconds. A token is taken from every bucket when all of them
+// have one, and from none otherwise. Returns {index of the first empty
+// bucket or 0, tokens left in each bucket...}.
+var tokenBuckets = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local tokens = {}
+local denied = 0
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[2 * i])
+	local burst = tonumber(ARGV[2 * i + 1])
+	local state = redis.call("HMGET", key, "tokens", "ts")
+	local t = tonumber(state[1]) or burst
+	local ts = tonumber(state[2]) or now
+	tokens[i] = math.min(burst, t + (now - ts) * rate / 1000)
+	if tokens[i] < 1 and denied == 0 then
+		denied = i
+	end
+end
+local out = {denied}
+for i, key in ipairs(KEYS) do
+	if denied == 0 then
+		local rate = tonumber(ARGV[2 * i])
+		local burst = tonumber(ARGV[2 * i + 1])
+		tokens[i] = tokens[i] - 1
+		redis.call("HSET", key, "tokens", tostring(tokens[i]), "ts", now)
+		redis.call("PEXPIRE", key, math.ceil(burst / rate * 1000) + 1000)
+	end
+	out[i + 1] = math.floor(tokens[i])
+end
+return out
+`)
+
 // NewLimiter creates a new rate limiter
-func NewLimiter(redisAddr string, requestsPerSecond int) (*Limiter, error) {
+func NewLimiter(redisAddr string) (*Limiter, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     redisAddr,
 		Password: "",
@@ -30,63 +89,241 @@ func NewLimiter(redisAddr string, requestsPerSecond int) (*Limiter, error) {
 		return nil, fmt.Errorf("redis connection failed: %w", err)
 	}
 
-	return &Limiter{
-		client: client,
-		limit:  requestsPerSecond,
-		window: time.Second,
-	}, nil
+	return &Limiter{client: client}, nil
 }
 
-// Allow checks if a request is allowed for the given key
-func (l *Limiter) Allow(ctx context.Context, key string) (bool, int, error) {
-	now := time.Now().Unix()
-	redisKey := fmt.Sprintf("ratelimit:%s:%d", key, now)
+// Pool is a set of limits and the key whose usage they cap
+type Pool struct {
+	Key    string
+	Limits Limits
+}
 
-	// Increment counter
-	count, err := l.client.Incr(ctx, redisKey).Result()
+// Allow checks a request for the given key against its daily and
+// monthly quotas, then its per-second rate with bursts. Quotas count
+// the key's own requests and those pooled into it.
+func (l *Limiter) Allow(ctx context.Context, key string, limits Limits) (Result, error) {
+	res, _, err := l.AllowAll(ctx, []Pool{{Key: key, Limits: limits}})
+	return res, err
+}
+
+// AllowAll checks a request against several pools at once, such as a
+// key's own limits and those it shares with its parent and
+// organization. Every pool's quotas are checked before any rate bucket,
+// and a token is taken from every bucket or from none, so a request one
+// pool denies costs the others nothing. It returns the result and index
+// of the first pool that denies the request, or the first pool's
+// result when all of them allow it.
+func (l *Limiter) AllowAll(ctx context.Context, pools []Pool) (Result, int, error) {
+	now := time.Now()
+	results := make([]Result, len(pools))
+	for i, p := range pools {
+		res, err := l.quotas(ctx, p.Key, p.Limits, now)
+		if err != nil || !res.Allowed {
+			return res, i, err
+		}
+		results[i] = res
+	}
+
+	var keys []string
+	var bucketed []int // pool index of each key
+	args := []interface{}{now.UnixMilli()}
+	for i, p := range pools {
+		if p.Limits.PerSecond <= 0 {
+			results[i].Remaining = -1
+			continue
+		}
+		burst := p.Limits.Burst
+		if burst < p.Limits.PerSecond {
+			burst = p.Limits.PerSecond
+		}
+		keys = append(keys, fmt.Sprintf("ratelimit:%s", p.Key))
+		bucketed = append(bucketed, i)
+		args = append(args, p.Limits.PerSecond, burst)
+	}
+	if len(keys) == 0 {
+		return results[0], 0, nil
+	}
+	out, err := tokenBuckets.Run(ctx, l.client, keys, args...).Int64Slice()
 	if err != nil {
-		return false, 0, err
+		return results[0], 0, err
+	}
+	for j, i := range bucketed {
+		results[i].Remaining = int(out[j+1])
+	}
+	if out[0] > 0 {
+		i := bucketed[out[0]-1]
+		res := results[i]
+		res.Allowed, res.Reason = false, ReasonRate
+		res.RetryAfter = time.Second / time.Duration(pools[i].Limits.PerSecond)
+		return res, i, nil
 	}
+	return results[0], 0, nil
+}
+
+// quotas checks a key's daily and monthly quotas without counting the
+// request, which IncrementUsage does once it is served
+func (l *Limiter) quotas(ctx context.Context, key string, limits Limits, now time.Time) (Result, error) {
+	res := Result{Allowed: true, DailyRemaining: -1, MonthlyRemaining: -1}
 
-	// Set expiry on first request
-	if count == 1 {
-		l.client.Expire(ctx, redisKey, l.window*2)
+	if limits.Daily > 0 {
+		used, err := l.pooledCount(ctx, "daily", key, now.Format("20060102"))
+		if err != nil {
+			return res, err
+		}
+		res.DailyRemaining = remaining(limits.Daily, used)
+		if res.DailyRemaining == 0 {
+			tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+			res.Allowed, res.Reason, res.RetryAfter = false, ReasonDaily, tomorrow.Sub(now)
+			return res, nil
+		}
 	}
+	if limits.Monthly > 0 {
+		used, err := l.pooledCount(ctx, "monthly", key, now.Format("200601"))
+		if err != nil {
+			return res, err
+		}
+		res.MonthlyRemaining = remaining(limits.Monthly, used)
+		if res.MonthlyRemaining == 0 {
+			next := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+			res.Allowed, res.Reason, res.RetryAfter = false, ReasonMonthly, next.Sub(now)
+			return res, nil
+		}
+	}
+	return res, nil
+}
 
-	remaining := l.limit - int(count)
-	if remaining < 0 {
-		remaining = 0
+func remaining(limit, used int64) int64 {
+	if used >= limit {
+		return 0
 	}
+	return limit - used
+}
 
-	return count <= int64(l.limit), remaining, nil
+// Throttle counts an attempt against a fixed window and reports
+// whether it is within limit, or how long until the window resets
+func (l *Limiter) Throttle(ctx context.Context, key string, limit int64, window time.Duration) (bool, time.Duration, error) {
+	redisKey := fmt.Sprintf("throttle:%s", key)
+	n, err := l.client.Incr(ctx, redisKey).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	if n == 1 {
+		l.client.Expire(ctx, redisKey, window)
+	}
+	if n <= limit {
+		return true, 0, nil
+	}
+	ttl, err := l.client.TTL(ctx, redisKey).Result()
+	if err != nil || ttl < 0 {
+		// Repair a window that lost its expiry
+		l.client.Expire(ctx, redisKey, window)
+		ttl = window
+	}
+	return false, ttl, nil
+}
+
+// Claim marks a one-time token as used until ttl passes, and reports
+// whether this was its first use
+func (l *Limiter) Claim(ctx context.Context, token string, ttl time.Duration) (bool, error) {
+	return l.client.SetNX(ctx, fmt.Sprintf("claimed:%s", token), 1, ttl).Result()
 }
 
 // GlobalLimit is the total API calls allowed across all keys
 const GlobalLimit int64 = 10_000_000
 
+// Requests are counted in two namespaces. usage: counts the requests
+// made with a key, once each, and is what the global stats add up.
+// pool: counts requests made with other keys that share in a key's
+// limits, such as a child key's toward its parent or an organization
+// member's toward the organization.
+const (
+	usageNamespace = "usage"
+	poolNamespace  = "pool"
+)
+
 // IncrementUsage increments the usage counter for tracking
 func (l *Limiter) IncrementUsage(ctx context.Context, key string) error {
-	now := time.Now()
-	
 	// Global usage counter
 	l.client.Incr(ctx, "usage:global:total")
+
+	return l.increment(ctx, usageNamespace, key)
+}
+
+// IncrementPoolUsage counts a request made with another key toward
+// pool's limits without counting it as pool's own
+func (l *Limiter) IncrementPoolUsage(ctx context.Context, pool string) error {
+	return l.increment(ctx, poolNamespace, pool)
+}
+
+func (l *Limiter) increment(ctx context.Context, ns, key string) error {
+	now := time.Now()
 	
 	// Total usage per key
-	l.client.Incr(ctx, fmt.Sprintf("usage:total:%s", key))
+	l.client.Incr(ctx, fmt.Sprintf("%s:total:%s", ns, key))
 	
 	// Daily usage (YYYYMMDD)
-	dayKey := fmt.Sprintf("usage:daily:%s:%s", key, now.Format("20060102"))
+	dayKey := fmt.Sprintf("%s:daily:%s:%s", ns, key, now.Format("20060102"))
 	l.client.Incr(ctx, dayKey)
 	l.client.Expire(ctx, dayKey, 8*24*time.Hour) // Keep 8 days
 	
+	// Monthly usage (YYYYMM) for monthly quotas
+	monthKey := fmt.Sprintf("%s:monthly:%s:%s", ns, key, now.Format("200601"))
+	l.client.Incr(ctx, monthKey)
+	l.client.Expire(ctx, monthKey, 32*24*time.Hour)
+	
 	// Hourly usage for last 24h tracking
-	hourKey := fmt.Sprintf("usage:hourly:%s:%s", key, now.Format("2006010215"))
+	hourKey := fmt.Sprintf("%s:hourly:%s:%s", ns, key, now.Format("2006010215"))
 	l.client.Incr(ctx, hourKey)
 	l.client.Expire(ctx, hourKey, 25*time.Hour)
 	
 	return nil
 }
 
+// moveCounter adds the counter at KEYS[1] to KEYS[2] and deletes it,
+// in one step so increments racing the move aren't lost. KEYS[2] keeps
+// the longer of the two expiries. Returns the count moved.
+var moveCounter = redis.NewScript(`
+local count = tonumber(redis.call("GET", KEYS[1]))
+if not count then
+	return 0
+end
+local ttl = redis.call("PTTL", KEYS[1])
+redis.call("INCRBY", KEYS[2], count)
+if ttl > 0 and redis.call("PTTL", KEYS[2]) < ttl then
+	redis.call("PEXPIRE", KEYS[2], ttl)
+end
+redis.call("DEL", KEYS[1])
+return count
+`)
+
+// MoveUsage adds the usage counters recorded under one key to another,
+// keeping their expiry, and deletes the originals. Period counters are
+// found with SCAN, which doesn't block Redis the way KEYS does.
+func (l *Limiter) MoveUsage(ctx context.Context, from, to string) error {
+	moves := make(map[string]string)
+	for _, ns := range []string{usageNamespace, poolNamespace} {
+		moves[fmt.Sprintf("%s:total:%s", ns, from)] = fmt.Sprintf("%s:total:%s", ns, to)
+		for _, period := range []string{"daily", "monthly", "hourly"} {
+			prefix := fmt.Sprintf("%s:%s:%s:", ns, period, from)
+			iter := l.client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+			for iter.Next(ctx) {
+				k := iter.Val()
+				moves[k] = fmt.Sprintf("%s:%s:%s:%s", ns, period, to, strings.TrimPrefix(k, prefix))
+			}
+			if err := iter.Err(); err != nil {
+				return err
+			}
+		}
+	}
+
+	for src, dst := range moves {
+		if err := moveCounter.Run(ctx, l.client, []string{src, dst}).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // CheckGlobalLimit checks if global limit is exceeded
 func (l *Limiter) CheckGlobalLimit(ctx context.Context) (bool, int64, error) {
 	count, err := l.client.Get(ctx, "usage:global:total").Int64()
@@ -131,14 +368,41 @@ func (l *Limiter) GetDailyUsage(ctx context.Context, key string, date time.Time)
 	return count, err
 }
 
+// GetMonthlyUsage returns usage for the month of date
+func (l *Limiter) GetMonthlyUsage(ctx context.Context, key string, date time.Time) (int64, error) {
+	monthKey := fmt.Sprintf("usage:monthly:%s:%s", key, date.Format("200601"))
+	count, err := l.client.Get(ctx, monthKey).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return count, err
+}
+
+// pooledCount adds a key's own count for a period to its pooled one
+func (l *Limiter) pooledCount(ctx context.Context, period, key, stamp string) (int64, error) {
+	var total int64
+	for _, ns := range []string{usageNamespace, poolNamespace} {
+		count, err := l.client.Get(ctx, fmt.Sprintf("%s:%s:%s:%s", ns, period, key, stamp)).Int64()
+		if err != nil && err != redis.Nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
 // GetLast24HoursUsage returns usage for last 24 hours
 func (l *Limiter) GetLast24HoursUsage(ctx context.Context, key string) (int64, error) {
+	return l.last24Hours(ctx, usageNamespace, key)
+}
+
+func (l *Limiter) last24Hours(ctx context.Context, ns, key string) (int64, error) {
 	var total int64
 	now := time.Now()
 	
 	for i := 0; i < 24; i++ {
 		t := now.Add(-time.Duration(i) * time.Hour)
-		hourKey := fmt.Sprintf("usage:hourly:%s:%s", key, t.Format("2006010215"))
+		hourKey := fmt.Sprintf("%s:hourly:%s:%s", ns, key, t.Format("2006010215"))
 		count, err := l.client.Get(ctx, hourKey).Int64()
 		if err == nil {
 			total += count
@@ -150,12 +414,16 @@ func (l *Limiter) GetLast24HoursUsage(ctx context.Context, key string) (int64, e
 
 // GetLast7DaysUsage returns usage for last 7 days
 func (l *Limiter) GetLast7DaysUsage(ctx context.Context, key string) (int64, error) {
+	return l.last7Days(ctx, usageNamespace, key)
+}
+
+func (l *Limiter) last7Days(ctx context.Context, ns, key string) (int64, error) {
 	var total int64
 	now := time.Now()
 	
 	for i := 0; i < 7; i++ {
 		t := now.AddDate(0, 0, -i)
-		dayKey := fmt.Sprintf("usage:daily:%s:%s", key, t.Format("20060102"))
+		dayKey := fmt.Sprintf("%s:daily:%s:%s", ns, key, t.Format("20060102"))
 		count, err := l.client.Get(ctx, dayKey).Int64()
 		if err == nil {
 			total += count
@@ -232,12 +500,16 @@ type DailyBreakdown struct {
 
 // GetDailyBreakdown returns daily stats for last N days
 func (l *Limiter) GetDailyBreakdown(ctx context.Context, key string, days int) ([]DailyBreakdown, error) {
+	return l.dailyBreakdown(ctx, usageNamespace, key, days)
+}
+
+func (l *Limiter) dailyBreakdown(ctx context.Context, ns, key string, days int) ([]DailyBreakdown, error) {
 	var breakdown []DailyBreakdown
 	now := time.Now()
 	
 	for i := days - 1; i >= 0; i-- {
 		t := now.AddDate(0, 0, -i)
-		dayKey := fmt.Sprintf("usage:daily:%s:%s", key, t.Format("20060102"))
+		dayKey := fmt.Sprintf("%s:daily:%s:%s", ns, key, t.Format("20060102"))
 		count, _ := l.client.Get(ctx, dayKey).Int64()
 		
 		breakdown = append(breakdown, DailyBreakdown{
@@ -249,6 +521,29 @@ func (l *Limiter) GetDailyBreakdown(ctx context.Context, key string, days int) (
 	return breakdown, nil
 }
 
+// PoolUsage is what the keys sharing a pool have used of it
+type PoolUsage struct {
+	Total     int64            `json:"total"`
+	Last24h   int64            `json:"last_24h"`
+	Last7Days int64            `json:"last_7_days"`
+	Daily     []DailyBreakdown `json:"daily_breakdown"`
+}
+
+// GetPoolUsage returns the usage pooled into pool, with a daily
+// breakdown for the last N days
+func (l *Limiter) GetPoolUsage(ctx context.Context, pool string, days int) (PoolUsage, error) {
+	var usage PoolUsage
+	total, err := l.client.Get(ctx, fmt.Sprintf("%s:total:%s", poolNamespace, pool)).Int64()
+	if err != nil && err != redis.Nil {
+		return usage, err
+	}
+	usage.Total = total
+	usage.Last24h, _ = l.last24Hours(ctx, poolNamespace, pool)
+	usage.Last7Days, _ = l.last7Days(ctx, poolNamespace, pool)
+	usage.Daily, _ = l.dailyBreakdown(ctx, poolNamespace, pool, days)
+	return usage, nil
+}
+
 // GetGlobalDailyBreakdown returns overall daily stats
 func (l *Limiter) GetGlobalDailyBreakdown(ctx context.Context, days int) ([]DailyBreakdown, error) {
 	var breakdown []DailyBreakdown
@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testLimiter connects to the Redis at REDIS_ADDR, or skips the test
+// when there is none
+func testLimiter(t *testing.T) *Limiter {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	l, err := NewLimiter(addr)
+	if err != nil {
+		t.Skipf("no Redis at %s: %v", addr, err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestClaim(t *testing.T) {
+	l := testLimiter(t)
+	ctx := context.Background()
+	nonce := "nonce:test:" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	t.Cleanup(func() { l.client.Del(ctx, "claimed:"+nonce, "claimed:"+nonce+"-2") })
+
+	tests := []struct {
+		name  string
+		token string
+		first bool
+	}{
+		{"first use", nonce, true},
+		{"replay", nonce, false},
+		{"replay again", nonce, false},
+		{"another nonce", nonce + "-2", true},
+	}
+	for _, tt := range tests {
+		first, err := l.Claim(ctx, tt.token, time.Minute)
+		if err != nil {
+			t.Fatalf("%s: Claim: %v", tt.name, err)
+		}
+		if first != tt.first {
+			t.Errorf("%s: Claim = %v, want %v", tt.name, first, tt.first)
+		}
+	}
+
+	// A claim lasts as long as asked, so a nonce stays used for the
+	// whole window its timestamp is accepted in
+	if ttl := l.client.TTL(ctx, "claimed:"+nonce).Val(); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("claim TTL = %v, want up to a minute", ttl)
+	}
+}
+
+func TestAllowAll(t *testing.T) {
+	l := testLimiter(t)
+	ctx := context.Background()
+	prefix := "test:" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	own := Pool{Key: prefix + ":key", Limits: Limits{PerSecond: 1, Burst: 5}}
+	shared := Pool{Key: prefix + ":org", Limits: Limits{PerSecond: 1, Burst: 2}}
+	t.Cleanup(func() { l.client.Del(ctx, "ratelimit:"+own.Key, "ratelimit:"+shared.Key) })
+
+	// The shared pool runs dry after two requests
+	for i := 0; i < 2; i++ {
+		res, denying, err := l.AllowAll(ctx, []Pool{own, shared})
+		if err != nil {
+			t.Fatalf("AllowAll: %v", err)
+		}
+		if !res.Allowed || denying != 0 || res.Remaining != 4-i {
+			t.Errorf("request %d = %+v from pool %d, want allowed with %d left", i, res, denying, 4-i)
+		}
+	}
+	res, denying, err := l.AllowAll(ctx, []Pool{own, shared})
+	if err != nil {
+		t.Fatalf("AllowAll: %v", err)
+	}
+	if res.Allowed || denying != 1 || res.Reason != ReasonRate {
+		t.Errorf("third request = %+v from pool %d, want denied by pool 1", res, denying)
+	}
+
+	// The denied request took nothing from the key's own bucket
+	res, err = l.Allow(ctx, own.Key, own.Limits)
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if !res.Allowed || res.Remaining != 2 {
+		t.Errorf("own bucket after the denial = %+v, want 2 tokens left after this request", res)
+	}
+}
+
+func TestMoveUsage(t *testing.T) {
+	l := testLimiter(t)
+	ctx := context.Background()
+	id := strconv.FormatInt(time.Now().UnixNano(), 36)
+	from, to := "test-from-"+id, "test-to-"+id
+	day := time.Now().Format("20060102")
+	t.Cleanup(func() {
+		for _, key := range []string{from, to} {
+			for _, ns := range []string{usageNamespace, poolNamespace} {
+				iter := l.client.Scan(ctx, 0, ns+":*"+key+"*", 100).Iterator()
+				for iter.Next(ctx) {
+					l.client.Del(ctx, iter.Val())
+				}
+			}
+		}
+	})
+
+	for i := 0; i < 3; i++ {
+		l.IncrementUsage(ctx, from)
+	}
+	l.IncrementPoolUsage(ctx, from)
+	l.IncrementUsage(ctx, to)
+	if err := l.MoveUsage(ctx, from, to); err != nil {
+		t.Fatalf("MoveUsage: %v", err)
+	}
+
+	counts := []struct {
+		key  string
+		want int64
+	}{
+		{"usage:total:" + to, 4},
+		{"usage:daily:" + to + ":" + day, 4},
+		{"pool:daily:" + to + ":" + day, 1},
+		{"usage:total:" + from, 0},
+		{"usage:daily:" + from + ":" + day, 0},
+	}
+	for _, c := range counts {
+		got, _ := l.client.Get(ctx, c.key).Int64()
+		if got != c.want {
+			t.Errorf("%s = %d, want %d", c.key, got, c.want)
+		}
+	}
+	if ttl := l.client.TTL(ctx, "usage:daily:"+to+":"+day).Val(); ttl <= 0 {
+		t.Errorf("moved daily counter TTL = %v, want it kept", ttl)
+	}
+}